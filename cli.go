@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"tinyauth-sidecar/internal/config"
+)
+
+const usage = `Usage:
+  tinyauth-sidecar                          start the server
+  tinyauth-sidecar config check [--json] [path]
+                                            validate config.toml (default: $CONFIG_PATH)
+`
+
+// runCommand handles CLI subcommands and returns the process exit code.
+func runCommand(args []string) int {
+	if len(args) >= 2 && args[0] == "config" && args[1] == "check" {
+		return runConfigCheck(args[2:])
+	}
+	fmt.Fprint(os.Stderr, usage)
+	return 2
+}
+
+// runConfigCheck validates a config file and prints the problems found.
+// Exits 0 when the config is valid, 1 otherwise.
+func runConfigCheck(args []string) int {
+	asJSON := false
+	path := config.ConfigPath()
+	for _, a := range args {
+		switch a {
+		case "--json":
+			asJSON = true
+		case "-h", "--help":
+			fmt.Fprint(os.Stderr, usage)
+			return 0
+		default:
+			path = a
+		}
+	}
+
+	_, errs := config.CheckFileConfig(path)
+
+	if asJSON {
+		if errs == nil {
+			errs = []config.ValidationError{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(map[string]any{"path": path, "valid": len(errs) == 0, "errors": errs})
+	} else if len(errs) == 0 {
+		fmt.Printf("%s: OK\n", path)
+	} else {
+		for _, e := range errs {
+			fmt.Printf("%s: %v\n", path, e)
+		}
+		fmt.Printf("%d problem(s) found\n", len(errs))
+	}
+
+	if len(errs) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -1,5 +1,7 @@
 import axios from 'axios'
 
+import i18n from '../i18n'
+
 export const api = axios.create({
   baseURL: '/manage/api',
   withCredentials: true,
@@ -11,5 +13,17 @@ api.interceptors.request.use((config) => {
   if (match) {
     config.headers['X-CSRF-Token'] = match[1]
   }
+  // Mails are sent in the UI language unless the user picked one in their profile
+  if (i18n.resolvedLanguage) {
+    config.headers['Accept-Language'] = i18n.resolvedLanguage
+  }
   return config
 })
+
+// Sensitive changes need a recent re-authentication; let the page ask for it
+api.interceptors.response.use(undefined, (error) => {
+  if (error?.response?.status === 403 && error.response.data?.error === 'reauth_required') {
+    window.dispatchEvent(new Event('reauth-required'))
+  }
+  return Promise.reject(error)
+})
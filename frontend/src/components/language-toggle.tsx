@@ -1,5 +1,7 @@
 import { useState } from 'react'
 import { useTranslation } from 'react-i18next'
+
+import { api } from '../api/client'
 import {
   Select,
   SelectContent,
@@ -22,6 +24,8 @@ export const LanguageSelector = () => {
   const handleSelect = (option: string) => {
     setLanguage(option)
     void i18n.changeLanguage(option)
+    // Remember the choice for emails; fails silently when not logged in
+    api.post('/account/language', { language: option }).catch(() => {})
   }
 
   return (
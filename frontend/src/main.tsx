@@ -9,6 +9,7 @@ import { ProtectedRoute } from './components/ProtectedRoute'
 import { SmartRedirect } from './components/SmartRedirect'
 import ResetPasswordPage from './pages/ResetPasswordPage'
 import AccountPage from './pages/AccountPage'
+import VerifyEmailPage from './pages/VerifyEmailPage'
 import './i18n'
 import './index.css'
 
@@ -22,6 +23,7 @@ ReactDOM.createRoot(document.getElementById('root')!).render(
             <Routes>
               <Route path='/' element={<SmartRedirect />} />
               <Route path='/reset-password' element={<ResetPasswordPage />} />
+              <Route path='/verify-email' element={<VerifyEmailPage />} />
               <Route path='/account' element={
                 <ProtectedRoute>
                   <AccountPage />
@@ -7,19 +7,50 @@ import { AnimatedHeight } from '@/components/AnimatedHeight'
 import { Input } from '@/components/ui/input'
 import { Label } from '@/components/ui/label'
 import { Tabs, TabsContent, TabsList, TabsTrigger } from '@/components/ui/tabs'
+import { Select, SelectContent, SelectItem, SelectTrigger, SelectValue } from '@/components/ui/select'
 import { PasswordStrengthBar } from '@/components/PasswordStrengthBar'
 import { Copy, Check, ShieldCheck, ShieldAlert, User, Lock, Shield, Settings, CheckCircle, XCircle, RefreshCw } from 'lucide-react'
 
 import { useFeatures } from '@/context/FeaturesContext'
 
+type HookDelivery = {
+  id: string
+  hook: string
+  username: string
+  attempts: number
+  lastError?: string
+  failed: boolean
+}
+
+type SMSProviderStatus = {
+  name: string
+  state: 'closed' | 'open' | 'half-open'
+  lastError?: string
+}
+
+type SMSMessage = {
+  id: string
+  provider: string
+  to: string
+  status: 'sent' | 'delivered' | 'failed'
+  detail?: string
+  createdAt: number
+}
+
 type Profile = {
   username: string
   totpEnabled: boolean
   phone?: string
+  pendingPhone?: string
   email?: string
+  pendingEmail?: string
   role?: string
+  otpMethod?: 'sms' | 'email' | ''
+  otpMethods?: ('sms' | 'email')[]
 }
 
+const stepUpErrors = ['reauth_required', 'invalid_credentials', 'otp_required', 'invalid_otp', 'code_recently_sent', 'otp_method_unavailable', 'otp_not_enabled']
+
 function CopyButton({ value }: { value: string }) {
   const [copied, setCopied] = useState(false)
   return (
@@ -38,6 +69,30 @@ function CopyButton({ value }: { value: string }) {
   )
 }
 
+// StepUpCode asks for a code from the user's second factor when re-authenticating.
+function StepUpCode({ value, onChange, onSend }: { value: string; onChange: (v: string) => void; onSend: () => void }) {
+  const { t } = useTranslation()
+  return (
+    <div className="grid gap-2">
+      <Label htmlFor="stepUpCode">{t('accountPage.otpCode')}</Label>
+      <div className="flex flex-wrap gap-2">
+        <Input
+          id="stepUpCode"
+          inputMode="numeric"
+          autoComplete="one-time-code"
+          value={value}
+          onChange={(e) => onChange(e.target.value)}
+          className="flex-1 min-w-[120px]"
+        />
+        <Button variant="outline" onClick={onSend}>
+          {t('accountPage.otpSendCode')}
+        </Button>
+      </div>
+      <p className="text-xs text-muted-foreground">{t('accountPage.otpCodeHint')}</p>
+    </div>
+  )
+}
+
 export default function AccountPage() {
   const { t } = useTranslation()
   const features = useFeatures()
@@ -46,6 +101,7 @@ export default function AccountPage() {
 
   // Profile fields
   const [phone, setPhone] = useState('')
+  const [phoneCode, setPhoneCode] = useState('')
   const [profileEmail, setProfileEmail] = useState('')
 
   // Password fields
@@ -59,19 +115,29 @@ export default function AccountPage() {
   const [qrPng, setQrPng] = useState('')
   const [otpUrl, setOtpUrl] = useState('')
   const [disablePassword, setDisablePassword] = useState('')
+  const [otpCode, setOtpCode] = useState('')
+  // Re-authentication, asked for when a sensitive change returns reauth_required
+  const [reauthNeeded, setReauthNeeded] = useState(false)
+  const [reauthPassword, setReauthPassword] = useState('')
+  const [reauthTotp, setReauthTotp] = useState('')
+  const [otpSetupMethod, setOtpSetupMethod] = useState<'sms' | 'email' | ''>('')
+  const [otpEnrollCode, setOtpEnrollCode] = useState('')
   const [showTotpSetup, setShowTotpSetup] = useState(false)
   const [totpLoading, setTotpLoading] = useState(false)
   const [changingPassword, setChangingPassword] = useState(false)
 
   // Admin fields
-  const [adminStatus, setAdminStatus] = useState<{ email: boolean; sms: boolean; usernameIsEmail: boolean; userCount: number } | null>(null)
+  const [adminStatus, setAdminStatus] = useState<{ email: boolean; sms: boolean; smsProviders?: SMSProviderStatus[]; usernameIsEmail: boolean; userCount: number } | null>(null)
   const [testEmailTo, setTestEmailTo] = useState('')
   const [testSmsTo, setTestSmsTo] = useState('')
+  const [testSmsProvider, setTestSmsProvider] = useState('auto')
   const [testEmailMsg, setTestEmailMsg] = useState('')
   const [testSmsMsg, setTestSmsMsg] = useState('')
   const [reloadMsg, setReloadMsg] = useState('')
   const [tinyauthUp, setTinyauthUp] = useState<boolean | null>(null)
   const [restarting, setRestarting] = useState(false)
+  const [deliveries, setDeliveries] = useState<HookDelivery[]>([])
+  const [smsMessages, setSmsMessages] = useState<SMSMessage[]>([])
 
   const load = async () => {
     try {
@@ -88,11 +154,23 @@ export default function AccountPage() {
     void load()
   }, [])
 
+  useEffect(() => {
+    const onReauth = () => setReauthNeeded(true)
+    window.addEventListener('reauth-required', onReauth)
+    return () => window.removeEventListener('reauth-required', onReauth)
+  }, [])
+
+  const loadDeliveries = () => {
+    api.get('/admin/hook-deliveries').then((res) => setDeliveries(res.data.deliveries || [])).catch(() => {})
+  }
+
   // Load admin status when profile indicates admin role
   useEffect(() => {
     if (profile?.role === 'admin') {
       api.get('/admin/status').then((res) => setAdminStatus(res.data)).catch(() => {})
       api.get('/admin/tinyauth-health').then((res) => setTinyauthUp(res.data.running)).catch(() => setTinyauthUp(false))
+      loadDeliveries()
+      api.get('/admin/sms-messages').then((res) => setSmsMessages(res.data.messages || [])).catch(() => {})
     }
   }, [profile?.role])
 
@@ -113,6 +191,33 @@ export default function AccountPage() {
     return () => clearInterval(interval)
   }, [restarting])
 
+  const errorMsg = (e: any, known: string[] = []) => {
+    const err = e?.response?.data?.error
+    return [...known, ...stepUpErrors].includes(err) ? t(`accountPage.${err}`) : err || t('accountPage.genericError')
+  }
+
+  const sendStepUpCode = async () => {
+    try {
+      await api.post('/account/otp/send')
+      setMsg(profile?.otpMethod === 'email' ? t('accountPage.otpCodeSentEmail') : t('accountPage.otpCodeSentSms'))
+    } catch (e: any) {
+      setMsg(errorMsg(e))
+    }
+  }
+
+  const reauthenticate = async () => {
+    try {
+      await api.post('/account/reauth', { password: reauthPassword, totp: reauthTotp, otp: otpCode })
+      setReauthNeeded(false)
+      setReauthPassword('')
+      setReauthTotp('')
+      setOtpCode('')
+      setMsg(t('accountPage.reauthSuccess'))
+    } catch (e: any) {
+      setMsg(errorMsg(e))
+    }
+  }
+
   const startTotpSetup = async () => {
     setTotpLoading(true)
     try {
@@ -138,6 +243,42 @@ export default function AccountPage() {
         <AnimatedHeight>
         {msg && <div className="mb-4 rounded-md border bg-muted px-3 py-2 text-sm">{msg}</div>}
 
+        {profile && reauthNeeded && (
+          <div className="mb-4 grid gap-3 rounded-md border p-3">
+            <h3 className="font-medium">{t('accountPage.reauthTitle')}</h3>
+            <p className="text-sm text-muted-foreground">{t('accountPage.reauthDescription')}</p>
+            <div className="grid gap-2">
+              <Label htmlFor="reauthPassword">{t('common.password')}</Label>
+              <Input
+                id="reauthPassword"
+                type="password"
+                autoComplete="current-password"
+                value={reauthPassword}
+                onChange={(e) => setReauthPassword(e.target.value)}
+              />
+            </div>
+            {profile.totpEnabled && (
+              <div className="grid gap-2">
+                <Label htmlFor="reauthTotp">{t('accountPage.reauthTotp')}</Label>
+                <Input
+                  id="reauthTotp"
+                  inputMode="numeric"
+                  autoComplete="one-time-code"
+                  value={reauthTotp}
+                  onChange={(e) => setReauthTotp(e.target.value)}
+                />
+              </div>
+            )}
+            {profile.otpMethod && <StepUpCode value={otpCode} onChange={setOtpCode} onSend={sendStepUpCode} />}
+            <div className="flex gap-2">
+              <Button onClick={reauthenticate}>{t('accountPage.reauthConfirm')}</Button>
+              <Button variant="outline" onClick={() => setReauthNeeded(false)}>
+                {t('common.cancel')}
+              </Button>
+            </div>
+          </div>
+        )}
+
         {profile && (
           <Tabs defaultValue="profile">
             <TabsList>
@@ -176,21 +317,52 @@ export default function AccountPage() {
                     onChange={(e) => setPhone(e.target.value)}
                     placeholder="+31612345678"
                   />
+                  {profile.pendingPhone && (
+                    <p className="text-sm text-muted-foreground">{t('accountPage.phonePending', { phone: profile.pendingPhone })}</p>
+                  )}
                 </div>
                 <Button
                   variant="outline"
                   onClick={async () => {
                     try {
-                      await api.post('/account/phone', { phone })
-                      setMsg(t('accountPage.phoneUpdated'))
+                      const res = (await api.post('/account/phone', { phone })).data
+                      setMsg(res.pending ? t('accountPage.phoneCodeSent') : t('accountPage.phoneUpdated'))
                       void load()
                     } catch (e: any) {
-                      setMsg(e?.response?.data?.error || t('accountPage.genericError'))
+                      setMsg(errorMsg(e, ['invalid_phone', 'phone_in_use']))
                     }
                   }}
                 >
                   {t('common.save')}
                 </Button>
+                {profile.pendingPhone && (
+                  <>
+                    <div className="grid gap-2">
+                      <Label htmlFor="phoneCode">{t('common.code')}</Label>
+                      <Input
+                        id="phoneCode"
+                        inputMode="numeric"
+                        autoComplete="one-time-code"
+                        value={phoneCode}
+                        onChange={(e) => setPhoneCode(e.target.value)}
+                      />
+                    </div>
+                    <Button
+                      onClick={async () => {
+                        try {
+                          await api.post('/account/phone/verify', { code: phoneCode })
+                          setMsg(t('accountPage.phoneUpdated'))
+                          setPhoneCode('')
+                          void load()
+                        } catch (e: any) {
+                          setMsg(e?.response?.data?.error || t('accountPage.genericError'))
+                        }
+                      }}
+                    >
+                      {t('accountPage.confirmPhone')}
+                    </Button>
+                  </>
+                )}
                 {!features.usernameIsEmail && (
                   <>
                     <div className="grid gap-2">
@@ -202,16 +374,24 @@ export default function AccountPage() {
                         onChange={(e) => setProfileEmail(e.target.value)}
                         placeholder="user@example.com"
                       />
+                      {profile?.pendingEmail ? (
+                        <p className="text-sm text-muted-foreground">{t('accountPage.emailPending', { email: profile.pendingEmail })}</p>
+                      ) : profile?.email ? (
+                        <p className="flex items-center gap-1 text-sm text-muted-foreground">
+                          <CheckCircle className="h-4 w-4 text-green-500" />{t('accountPage.emailVerified')}
+                        </p>
+                      ) : null}
                     </div>
                     <Button
                       variant="outline"
                       onClick={async () => {
                         try {
                           await api.post('/account/email', { email: profileEmail })
-                          setMsg(t('accountPage.emailUpdated'))
+                          const changed = profileEmail.trim() !== '' && profileEmail.trim().toLowerCase() !== (profile?.email || '').toLowerCase()
+                          setMsg(changed ? t('accountPage.emailVerificationSent', { email: profileEmail.trim() }) : t('accountPage.emailUpdated'))
                           void load()
                         } catch (e: any) {
-                          setMsg(e?.response?.data?.error || t('accountPage.genericError'))
+                          setMsg(errorMsg(e, ['invalid_email', 'email_in_use']))
                         }
                       }}
                     >
@@ -379,7 +559,7 @@ export default function AccountPage() {
                           setDisablePassword('')
                           void load()
                         } catch (e: any) {
-                          setMsg(e?.response?.data?.error || t('accountPage.genericError'))
+                          setMsg(errorMsg(e))
                         } finally {
                           setRestarting(false)
                           setTinyauthUp(true)
@@ -390,6 +570,85 @@ export default function AccountPage() {
                     </Button>
                   </div>
                 )}
+
+                {/* Sidecar second factor (SMS or email codes) */}
+                {(profile.otpMethod || (profile.otpMethods && profile.otpMethods.length > 0)) && (
+                  <div className="grid gap-3 border-t pt-4">
+                    <h3 className="font-medium">{t('accountPage.otpTitle')}</h3>
+                    <p className="text-sm text-muted-foreground">{t('accountPage.otpDescription')}</p>
+                    {profile.otpMethod ? (
+                      <>
+                        <div className="flex items-center gap-2">
+                          <ShieldCheck className="h-5 w-5 text-green-500" />
+                          <span className="text-sm font-medium">{t(`accountPage.otpEnabled_${profile.otpMethod}`)}</span>
+                        </div>
+                        <Button
+                          variant="destructive"
+                          onClick={async () => {
+                            try {
+                              await api.post('/account/otp/disable')
+                              setMsg(t('accountPage.otpDisabledSuccess'))
+                              void load()
+                            } catch (e: any) {
+                              setMsg(errorMsg(e))
+                            }
+                          }}
+                        >
+                          {t('accountPage.otpDisable')}
+                        </Button>
+                      </>
+                    ) : (
+                      <>
+                        <div className="flex flex-wrap gap-2">
+                          {profile.otpMethods?.map((m) => (
+                            <Button
+                              key={m}
+                              variant="outline"
+                              onClick={async () => {
+                                try {
+                                  await api.post('/account/otp/setup', { method: m })
+                                  setOtpSetupMethod(m)
+                                  setMsg(m === 'email' ? t('accountPage.otpCodeSentEmail') : t('accountPage.otpCodeSentSms'))
+                                } catch (e: any) {
+                                  setMsg(errorMsg(e))
+                                }
+                              }}
+                            >
+                              {t(`accountPage.otpUse_${m}`)}
+                            </Button>
+                          ))}
+                        </div>
+                        {otpSetupMethod && (
+                          <div className="flex flex-wrap gap-2">
+                            <Input
+                              inputMode="numeric"
+                              autoComplete="one-time-code"
+                              value={otpEnrollCode}
+                              onChange={(e) => setOtpEnrollCode(e.target.value)}
+                              placeholder={t('common.code')}
+                              className="flex-1 min-w-[120px]"
+                            />
+                            <Button
+                              onClick={async () => {
+                                try {
+                                  await api.post('/account/otp/enable', { method: otpSetupMethod, code: otpEnrollCode })
+                                  setMsg(t('accountPage.otpEnabledSuccess'))
+                                  setOtpSetupMethod('')
+                                  setOtpEnrollCode('')
+                                  void load()
+                                } catch (e: any) {
+                                  setMsg(errorMsg(e))
+                                }
+                              }}
+                            >
+                              {t('common.enable')}
+                            </Button>
+                          </div>
+                        )}
+                      </>
+                    )}
+                  </div>
+                )}
               </div>
             </TabsContent>
 
@@ -506,11 +765,29 @@ export default function AccountPage() {
                             placeholder="+31612345678"
                             className="flex-1 min-w-[180px]"
                           />
+                          {(adminStatus.smsProviders?.length ?? 0) > 1 && (
+                            <div className="min-w-[160px]">
+                              <Select value={testSmsProvider} onValueChange={(v) => { setTestSmsProvider(v); setTestSmsMsg('') }}>
+                                <SelectTrigger>
+                                  <SelectValue />
+                                </SelectTrigger>
+                                <SelectContent>
+                                  <SelectItem value="auto">{t('accountPage.smsProviderAuto')}</SelectItem>
+                                  {adminStatus.smsProviders!.map((p) => (
+                                    <SelectItem key={p.name} value={p.name}>{p.name}</SelectItem>
+                                  ))}
+                                </SelectContent>
+                              </Select>
+                            </div>
+                          )}
                           <Button
                             variant="outline"
                             onClick={async () => {
                               try {
-                                await api.post('/admin/test-sms', { to: testSmsTo })
+                                await api.post('/admin/test-sms', {
+                                  to: testSmsTo,
+                                  provider: testSmsProvider === 'auto' ? undefined : testSmsProvider,
+                                })
                                 setTestSmsMsg(t('accountPage.testSuccess'))
                               } catch (e: any) {
                                 setTestSmsMsg(t('accountPage.testFailed') + ': ' + (e?.response?.data?.error || ''))
@@ -521,6 +798,60 @@ export default function AccountPage() {
                           </Button>
                         </div>
                         {testSmsMsg && <p className="text-sm">{testSmsMsg}</p>}
+                        {adminStatus.smsProviders?.filter((p) => p.state !== 'closed').map((p) => (
+                          <p key={p.name} className="text-xs text-muted-foreground break-all">
+                            {t('accountPage.smsProviderDown', { name: p.name })}{p.lastError ? `: ${p.lastError}` : ''}
+                          </p>
+                        ))}
+                        {smsMessages.length > 0 && (
+                          <div className="grid gap-1">
+                            <Label>{t('accountPage.recentSms')}</Label>
+                            {smsMessages.slice(0, 10).map((m) => (
+                              <div key={`${m.provider}/${m.id}`} className="flex flex-wrap justify-between gap-2 text-xs">
+                                <span>{new Date(m.createdAt * 1000).toLocaleString()} — {m.to} ({m.provider})</span>
+                                <span className={m.status === 'failed' ? 'text-destructive' : 'text-muted-foreground'}>
+                                  {t(`accountPage.smsStatus.${m.status}`)}{m.detail ? `: ${m.detail}` : ''}
+                                </span>
+                              </div>
+                            ))}
+                          </div>
+                        )}
+                      </div>
+                    )}
+
+                    {deliveries.some((d) => d.failed) && (
+                      <div className="grid gap-2 rounded-md border p-3">
+                        <Label>{t('accountPage.failedDeliveries')}</Label>
+                        {deliveries.filter((d) => d.failed).map((d) => (
+                          <div key={d.id} className="grid gap-1 rounded-md border bg-background/45 p-2 text-xs">
+                            <span className="font-medium">{d.hook} — {d.username}</span>
+                            <span className="text-muted-foreground break-all">
+                              {t('accountPage.deliveryAttempts', { count: d.attempts })}{d.lastError ? `: ${d.lastError}` : ''}
+                            </span>
+                            <div className="flex gap-2">
+                              <Button
+                                variant="outline"
+                                size="sm"
+                                onClick={async () => {
+                                  await api.post(`/admin/hook-deliveries/${d.id}/retry`).catch(() => {})
+                                  loadDeliveries()
+                                }}
+                              >
+                                {t('accountPage.retryDelivery')}
+                              </Button>
+                              <Button
+                                variant="destructive"
+                                size="sm"
+                                onClick={async () => {
+                                  await api.delete(`/admin/hook-deliveries/${d.id}`).catch(() => {})
+                                  loadDeliveries()
+                                }}
+                              >
+                                {t('accountPage.discardDelivery')}
+                              </Button>
+                            </div>
+                          </div>
+                        ))}
                       </div>
                     )}
                   </>
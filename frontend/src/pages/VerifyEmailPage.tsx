@@ -0,0 +1,49 @@
+import { useState } from 'react'
+import { useTranslation } from 'react-i18next'
+import { useSearchParams } from 'react-router-dom'
+import { api } from '../api/client'
+import { Button } from '@/components/ui/button'
+import { Card, CardContent, CardDescription, CardHeader, CardTitle } from '@/components/ui/card'
+
+// The link only opens this page; confirming takes a click so mail scanners
+// that fetch links don't verify the address on their own.
+export default function VerifyEmailPage() {
+  const { t } = useTranslation()
+  const [params] = useSearchParams()
+  const token = params.get('token') || ''
+  const [msg, setMsg] = useState('')
+  const [done, setDone] = useState(false)
+  const [busy, setBusy] = useState(false)
+
+  return (
+    <Card className="w-full max-w-sm sm:max-w-md">
+      <CardHeader>
+        <CardTitle className="text-center text-3xl">{t('verifyEmailPage.title')}</CardTitle>
+        <CardDescription className="text-center">{t('verifyEmailPage.description')}</CardDescription>
+      </CardHeader>
+      <CardContent className="flex flex-col gap-4">
+        {msg && <div className="rounded-md border bg-muted px-3 py-2 text-sm">{msg}</div>}
+        {!token && <p className="text-center text-muted-foreground">{t('verifyEmailPage.missingToken')}</p>}
+        {token && !done && (
+          <Button
+            disabled={busy}
+            onClick={async () => {
+              setBusy(true)
+              try {
+                await api.post('/email/verify', { token })
+                setMsg(t('verifyEmailPage.success'))
+                setDone(true)
+              } catch (e: any) {
+                setMsg(t('verifyEmailPage.failed') + ': ' + (e?.response?.data?.error || ''))
+              } finally {
+                setBusy(false)
+              }
+            }}
+          >
+            {t('verifyEmailPage.confirm')}
+          </Button>
+        )}
+      </CardContent>
+    </Card>
+  )
+}
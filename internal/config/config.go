@@ -1,23 +1,31 @@
 package config
 
 import (
+	"fmt"
 	"log"
 	"os"
 	"strconv"
 	"strings"
 
-	"github.com/BurntSushi/toml"
+	"tinyauth-sidecar/internal/httpclient"
 )
 
 type Config struct {
 	Port                  string
 	UsersFilePath         string
 	ResetTokenTTLSeconds  int64
+	EmailVerifyTTLSeconds int64
+	PhoneDefaultCountry   string
 	SMTPHost              string
 	SMTPPort              int
 	SMTPUsername          string
 	SMTPPassword          string
 	SMTPFrom              string
+	SMTPSecurity          string
+	SMTPAuth              string
+	SMTPHeloName          string
+	SMTPSkipTLSVerify     bool
+	SMTPCAFile            string
 	MailBaseURL           string
 	TOTPIssuer            string
 	TinyauthBaseURL       string
@@ -32,9 +40,39 @@ type Config struct {
 	UsernameIsEmail       bool
 	EmailSubject          string
 	EmailBody             string
+	MailTemplatesDir      string
+	MailDefaultLocale     string
 	BackgroundImage       string
 	Title                 string
 	RestartMethod         string
+	ConfigStrict          bool
+	HookOutboxPath        string
+	MailSpoolPath         string
+	SMSLogPath            string
+	SMSTemplatesDir       string
+	SMSCodeLength         int
+	SMSCodeTTLSeconds     int64
+	SMSCodeCooldown       int64
+	SMSCodeMaxAttempts    int
+	MailWorkers           int
+	MailMaxAttempts       int
+	MailRetryBackoff      int
+	MailMaxAgeSeconds     int
+	MailTransport         string
+	MailSendmailPath      string
+	MailFileDir           string
+	DKIMDomain            string
+	DKIMSelector          string
+	DKIMKeyFile           string
+	DKIMHeaders           []string
+	DKIMCanonicalization  string
+	OutboxKey             string
+	StepUpKey             string
+	StepUpTTLSeconds      int64
+	ExtraCAFile           string
+	TinyauthCAFile        string
+	TinyauthClientCert    string
+	TinyauthClientKey     string
 }
 
 func Load() *Config {
@@ -44,11 +82,18 @@ func Load() *Config {
 		Port:                  getEnv("PORT", "8080"),
 		UsersFilePath:         getEnv("USERS_FILE_PATH", "/data/users.txt"),
 		ResetTokenTTLSeconds:  getEnvInt64("RESET_TOKEN_TTL_SECONDS", 3600),
+		EmailVerifyTTLSeconds: getEnvInt64("EMAIL_VERIFY_TTL_SECONDS", 86400),
+		PhoneDefaultCountry:   getEnv("PHONE_DEFAULT_COUNTRY", ""),
 		SMTPHost:              getEnv("SMTP_HOST", ""),
 		SMTPPort:              getEnvInt("SMTP_PORT", 587),
 		SMTPUsername:          getEnv("SMTP_USERNAME", ""),
 		SMTPPassword:          getEnv("SMTP_PASSWORD", ""),
 		SMTPFrom:              getEnv("SMTP_FROM", "noreply@example.local"),
+		SMTPSecurity:          getEnv("SMTP_SECURITY", ""),
+		SMTPAuth:              getEnv("SMTP_AUTH", ""),
+		SMTPHeloName:          getEnv("SMTP_HELO_NAME", ""),
+		SMTPSkipTLSVerify:     getEnvBool("SMTP_SKIP_TLS_VERIFY", false),
+		SMTPCAFile:            getEnv("SMTP_CA_FILE", ""),
 		MailBaseURL:           getEnv("MAIL_BASE_URL", "http://localhost:8080"),
 		TOTPIssuer:            getEnv("TOTP_ISSUER", "tinyauth"),
 		TinyauthBaseURL:       baseURL,
@@ -61,20 +106,59 @@ func Load() *Config {
 		MinPasswordLength:     getEnvInt("MIN_PASSWORD_LENGTH", 8),
 		MinPasswordStrength:   getEnvInt("MIN_PASSWORD_STRENGTH", 3),
 		UsernameIsEmail:       getEnvBool("USERNAME_IS_EMAIL", true),
-		EmailSubject:          getEnv("EMAIL_SUBJECT", "Password reset"),
+		EmailSubject:          getEnv("EMAIL_SUBJECT", ""),
 		EmailBody:             getEnv("EMAIL_BODY", ""),
+		MailTemplatesDir:      getEnv("MAIL_TEMPLATES_DIR", ""),
+		MailDefaultLocale:     getEnv("MAIL_DEFAULT_LOCALE", "en"),
 		BackgroundImage:       getEnv("BACKGROUND_IMAGE", "/background.jpg"),
 		Title:                 getEnv("TITLE", ""),
 		RestartMethod:         getEnv("TINYAUTH_RESTART_METHOD", "restart"),
+		ConfigStrict:          getEnvBool("CONFIG_STRICT", false),
+		HookOutboxPath:        getEnv("HOOK_OUTBOX_PATH", "/data/hook-outbox.json"),
+		MailSpoolPath:         getEnv("MAIL_SPOOL_PATH", "/data/mail-spool.json"),
+		SMSLogPath:            getEnv("SMS_LOG_PATH", "/data/sms-log.json"),
+		SMSTemplatesDir:       getEnv("SMS_TEMPLATES_DIR", ""),
+		SMSCodeLength:         getEnvInt("SMS_CODE_LENGTH", 6),
+		SMSCodeTTLSeconds:     getEnvInt64("SMS_CODE_TTL_SECONDS", 600),
+		SMSCodeCooldown:       getEnvInt64("SMS_CODE_COOLDOWN_SECONDS", 300),
+		SMSCodeMaxAttempts:    getEnvInt("SMS_CODE_MAX_ATTEMPTS", 3),
+		MailWorkers:           getEnvInt("MAIL_WORKERS", 2),
+		MailMaxAttempts:       getEnvInt("MAIL_MAX_ATTEMPTS", 8),
+		MailRetryBackoff:      getEnvInt("MAIL_RETRY_BACKOFF", 30),
+		MailMaxAgeSeconds:     getEnvInt("MAIL_MAX_AGE_SECONDS", 86400),
+		MailTransport:         getEnv("MAIL_TRANSPORT", MailTransportSMTP),
+		MailSendmailPath:      getEnv("MAIL_SENDMAIL_PATH", "/usr/sbin/sendmail"),
+		MailFileDir:           getEnv("MAIL_FILE_DIR", "/data/mail-out"),
+		DKIMDomain:            getEnv("DKIM_DOMAIN", ""),
+		DKIMSelector:          getEnv("DKIM_SELECTOR", ""),
+		DKIMKeyFile:           getEnv("DKIM_PRIVATE_KEY_FILE", ""),
+		DKIMHeaders:           splitList(getEnv("DKIM_HEADERS", "")),
+		DKIMCanonicalization:  getEnv("DKIM_CANONICALIZATION", ""),
+		OutboxKey:             getEnv("OUTBOX_KEY", ""),
+		StepUpKey:             getEnv("STEP_UP_KEY", ""),
+		StepUpTTLSeconds:      getEnvInt64("STEP_UP_TTL_SECONDS", 300),
+		ExtraCAFile:           getEnv("EXTRA_CA_FILE", ""),
+		TinyauthCAFile:        getEnv("TINYAUTH_CA_FILE", ""),
+		TinyauthClientCert:    getEnv("TINYAUTH_CLIENT_CERT_FILE", ""),
+		TinyauthClientKey:     getEnv("TINYAUTH_CLIENT_KEY_FILE", ""),
 	}
 
 	return cfg
 }
 
+// getEnv returns the env var, or the contents of the file named by KEY_FILE.
 func getEnv(key, fallback string) string {
 	if v := os.Getenv(key); v != "" {
 		return v
 	}
+	if path := os.Getenv(key + "_FILE"); path != "" {
+		v, err := readSecretFile(path)
+		if err != nil {
+			log.Printf("[config] failed to read %s_FILE: %v", key, err)
+			return fallback
+		}
+		return v
+	}
 	return fallback
 }
 
@@ -110,18 +194,200 @@ type HeaderEntry struct {
 }
 
 // WebhookConfig holds configuration for a generic webhook (password hook or SMS).
+// Env holds extra template variables, e.g. {{.ApiKey}}. MaxAttempts and
+// RetryBackoff (seconds, doubled per attempt) apply to password hook deliveries.
+// Required password hooks run before users.txt is written; the Rollback* fields
+// describe the compensating request sent when a later required hook fails.
+// When SigningSecret is set, requests are signed with the Standard Webhooks
+// scheme (see pkg/webhooksig). CAFile adds a trusted CA bundle and
+// ClientCertFile/ClientKeyFile enable mutual TLS.
+//
+// Password hooks are HTTP webhooks unless Type selects a built-in hook; the
+// type's settings live in the matching sub-table (e.g. [password_hooks.ldap]).
 type WebhookConfig struct {
-	Enabled       bool          `toml:"enabled"`
-	URL           string        `toml:"url"`
-	Method        string        `toml:"method"`
-	ContentType   string        `toml:"content_type"`
-	Body          string        `toml:"body"`
-	Headers       []HeaderEntry `toml:"headers"`
-	Timeout       int           `toml:"timeout"`
-	SkipTLSVerify bool          `toml:"skip_tls_verify"`
-	FilterDomains []string      `toml:"filter_domains"`
-	FilterRoles   []string      `toml:"filter_roles"`
-	FilterUsers   []string      `toml:"filter_users"`
+	Name           string            `toml:"name"`
+	Type           string            `toml:"type"`
+	Enabled        bool              `toml:"enabled"`
+	URL            string            `toml:"url"`
+	Method         string            `toml:"method"`
+	ContentType    string            `toml:"content_type"`
+	Body           string            `toml:"body"`
+	Headers        []HeaderEntry     `toml:"headers"`
+	Timeout        int               `toml:"timeout"`
+	SkipTLSVerify  bool              `toml:"skip_tls_verify"`
+	FilterDomains  []string          `toml:"filter_domains"`
+	FilterRoles    []string          `toml:"filter_roles"`
+	FilterUsers    []string          `toml:"filter_users"`
+	Env            map[string]string `toml:"env"`
+	MaxAttempts    int               `toml:"max_attempts"`
+	RetryBackoff   int               `toml:"retry_backoff"`
+	Required       bool              `toml:"required"`
+	RollbackURL    string            `toml:"rollback_url"`
+	RollbackMethod string            `toml:"rollback_method"`
+	RollbackBody   string            `toml:"rollback_body"`
+	SigningSecret  string            `toml:"signing_secret"`
+	CAFile         string            `toml:"ca_file"`
+	ClientCertFile string            `toml:"client_cert_file"`
+	ClientKeyFile  string            `toml:"client_key_file"`
+	LDAP           LDAPHookConfig    `toml:"ldap"`
+	Exec           ExecHookConfig    `toml:"exec"`
+	File           FileHookConfig    `toml:"file"`
+}
+
+// Password hook types.
+const (
+	HookTypeWebhook = "webhook"
+	HookTypeLDAP    = "ldap"
+	HookTypeExec    = "exec"
+	HookTypeFile    = "file"
+)
+
+// LDAPHookConfig configures a type = "ldap" password hook. UserDN and BindDN are
+// templates over the password hook variables, e.g. "uid={{.User}},ou=people,dc=example,dc=com";
+// user values are DN-escaped, env values are not.
+// Mode "modify" (default) replaces Attribute with a hash ("ssha" or "crypt",
+// the bcrypt hash from users.txt); mode "exop" uses the password modify
+// extended operation (RFC 3062) so the server hashes the password itself.
+type LDAPHookConfig struct {
+	URL          string `toml:"url"`
+	StartTLS     bool   `toml:"start_tls"`
+	BindDN       string `toml:"bind_dn"`
+	BindPassword string `toml:"bind_password"`
+	UserDN       string `toml:"user_dn"`
+	Mode         string `toml:"mode"`
+	Hash         string `toml:"hash"`
+	Attribute    string `toml:"attribute"`
+}
+
+// EventHookConfig is a webhook subscribed to lifecycle events ([[event_hooks]]).
+// Events lists the event types to deliver, or "*" for all of them.
+type EventHookConfig struct {
+	WebhookConfig
+	Events []string `toml:"events"`
+}
+
+// SMSConfig is the [sms] table: a webhook provider plus the policy for the
+// codes sent by SMS. CodeTTL and CodeCooldown are in seconds; the cooldown is
+// the minimum time between reset codes for one user. TemplatesDir overrides
+// the SMS texts per locale like [email] templates_dir.
+type SMSConfig struct {
+	WebhookConfig
+	CodeLength      int    `toml:"code_length"`
+	CodeTTL         int64  `toml:"code_ttl"`
+	CodeCooldown    int64  `toml:"code_cooldown"`
+	CodeMaxAttempts int    `toml:"code_max_attempts"`
+	TemplatesDir    string `toml:"templates_dir"`
+}
+
+// SMSProviderConfig is one of several SMS providers ([[sms_providers]]).
+// Providers are tried in ascending Priority (then config order) until one
+// accepts the message. When Countries lists calling-code prefixes (e.g. "31",
+// "1"), the provider only handles numbers starting with one of them and is
+// preferred over providers without Countries. After BreakerThreshold
+// consecutive failures (default 3) the provider is skipped for
+// BreakerCooldown seconds (default 60).
+//
+// Providers are templated webhooks unless Type selects a built-in gateway;
+// its credentials live in the matching sub-table (e.g. [sms_providers.cm]).
+// For built-in HTTP gateways URL overrides the API endpoint, and Sender is
+// the alphanumeric sender ID or number. ReportToken enables delivery reports
+// at /api/sms/reports/<name>?token=...; ReportURL is that public URL, passed
+// to gateways that take a per-message callback (MessageBird, Twilio).
+type SMSProviderConfig struct {
+	WebhookConfig
+	Priority         int                  `toml:"priority"`
+	Countries        []string             `toml:"countries"`
+	BreakerThreshold int                  `toml:"breaker_threshold"`
+	BreakerCooldown  int                  `toml:"breaker_cooldown"`
+	Sender           string               `toml:"sender"`
+	ReportToken      string               `toml:"report_token"`
+	ReportURL        string               `toml:"report_url"`
+	CM               SMSCMConfig          `toml:"cm"`
+	MessageBird      SMSMessageBirdConfig `toml:"messagebird"`
+	Twilio           SMSTwilioConfig      `toml:"twilio"`
+	SMPP             SMSSMPPConfig        `toml:"smpp"`
+}
+
+// SMS provider types.
+const (
+	SMSTypeWebhook     = "webhook"
+	SMSTypeCM          = "cm"
+	SMSTypeMessageBird = "messagebird"
+	SMSTypeTwilio      = "twilio"
+	SMSTypeSMPP        = "smpp"
+)
+
+// SMSCMConfig configures a type = "cm" provider (CM.com Business Messaging).
+type SMSCMConfig struct {
+	ProductToken string `toml:"product_token"`
+}
+
+// SMSMessageBirdConfig configures a type = "messagebird" provider.
+type SMSMessageBirdConfig struct {
+	AccessKey string `toml:"access_key"`
+}
+
+// SMSTwilioConfig configures a type = "twilio" provider. It also works with
+// Twilio-compatible APIs (e.g. SignalWire) by setting the provider URL.
+// MessagingServiceSID replaces Sender as the From of the message.
+type SMSTwilioConfig struct {
+	AccountSID          string `toml:"account_sid"`
+	AuthToken           string `toml:"auth_token"`
+	MessagingServiceSID string `toml:"messaging_service_sid"`
+}
+
+// SMSSMPPConfig configures a type = "smpp" provider: an SMPP 3.4 transceiver
+// session to Address (host:port). Delivery receipts arrive on the same
+// session. EnquireLink is the keepalive interval in seconds (default 30).
+type SMSSMPPConfig struct {
+	Address     string `toml:"address"`
+	TLS         bool   `toml:"tls"`
+	SystemID    string `toml:"system_id"`
+	Password    string `toml:"password"`
+	SystemType  string `toml:"system_type"`
+	EnquireLink int    `toml:"enquire_link"`
+}
+
+// EventTypes lists the lifecycle events that [[event_hooks]] can subscribe to.
+var EventTypes = []string{
+	"password.changed",
+	"password.reset_requested",
+	"totp.enabled",
+	"totp.disabled",
+	"email.changed",
+	"phone.changed",
+	"user.created",
+	"user.deleted",
+	"user.role_changed",
+	"user.locked_out",
+}
+
+// ExecHookConfig configures a type = "exec" password hook. Args are templates
+// over the password hook variables except {{.Password}}/{{.OldPassword}}, which
+// may only be passed on stdin (Stdin template, default "{{.Password}}\n").
+// The command only sees the environment variables named in EnvAllow.
+type ExecHookConfig struct {
+	Command      string   `toml:"command"`
+	Args         []string `toml:"args"`
+	Stdin        string   `toml:"stdin"`
+	Dir          string   `toml:"dir"`
+	EnvAllow     []string `toml:"env_allow"`
+	SuccessCodes []int    `toml:"success_codes"`
+}
+
+// FileHookConfig configures a type = "file" password hook that keeps a
+// password file for another service in sync. Format is "htpasswd" or "dovecot"
+// (passwd-file). Hash "bcrypt" (default) reuses the hash from users.txt;
+// "sha" (htpasswd) and "ssha512" (dovecot) compute a format-specific hash.
+// Username is a template for the entry name (default "{{.Username}}"); Extra
+// is a template for the dovecot fields after the password of new entries
+// (uid:gid:gecos:home:shell:extra).
+type FileHookConfig struct {
+	Path     string `toml:"path"`
+	Format   string `toml:"format"`
+	Hash     string `toml:"hash"`
+	Username string `toml:"username"`
+	Extra    string `toml:"extra"`
 }
 
 // PasswordPolicy configures password strength requirements.
@@ -131,8 +397,10 @@ type PasswordPolicy struct {
 }
 
 // UsersConfig configures user-related behaviour.
+// PhoneDefaultCountry is the calling code (e.g. "31") for national phone numbers.
 type UsersConfig struct {
-	UsernameIsEmail *bool `toml:"username_is_email"`
+	UsernameIsEmail     *bool  `toml:"username_is_email"`
+	PhoneDefaultCountry string `toml:"phone_default_country"`
 }
 
 // UIConfig configures UI appearance.
@@ -148,19 +416,58 @@ type SMTPConfig struct {
 	Username string `toml:"username"`
 	Password string `toml:"password"`
 	From     string `toml:"from"`
+	// Security is "none", "starttls" or "tls"; empty picks by port (465 tls, 587 starttls).
+	Security string `toml:"security"`
+	// Auth is "plain", "login", "crammd5" or "none"; empty is plain with a username.
+	Auth          string `toml:"auth"`
+	HeloName      string `toml:"helo_name"`
+	SkipTLSVerify bool   `toml:"skip_tls_verify"`
+	CAFile        string `toml:"ca_file"`
 }
 
-// EmailTemplateConfig holds email template settings.
+// EmailTemplateConfig holds the [email] settings: templates and the transport.
+// HTTP configures transport = "http" like [sms]; its templates see {{.From}},
+// {{.FromAddress}}, {{.FromName}}, {{.To}}, {{.Subject}}, {{.Text}}, {{.HTML}}
+// and {{.MIME}} (the complete message).
 type EmailTemplateConfig struct {
-	Subject string `toml:"subject"`
-	Body    string `toml:"body"`
+	Subject       string        `toml:"subject"`
+	Body          string        `toml:"body"`
+	TemplatesDir  string        `toml:"templates_dir"`
+	DefaultLocale string        `toml:"default_locale"`
+	Transport     string        `toml:"transport"`
+	SendmailPath  string        `toml:"sendmail_path"`
+	FileDir       string        `toml:"file_dir"`
+	HTTP          WebhookConfig `toml:"http"`
+	DKIM          DKIMConfig    `toml:"dkim"`
 }
 
+// DKIMConfig enables DKIM signing of all outgoing mail when Domain, Selector
+// and PrivateKeyFile are set. Headers defaults to From, To, Cc, Reply-To,
+// Subject, Date, Message-Id, MIME-Version and Content-Type; Canonicalization
+// is "header/body" and defaults to "relaxed/relaxed".
+type DKIMConfig struct {
+	Domain           string   `toml:"domain"`
+	Selector         string   `toml:"selector"`
+	PrivateKeyFile   string   `toml:"private_key_file"`
+	Headers          []string `toml:"headers"`
+	Canonicalization string   `toml:"canonicalization"`
+}
+
+// Mail transports.
+const (
+	MailTransportSMTP     = "smtp"
+	MailTransportSendmail = "sendmail"
+	MailTransportHTTP     = "http"
+	MailTransportFile     = "file"
+)
+
 // FileConfig represents the TOML config file structure.
 type FileConfig struct {
 	PasswordPolicy PasswordPolicy      `toml:"password_policy"`
 	PasswordHooks  []WebhookConfig     `toml:"password_hooks"`
-	SMS            WebhookConfig       `toml:"sms"`
+	EventHooks     []EventHookConfig   `toml:"event_hooks"`
+	SMS            SMSConfig           `toml:"sms"`
+	SMSProviders   []SMSProviderConfig `toml:"sms_providers"`
 	Users          UsersConfig         `toml:"users"`
 	SMTP           SMTPConfig          `toml:"smtp"`
 	Email          EmailTemplateConfig `toml:"email"`
@@ -168,28 +475,44 @@ type FileConfig struct {
 }
 
 // LoadFileConfig reads the TOML config file from CONFIG_PATH (default /data/config.toml).
-// Returns an empty config if the file doesn't exist.
-func LoadFileConfig() FileConfig {
-	path := getEnv("CONFIG_PATH", "/data/config.toml")
+// Returns an empty config if the file doesn't exist or can't be parsed.
+// Validation problems are logged and returned so callers can fail fast.
+func LoadFileConfig() (FileConfig, []ValidationError) {
+	path := ConfigPath()
 
-	var fc FileConfig
 	if _, err := os.Stat(path); os.IsNotExist(err) {
-		return fc
+		return FileConfig{}, nil
 	}
 
-	if _, err := toml.DecodeFile(path, &fc); err != nil {
-		log.Printf("[config] failed to parse %s: %v", path, err)
-		return FileConfig{}
+	fc, errs := CheckFileConfig(path)
+	for _, e := range errs {
+		log.Printf("[config] %s: %v", path, e)
 	}
 
 	// Apply defaults
 	for i := range fc.PasswordHooks {
+		if fc.PasswordHooks[i].Name == "" {
+			fc.PasswordHooks[i].Name = fmt.Sprintf("password_hooks[%d]", i)
+		}
 		applyWebhookDefaults(&fc.PasswordHooks[i], "POST", "application/x-www-form-urlencoded", 10)
 	}
-	applyWebhookDefaults(&fc.SMS, "POST", "application/json", 15)
+	for i := range fc.EventHooks {
+		if fc.EventHooks[i].Name == "" {
+			fc.EventHooks[i].Name = fmt.Sprintf("event_hooks[%d]", i)
+		}
+		applyWebhookDefaults(&fc.EventHooks[i].WebhookConfig, "POST", "application/json", 10)
+	}
+	applyWebhookDefaults(&fc.SMS.WebhookConfig, "POST", "application/json", 15)
+	for i := range fc.SMSProviders {
+		if fc.SMSProviders[i].Name == "" {
+			fc.SMSProviders[i].Name = fmt.Sprintf("sms_providers[%d]", i)
+		}
+		applyWebhookDefaults(&fc.SMSProviders[i].WebhookConfig, "POST", "application/json", 15)
+	}
+	applyWebhookDefaults(&fc.Email.HTTP, "POST", "application/json", 15)
 
 	log.Printf("[config] loaded %s", path)
-	return fc
+	return fc, errs
 }
 
 func applyWebhookDefaults(wc *WebhookConfig, method, contentType string, timeout int) {
@@ -216,6 +539,24 @@ func (c *Config) ApplyFileConfig(fc FileConfig) {
 	if fc.Users.UsernameIsEmail != nil {
 		c.UsernameIsEmail = *fc.Users.UsernameIsEmail
 	}
+	if fc.Users.PhoneDefaultCountry != "" {
+		c.PhoneDefaultCountry = fc.Users.PhoneDefaultCountry
+	}
+	if fc.SMS.CodeLength > 0 {
+		c.SMSCodeLength = fc.SMS.CodeLength
+	}
+	if fc.SMS.CodeTTL > 0 {
+		c.SMSCodeTTLSeconds = fc.SMS.CodeTTL
+	}
+	if fc.SMS.CodeCooldown > 0 {
+		c.SMSCodeCooldown = fc.SMS.CodeCooldown
+	}
+	if fc.SMS.CodeMaxAttempts > 0 {
+		c.SMSCodeMaxAttempts = fc.SMS.CodeMaxAttempts
+	}
+	if fc.SMS.TemplatesDir != "" {
+		c.SMSTemplatesDir = fc.SMS.TemplatesDir
+	}
 	if fc.SMTP.Host != "" {
 		c.SMTPHost = fc.SMTP.Host
 	}
@@ -231,12 +572,57 @@ func (c *Config) ApplyFileConfig(fc FileConfig) {
 	if fc.SMTP.From != "" {
 		c.SMTPFrom = fc.SMTP.From
 	}
+	if fc.SMTP.Security != "" {
+		c.SMTPSecurity = fc.SMTP.Security
+	}
+	if fc.SMTP.Auth != "" {
+		c.SMTPAuth = fc.SMTP.Auth
+	}
+	if fc.SMTP.HeloName != "" {
+		c.SMTPHeloName = fc.SMTP.HeloName
+	}
+	if fc.SMTP.SkipTLSVerify {
+		c.SMTPSkipTLSVerify = true
+	}
+	if fc.SMTP.CAFile != "" {
+		c.SMTPCAFile = fc.SMTP.CAFile
+	}
 	if fc.Email.Subject != "" {
 		c.EmailSubject = fc.Email.Subject
 	}
 	if fc.Email.Body != "" {
 		c.EmailBody = fc.Email.Body
 	}
+	if fc.Email.TemplatesDir != "" {
+		c.MailTemplatesDir = fc.Email.TemplatesDir
+	}
+	if fc.Email.DefaultLocale != "" {
+		c.MailDefaultLocale = fc.Email.DefaultLocale
+	}
+	if fc.Email.Transport != "" {
+		c.MailTransport = fc.Email.Transport
+	}
+	if fc.Email.SendmailPath != "" {
+		c.MailSendmailPath = fc.Email.SendmailPath
+	}
+	if fc.Email.FileDir != "" {
+		c.MailFileDir = fc.Email.FileDir
+	}
+	if fc.Email.DKIM.Domain != "" {
+		c.DKIMDomain = fc.Email.DKIM.Domain
+	}
+	if fc.Email.DKIM.Selector != "" {
+		c.DKIMSelector = fc.Email.DKIM.Selector
+	}
+	if fc.Email.DKIM.PrivateKeyFile != "" {
+		c.DKIMKeyFile = fc.Email.DKIM.PrivateKeyFile
+	}
+	if len(fc.Email.DKIM.Headers) > 0 {
+		c.DKIMHeaders = fc.Email.DKIM.Headers
+	}
+	if fc.Email.DKIM.Canonicalization != "" {
+		c.DKIMCanonicalization = fc.Email.DKIM.Canonicalization
+	}
 	if fc.UI.BackgroundImage != "" {
 		c.BackgroundImage = fc.UI.BackgroundImage
 	}
@@ -245,6 +631,17 @@ func (c *Config) ApplyFileConfig(fc FileConfig) {
 	}
 }
 
+// splitList splits a comma-separated value, dropping empty entries.
+func splitList(v string) []string {
+	var res []string
+	for _, p := range strings.Split(v, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
 func parseCSV(v string) []string {
 	parts := strings.Split(v, ",")
 	res := make([]string, 0, len(parts))
@@ -259,3 +656,33 @@ func parseCSV(v string) []string {
 	}
 	return res
 }
+
+// TLSOptions returns the TLS settings for the webhook's HTTP client.
+func (wc WebhookConfig) TLSOptions() httpclient.TLSOptions {
+	return httpclient.TLSOptions{
+		CAFile:         wc.CAFile,
+		ClientCertFile: wc.ClientCertFile,
+		ClientKeyFile:  wc.ClientKeyFile,
+		SkipVerify:     wc.SkipTLSVerify,
+	}
+}
+
+// TinyauthTLSOptions returns the TLS settings for calls to tinyauth (verify and health checks).
+func (c *Config) TinyauthTLSOptions() httpclient.TLSOptions {
+	return httpclient.TLSOptions{
+		CAFile:         c.TinyauthCAFile,
+		ClientCertFile: c.TinyauthClientCert,
+		ClientKeyFile:  c.TinyauthClientKey,
+	}
+}
+
+// SMTPTLSOptions returns the TLS settings for the SMTP connection.
+func (c *Config) SMTPTLSOptions() httpclient.TLSOptions {
+	return httpclient.TLSOptions{CAFile: c.SMTPCAFile, SkipVerify: c.SMTPSkipTLSVerify}
+}
+
+// MailEnabled reports whether mail can be sent: a transport other than SMTP
+// is selected, or an SMTP host is configured.
+func (c *Config) MailEnabled() bool {
+	return c.MailTransport != MailTransportSMTP || c.SMTPHost != ""
+}
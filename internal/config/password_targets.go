@@ -0,0 +1,100 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+)
+
+// PasswordTargetConfig is the JSON format of a PASSWORD_TARGETS entry.
+// Targets are converted to WebhookConfig and run in the same pipeline as [[password_hooks]].
+type PasswordTargetConfig struct {
+	Name           string            `json:"name"`
+	URL            string            `json:"url"`
+	Method         string            `json:"method"`
+	ContentType    string            `json:"content_type"`
+	Body           string            `json:"body"`
+	Headers        map[string]string `json:"headers"`
+	Timeout        int               `json:"timeout"`
+	SkipTLSVerify  bool              `json:"skip_tls_verify"`
+	Env            map[string]string `json:"env"`
+	FilterDomains  []string          `json:"filter_domains"`
+	FilterRoles    []string          `json:"filter_roles"`
+	FilterUsers    []string          `json:"filter_users"`
+	SigningSecret  string            `json:"signing_secret"`
+	CAFile         string            `json:"ca_file"`
+	ClientCertFile string            `json:"client_cert_file"`
+	ClientKeyFile  string            `json:"client_key_file"`
+}
+
+// LoadPasswordTargets parses the PASSWORD_TARGETS env var (a JSON array) into
+// password hook configs. Returns nil if unset or invalid.
+func LoadPasswordTargets() []WebhookConfig {
+	raw := Getenv("PASSWORD_TARGETS")
+	if raw == "" {
+		return nil
+	}
+
+	var targets []PasswordTargetConfig
+	if err := json.Unmarshal([]byte(raw), &targets); err != nil {
+		log.Printf("[config] failed to parse PASSWORD_TARGETS: %v", err)
+		return nil
+	}
+
+	hooks := make([]WebhookConfig, 0, len(targets))
+	for i, t := range targets {
+		name := t.Name
+		if name == "" {
+			name = fmt.Sprintf("password_targets[%d]", i)
+		}
+		wc := WebhookConfig{
+			Name:           name,
+			Enabled:        true,
+			URL:            t.URL,
+			Method:         t.Method,
+			ContentType:    t.ContentType,
+			Body:           t.Body,
+			Headers:        HeaderEntriesFromMap(t.Headers),
+			Timeout:        t.Timeout,
+			SkipTLSVerify:  t.SkipTLSVerify,
+			Env:            t.Env,
+			FilterDomains:  t.FilterDomains,
+			FilterRoles:    t.FilterRoles,
+			FilterUsers:    t.FilterUsers,
+			SigningSecret:  t.SigningSecret,
+			CAFile:         t.CAFile,
+			ClientCertFile: t.ClientCertFile,
+			ClientKeyFile:  t.ClientKeyFile,
+		}
+		applyWebhookDefaults(&wc, "POST", "application/json", 15)
+
+		prefix := "PASSWORD_TARGETS." + name
+		errs := wc.resolveSecrets(prefix)
+		errs = append(errs, validateWebhook(prefix, wc)...)
+		for _, e := range errs {
+			log.Printf("[config] %v", e)
+		}
+		hooks = append(hooks, wc)
+	}
+
+	log.Printf("[config] loaded %d password target(s) from PASSWORD_TARGETS", len(hooks))
+	return hooks
+}
+
+// HeaderEntriesFromMap converts a header map into entries sorted by key.
+func HeaderEntriesFromMap(m map[string]string) []HeaderEntry {
+	if len(m) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	entries := make([]HeaderEntry, 0, len(keys))
+	for _, k := range keys {
+		entries = append(entries, HeaderEntry{Key: k, Value: m[k]})
+	}
+	return entries
+}
@@ -0,0 +1,34 @@
+package config
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestLoadPasswordTargetsResolvesRefsBeforeValidating(t *testing.T) {
+	t.Setenv("TEST_HOOK_URL", "ftp://hooks.example.com/secret-token")
+	t.Setenv("TEST_GOOD_URL", "https://hooks.example.com/sync")
+	t.Setenv("PASSWORD_TARGETS", `[
+		{"name": "bad", "url": "${env:TEST_HOOK_URL}", "body": "x"},
+		{"name": "good", "url": "${env:TEST_GOOD_URL}", "body": "x"}
+	]`)
+
+	var buf bytes.Buffer
+	prev := log.Writer()
+	log.SetOutput(&buf)
+	hooks := LoadPasswordTargets()
+	log.SetOutput(prev)
+
+	out := buf.String()
+	if !strings.Contains(out, "PASSWORD_TARGETS.bad.url: must start with http:// or https://") {
+		t.Errorf("resolved URL not validated:\n%s", out)
+	}
+	if strings.Contains(out, "PASSWORD_TARGETS.good") {
+		t.Errorf("unexpected problem with the good target:\n%s", out)
+	}
+	if len(hooks) != 2 || hooks[1].URL != "https://hooks.example.com/sync" {
+		t.Errorf("expected resolved URLs, got %+v", hooks)
+	}
+}
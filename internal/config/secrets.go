@@ -0,0 +1,129 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// refPattern matches ${env:NAME} and ${file:/path/to/secret} references.
+var refPattern = regexp.MustCompile(`\$\{(env|file):([^}]+)\}`)
+
+// Getenv returns the value of an environment variable. If KEY_FILE is set
+// instead (e.g. SMTP_PASSWORD_FILE for Docker secrets), the file contents are returned.
+func Getenv(key string) string {
+	return getEnv(key, "")
+}
+
+// readSecretFile reads a secret file and strips the trailing newline.
+func readSecretFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// ResolveRefs replaces ${env:NAME} and ${file:/path} references in s with the
+// referenced environment variable or file contents.
+func ResolveRefs(s string) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+	var firstErr error
+	out := refPattern.ReplaceAllStringFunc(s, func(m string) string {
+		parts := refPattern.FindStringSubmatch(m)
+		kind, name := parts[1], strings.TrimSpace(parts[2])
+		switch kind {
+		case "env":
+			v, ok := os.LookupEnv(name)
+			if !ok && firstErr == nil {
+				firstErr = fmt.Errorf("environment variable %s is not set", name)
+			}
+			return v
+		default:
+			v, err := readSecretFile(name)
+			if err != nil && firstErr == nil {
+				firstErr = fmt.Errorf("read secret file: %w", err)
+			}
+			return v
+		}
+	})
+	return out, firstErr
+}
+
+// ResolveEnvValue resolves a value from a webhook `env` map (including PASSWORD_TARGETS and SMS_WEBHOOK_ENV).
+// A value of the form "$NAME" is read from the environment; ${env:..} and
+// ${file:..} references are resolved as in ResolveRefs.
+func ResolveEnvValue(v string) (string, error) {
+	if strings.HasPrefix(v, "$") && !strings.HasPrefix(v, "${") {
+		name := v[1:]
+		val, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return val, nil
+	}
+	return ResolveRefs(v)
+}
+
+// resolveSecrets resolves secret references in webhook and SMTP values in place.
+func (fc *FileConfig) resolveSecrets() []ValidationError {
+	var errs []ValidationError
+	for i := range fc.PasswordHooks {
+		errs = append(errs, fc.PasswordHooks[i].resolveSecrets(fmt.Sprintf("password_hooks[%d]", i))...)
+	}
+	for i := range fc.EventHooks {
+		errs = append(errs, fc.EventHooks[i].resolveSecrets(fmt.Sprintf("event_hooks[%d]", i))...)
+	}
+	errs = append(errs, fc.SMS.resolveSecrets("sms")...)
+	for i := range fc.SMSProviders {
+		p := &fc.SMSProviders[i]
+		prefix := fmt.Sprintf("sms_providers[%d]", i)
+		errs = append(errs, p.resolveSecrets(prefix)...)
+		resolveField(&errs, prefix+".report_token", &p.ReportToken)
+		resolveField(&errs, prefix+".report_url", &p.ReportURL)
+		resolveField(&errs, prefix+".cm.product_token", &p.CM.ProductToken)
+		resolveField(&errs, prefix+".messagebird.access_key", &p.MessageBird.AccessKey)
+		resolveField(&errs, prefix+".twilio.auth_token", &p.Twilio.AuthToken)
+		resolveField(&errs, prefix+".smpp.password", &p.SMPP.Password)
+	}
+	errs = append(errs, fc.Email.HTTP.resolveSecrets("email.http")...)
+	resolveField(&errs, "smtp.username", &fc.SMTP.Username)
+	resolveField(&errs, "smtp.password", &fc.SMTP.Password)
+	return errs
+}
+
+func (wc *WebhookConfig) resolveSecrets(prefix string) []ValidationError {
+	var errs []ValidationError
+	resolveField(&errs, prefix+".url", &wc.URL)
+	resolveField(&errs, prefix+".body", &wc.Body)
+	resolveField(&errs, prefix+".rollback_url", &wc.RollbackURL)
+	resolveField(&errs, prefix+".rollback_body", &wc.RollbackBody)
+	resolveField(&errs, prefix+".signing_secret", &wc.SigningSecret)
+	resolveField(&errs, prefix+".ldap.url", &wc.LDAP.URL)
+	resolveField(&errs, prefix+".ldap.bind_dn", &wc.LDAP.BindDN)
+	resolveField(&errs, prefix+".ldap.bind_password", &wc.LDAP.BindPassword)
+	for j := range wc.Headers {
+		resolveField(&errs, fmt.Sprintf("%s.headers[%d].value", prefix, j), &wc.Headers[j].Value)
+	}
+	for k, v := range wc.Env {
+		r, err := ResolveEnvValue(v)
+		if err != nil {
+			errs = append(errs, ValidationError{Field: prefix + ".env." + k, Message: err.Error()})
+		}
+		wc.Env[k] = r
+	}
+	return errs
+}
+
+// resolveField resolves references in *v, recording a validation error on failure.
+func resolveField(errs *[]ValidationError, field string, v *string) {
+	r, err := ResolveRefs(*v)
+	if err != nil {
+		*errs = append(*errs, ValidationError{Field: field, Message: err.Error()})
+		return
+	}
+	*v = r
+}
@@ -0,0 +1,563 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"tinyauth-sidecar/internal/httpclient"
+	"tinyauth-sidecar/internal/mailer"
+	"tinyauth-sidecar/internal/tmpl"
+	"tinyauth-sidecar/pkg/webhooksig"
+
+	"github.com/BurntSushi/toml"
+)
+
+// ValidationError describes a single problem found in config.toml.
+type ValidationError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e ValidationError) Error() string {
+	if e.Field == "" {
+		return e.Message
+	}
+	return e.Field + ": " + e.Message
+}
+
+// validMethods lists the HTTP methods accepted for webhooks.
+var validMethods = map[string]bool{
+	"GET":    true,
+	"POST":   true,
+	"PUT":    true,
+	"PATCH":  true,
+	"DELETE": true,
+}
+
+// ConfigPath returns the config file location from CONFIG_PATH (default /data/config.toml).
+func ConfigPath() string {
+	return getEnv("CONFIG_PATH", "/data/config.toml")
+}
+
+// CheckFileConfig parses the TOML config file at path, resolves secret
+// references and validates the resolved values strictly. A missing file is
+// valid and yields an empty config. If the file cannot be parsed, the returned
+// config is empty and the parse error is reported.
+func CheckFileConfig(path string) (FileConfig, []ValidationError) {
+	var fc FileConfig
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return fc, nil
+	}
+
+	md, err := toml.DecodeFile(path, &fc)
+	if err != nil {
+		return FileConfig{}, []ValidationError{{Message: err.Error()}}
+	}
+
+	var errs []ValidationError
+	for _, key := range md.Undecoded() {
+		errs = append(errs, ValidationError{Field: key.String(), Message: "unknown key"})
+	}
+	errs = append(errs, fc.resolveSecrets()...)
+	errs = append(errs, fc.Validate()...)
+	return fc, errs
+}
+
+// Validate checks value ranges, URLs, methods and template syntax.
+func (fc FileConfig) Validate() []ValidationError {
+	var errs []ValidationError
+	add := func(field, format string, args ...any) {
+		errs = append(errs, ValidationError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if fc.PasswordPolicy.MinLength < 0 {
+		add("password_policy.min_length", "must not be negative")
+	}
+	if fc.PasswordPolicy.MinStrength < 0 || fc.PasswordPolicy.MinStrength > 4 {
+		add("password_policy.min_strength", "must be between 0 and 4, got %d", fc.PasswordPolicy.MinStrength)
+	}
+
+	if cc := strings.TrimPrefix(fc.Users.PhoneDefaultCountry, "+"); cc != "" {
+		if len(cc) > 3 || strings.Trim(cc, "0123456789") != "" || cc[0] == '0' {
+			add("users.phone_default_country", "must be a country calling code like \"31\", got %q", fc.Users.PhoneDefaultCountry)
+		}
+	}
+
+	for i, hook := range fc.PasswordHooks {
+		errs = append(errs, validatePasswordHook(fmt.Sprintf("password_hooks[%d]", i), hook)...)
+	}
+	for i, hook := range fc.EventHooks {
+		prefix := fmt.Sprintf("event_hooks[%d]", i)
+		errs = append(errs, validateWebhook(prefix, hook.WebhookConfig)...)
+		if hook.Enabled && len(hook.Events) == 0 {
+			add(prefix+".events", "required when enabled")
+		}
+		for j, ev := range hook.Events {
+			if ev != "*" && !slices.Contains(EventTypes, ev) {
+				add(fmt.Sprintf("%s.events[%d]", prefix, j), "unknown event type %q", ev)
+			}
+		}
+		if hook.Required || hook.RollbackURL != "" || hook.RollbackBody != "" {
+			add(prefix, "required and rollback_* are only supported for password_hooks")
+		}
+		if hook.Type != "" && hook.Type != HookTypeWebhook {
+			add(prefix+".type", "event hooks only support type %q", HookTypeWebhook)
+		}
+	}
+	errs = append(errs, validateWebhook("sms", fc.SMS.WebhookConfig)...)
+	if n := fc.SMS.CodeLength; n != 0 && (n < 4 || n > 10) {
+		add("sms.code_length", "must be between 4 and 10, got %d", n)
+	}
+	if ttl := fc.SMS.CodeTTL; ttl != 0 && (ttl < 60 || ttl > 86400) {
+		add("sms.code_ttl", "must be between 60 and 86400 seconds, got %d", ttl)
+	}
+	if fc.SMS.CodeCooldown < 0 {
+		add("sms.code_cooldown", "must not be negative")
+	}
+	if fc.SMS.CodeMaxAttempts < 0 {
+		add("sms.code_max_attempts", "must not be negative")
+	}
+	if fc.SMS.TemplatesDir != "" {
+		if fi, err := os.Stat(fc.SMS.TemplatesDir); err != nil || !fi.IsDir() {
+			add("sms.templates_dir", "not a readable directory: %s", fc.SMS.TemplatesDir)
+		}
+	}
+	smsNames := map[string]bool{}
+	if fc.SMS.Enabled {
+		smsNames["sms"] = true
+	}
+	for i, p := range fc.SMSProviders {
+		prefix := fmt.Sprintf("sms_providers[%d]", i)
+		errs = append(errs, validateSMSProvider(prefix, p)...)
+		if p.Name != "" && p.Enabled {
+			if smsNames[p.Name] {
+				add(prefix+".name", "duplicate SMS provider name %q", p.Name)
+			}
+			smsNames[p.Name] = true
+		}
+		if p.Priority < 0 {
+			add(prefix+".priority", "must not be negative")
+		}
+		for j, cc := range p.Countries {
+			cc = strings.TrimPrefix(cc, "+")
+			if cc == "" || len(cc) > 6 || strings.Trim(cc, "0123456789") != "" || cc[0] == '0' {
+				add(fmt.Sprintf("%s.countries[%d]", prefix, j), "must be a calling code prefix like \"31\", got %q", p.Countries[j])
+			}
+		}
+		if p.BreakerThreshold < 0 {
+			add(prefix+".breaker_threshold", "must not be negative")
+		}
+		if p.BreakerCooldown < 0 {
+			add(prefix+".breaker_cooldown", "must not be negative")
+		}
+	}
+
+	if fc.SMTP.Port != 0 && (fc.SMTP.Port < 1 || fc.SMTP.Port > 65535) {
+		add("smtp.port", "must be between 1 and 65535, got %d", fc.SMTP.Port)
+	}
+	switch fc.SMTP.Security {
+	case "", "none", "starttls", "tls":
+	default:
+		add("smtp.security", "must be \"none\", \"starttls\" or \"tls\", got %q", fc.SMTP.Security)
+	}
+	switch fc.SMTP.Auth {
+	case "", "plain", "login", "crammd5", "none":
+	default:
+		add("smtp.auth", "must be \"plain\", \"login\", \"crammd5\" or \"none\", got %q", fc.SMTP.Auth)
+	}
+	if fc.SMTP.Auth != "" && fc.SMTP.Auth != "none" && fc.SMTP.Username == "" {
+		add("smtp.username", "required with auth = %q", fc.SMTP.Auth)
+	}
+	if fc.SMTP.CAFile != "" {
+		if _, err := (httpclient.TLSOptions{CAFile: fc.SMTP.CAFile}).Config(); err != nil {
+			add("smtp.ca_file", "%v", err)
+		}
+	}
+
+	if err := checkTemplate(fc.Email.Subject); err != nil {
+		add("email.subject", "invalid template: %v", err)
+	}
+	if err := checkTemplate(fc.Email.Body); err != nil {
+		add("email.body", "invalid template: %v", err)
+	}
+	switch fc.Email.Transport {
+	case "", MailTransportSMTP, MailTransportSendmail, MailTransportFile:
+	case MailTransportHTTP:
+		if fc.Email.HTTP.URL == "" {
+			add("email.http.url", "required with transport = \"http\"")
+		}
+		if fc.Email.HTTP.Body == "" {
+			add("email.http.body", "required with transport = \"http\"")
+		}
+	default:
+		add("email.transport", "must be \"smtp\", \"sendmail\", \"http\" or \"file\", got %q", fc.Email.Transport)
+	}
+	errs = append(errs, validateWebhook("email.http", fc.Email.HTTP)...)
+	if dk := fc.Email.DKIM; dk.Domain != "" || dk.Selector != "" || dk.PrivateKeyFile != "" {
+		if dk.Domain == "" {
+			add("email.dkim.domain", "required for DKIM signing")
+		}
+		if dk.Selector == "" {
+			add("email.dkim.selector", "required for DKIM signing")
+		}
+		if dk.PrivateKeyFile == "" {
+			add("email.dkim.private_key_file", "required for DKIM signing")
+		} else if _, err := os.Stat(dk.PrivateKeyFile); err != nil {
+			add("email.dkim.private_key_file", "%v", err)
+		}
+	}
+	if _, _, err := mailer.ParseCanonicalization(fc.Email.DKIM.Canonicalization); err != nil {
+		add("email.dkim.canonicalization", "must be \"simple\" or \"relaxed\", optionally as \"header/body\", got %q", fc.Email.DKIM.Canonicalization)
+	}
+	for i, h := range fc.Email.DKIM.Headers {
+		if strings.TrimSpace(h) == "" || strings.ContainsAny(h, ": ") {
+			add(fmt.Sprintf("email.dkim.headers[%d]", i), "invalid header name %q", h)
+		}
+	}
+	if fc.Email.TemplatesDir != "" {
+		if fi, err := os.Stat(fc.Email.TemplatesDir); err != nil || !fi.IsDir() {
+			add("email.templates_dir", "not a readable directory: %s", fc.Email.TemplatesDir)
+		}
+	}
+
+	return errs
+}
+
+// validateSMSProvider checks the type-specific settings of an [[sms_providers]] entry.
+func validateSMSProvider(prefix string, p SMSProviderConfig) []ValidationError {
+	var errs []ValidationError
+	add := func(field, format string, args ...any) {
+		errs = append(errs, ValidationError{Field: prefix + "." + field, Message: fmt.Sprintf(format, args...)})
+	}
+	required := func(field, value string) {
+		if p.Enabled && value == "" {
+			add(field, "required for type %q", p.Type)
+		}
+	}
+
+	switch p.Type {
+	case "", SMSTypeWebhook:
+		return validateWebhook(prefix, p.WebhookConfig)
+	case SMSTypeCM:
+		required("cm.product_token", p.CM.ProductToken)
+		required("sender", p.Sender)
+	case SMSTypeMessageBird:
+		required("messagebird.access_key", p.MessageBird.AccessKey)
+		required("sender", p.Sender)
+	case SMSTypeTwilio:
+		required("twilio.account_sid", p.Twilio.AccountSID)
+		required("twilio.auth_token", p.Twilio.AuthToken)
+		if p.Enabled && p.Sender == "" && p.Twilio.MessagingServiceSID == "" {
+			add("sender", "required unless twilio.messaging_service_sid is set")
+		}
+	case SMSTypeSMPP:
+		required("smpp.address", p.SMPP.Address)
+		required("smpp.system_id", p.SMPP.SystemID)
+		if p.SMPP.Address != "" {
+			if _, _, err := net.SplitHostPort(p.SMPP.Address); err != nil {
+				add("smpp.address", "must be host:port: %v", err)
+			}
+		}
+		if p.SMPP.EnquireLink < 0 {
+			add("smpp.enquire_link", "must not be negative")
+		}
+	default:
+		add("type", "unknown SMS provider type %q", p.Type)
+		return errs
+	}
+
+	if p.URL != "" && p.Type != SMSTypeSMPP {
+		if err := checkURL(p.URL); err != nil {
+			add("url", "%v", err)
+		}
+	}
+	if p.Sender != "" && !validSMSSender(p.Sender) {
+		add("sender", "must be a phone number or up to 11 letters and digits, got %q", p.Sender)
+	}
+	if p.ReportURL != "" {
+		if err := checkURL(p.ReportURL); err != nil {
+			add("report_url", "%v", err)
+		}
+		if p.ReportToken == "" {
+			add("report_token", "required with report_url")
+		}
+	}
+	return append(errs, validateHookCommon(prefix, p.WebhookConfig)...)
+}
+
+// validSMSSender reports whether s is a usable sender: a number of up to 16
+// digits (optionally with +) or an alphanumeric ID of up to 11 characters.
+func validSMSSender(s string) bool {
+	if digits := strings.TrimPrefix(s, "+"); digits != "" && strings.Trim(digits, "0123456789") == "" {
+		return len(digits) <= 16
+	}
+	if len(s) > 11 {
+		return false
+	}
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == ' ') {
+			return false
+		}
+	}
+	return true
+}
+
+func validateWebhook(prefix string, wc WebhookConfig) []ValidationError {
+	var errs []ValidationError
+	add := func(field, format string, args ...any) {
+		errs = append(errs, ValidationError{Field: prefix + "." + field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if wc.Enabled {
+		if wc.URL == "" {
+			add("url", "required when enabled")
+		}
+		if wc.Body == "" {
+			add("body", "required when enabled")
+		}
+	}
+
+	if wc.URL != "" {
+		if err := checkTemplate(wc.URL); err != nil {
+			add("url", "invalid template: %v", err)
+		} else if err := checkURL(wc.URL); err != nil {
+			add("url", "%v", err)
+		}
+	}
+	if wc.Method != "" && !validMethods[strings.ToUpper(wc.Method)] {
+		add("method", "unsupported HTTP method %q", wc.Method)
+	}
+	if wc.RollbackURL != "" {
+		if err := checkTemplate(wc.RollbackURL); err != nil {
+			add("rollback_url", "invalid template: %v", err)
+		} else if err := checkURL(wc.RollbackURL); err != nil {
+			add("rollback_url", "%v", err)
+		}
+	}
+	if wc.RollbackMethod != "" && !validMethods[strings.ToUpper(wc.RollbackMethod)] {
+		add("rollback_method", "unsupported HTTP method %q", wc.RollbackMethod)
+	}
+	if err := checkTemplate(wc.RollbackBody); err != nil {
+		add("rollback_body", "invalid template: %v", err)
+	}
+	if err := checkTemplate(wc.Body); err != nil {
+		add("body", "invalid template: %v", err)
+	}
+	if wc.SigningSecret != "" && !strings.Contains(wc.SigningSecret, "${") {
+		if _, err := webhooksig.DecodeSecret(wc.SigningSecret); err != nil {
+			add("signing_secret", "%v", err)
+		}
+	}
+	for j, hdr := range wc.Headers {
+		if strings.TrimSpace(hdr.Key) == "" {
+			add(fmt.Sprintf("headers[%d].key", j), "must not be empty")
+		}
+		if err := checkTemplate(hdr.Value); err != nil {
+			add(fmt.Sprintf("headers[%d].value", j), "invalid template: %v", err)
+		}
+	}
+
+	return append(errs, validateHookCommon(prefix, wc)...)
+}
+
+// validatePasswordHook validates a [[password_hooks]] entry according to its type.
+func validatePasswordHook(prefix string, wc WebhookConfig) []ValidationError {
+	switch wc.Type {
+	case "", HookTypeWebhook:
+		return validateWebhook(prefix, wc)
+	case HookTypeLDAP:
+		return append(validateLDAP(prefix, wc), validateHookCommon(prefix, wc)...)
+	case HookTypeExec:
+		return append(validateExec(prefix, wc), validateHookCommon(prefix, wc)...)
+	case HookTypeFile:
+		return append(validateFileHook(prefix, wc), validateHookCommon(prefix, wc)...)
+	default:
+		return []ValidationError{{Field: prefix + ".type", Message: fmt.Sprintf("unknown hook type %q", wc.Type)}}
+	}
+}
+
+// validateHookCommon checks the settings shared by all hook types.
+func validateHookCommon(prefix string, wc WebhookConfig) []ValidationError {
+	var errs []ValidationError
+	add := func(field, format string, args ...any) {
+		errs = append(errs, ValidationError{Field: prefix + "." + field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if wc.CAFile != "" || wc.ClientCertFile != "" || wc.ClientKeyFile != "" {
+		if _, err := wc.TLSOptions().Config(); err != nil {
+			add("tls", "%v", err)
+		}
+	}
+	if wc.Timeout < 0 {
+		add("timeout", "must not be negative")
+	}
+	if wc.MaxAttempts < 0 {
+		add("max_attempts", "must not be negative")
+	}
+	if wc.RetryBackoff < 0 {
+		add("retry_backoff", "must not be negative")
+	}
+
+	return errs
+}
+
+func validateLDAP(prefix string, wc WebhookConfig) []ValidationError {
+	var errs []ValidationError
+	add := func(field, format string, args ...any) {
+		errs = append(errs, ValidationError{Field: prefix + ".ldap." + field, Message: fmt.Sprintf(format, args...)})
+	}
+	lc := wc.LDAP
+
+	if wc.Enabled {
+		if lc.URL == "" {
+			add("url", "required when enabled")
+		}
+		if lc.UserDN == "" {
+			add("user_dn", "required when enabled")
+		}
+	}
+	if lc.URL != "" && !strings.HasPrefix(lc.URL, "${") {
+		lower := strings.ToLower(lc.URL)
+		if !strings.HasPrefix(lower, "ldap://") && !strings.HasPrefix(lower, "ldaps://") {
+			add("url", "must start with ldap:// or ldaps://")
+		} else if lc.StartTLS && strings.HasPrefix(lower, "ldaps://") {
+			add("start_tls", "cannot be combined with ldaps://")
+		}
+	}
+	if err := checkTemplate(lc.UserDN); err != nil {
+		add("user_dn", "invalid template: %v", err)
+	}
+	if err := checkTemplate(lc.BindDN); err != nil {
+		add("bind_dn", "invalid template: %v", err)
+	}
+	switch lc.Mode {
+	case "", "modify", "exop":
+	default:
+		add("mode", "must be \"modify\" or \"exop\", got %q", lc.Mode)
+	}
+	switch lc.Hash {
+	case "", "ssha", "crypt":
+	default:
+		add("hash", "must be \"ssha\" or \"crypt\", got %q", lc.Hash)
+	}
+	if lc.Mode == "exop" && lc.Hash != "" {
+		add("hash", "not used with mode = \"exop\" (the server hashes the password)")
+	}
+	return errs
+}
+
+// plaintextVars are the template variables that must never end up in argv.
+var plaintextVars = []string{".Password", ".OldPassword"}
+
+func validateExec(prefix string, wc WebhookConfig) []ValidationError {
+	var errs []ValidationError
+	add := func(field, format string, args ...any) {
+		errs = append(errs, ValidationError{Field: prefix + ".exec." + field, Message: fmt.Sprintf(format, args...)})
+	}
+	ec := wc.Exec
+
+	if wc.Enabled && ec.Command == "" {
+		add("command", "required when enabled")
+	}
+	for j, arg := range ec.Args {
+		field := fmt.Sprintf("args[%d]", j)
+		if err := checkTemplate(arg); err != nil {
+			add(field, "invalid template: %v", err)
+		}
+		for _, v := range plaintextVars {
+			if strings.Contains(arg, v) || strings.Contains(arg, `"`+v[1:]+`"`) {
+				add(field, "must not contain {{%s}}; pass passwords on stdin", v)
+			}
+		}
+	}
+	if err := checkTemplate(ec.Stdin); err != nil {
+		add("stdin", "invalid template: %v", err)
+	}
+	for j, name := range ec.EnvAllow {
+		if name == "" || strings.ContainsAny(name, "= ") {
+			add(fmt.Sprintf("env_allow[%d]", j), "invalid environment variable name %q", name)
+		}
+	}
+	for j, code := range ec.SuccessCodes {
+		if code < 0 || code > 255 {
+			add(fmt.Sprintf("success_codes[%d]", j), "must be between 0 and 255, got %d", code)
+		}
+	}
+	return errs
+}
+
+// fileHookHashes lists the supported hashes per file hook format.
+var fileHookHashes = map[string][]string{
+	"htpasswd": {"bcrypt", "sha"},
+	"dovecot":  {"bcrypt", "ssha512"},
+}
+
+func validateFileHook(prefix string, wc WebhookConfig) []ValidationError {
+	var errs []ValidationError
+	add := func(field, format string, args ...any) {
+		errs = append(errs, ValidationError{Field: prefix + ".file." + field, Message: fmt.Sprintf(format, args...)})
+	}
+	fh := wc.File
+
+	if wc.Enabled && fh.Path == "" {
+		add("path", "required when enabled")
+	}
+	hashes, ok := fileHookHashes[fh.Format]
+	if !ok {
+		add("format", "must be \"htpasswd\" or \"dovecot\", got %q", fh.Format)
+	} else if fh.Hash != "" && !slices.Contains(hashes, fh.Hash) {
+		add("hash", "must be one of %v for format %q, got %q", hashes, fh.Format, fh.Hash)
+	}
+	if err := checkTemplate(fh.Username); err != nil {
+		add("username", "invalid template: %v", err)
+	}
+	if err := checkTemplate(fh.Extra); err != nil {
+		add("extra", "invalid template: %v", err)
+	}
+	if fh.Extra != "" && fh.Format != "dovecot" {
+		add("extra", "only used with format = \"dovecot\"")
+	}
+	return errs
+}
+
+// checkTemplate verifies that s parses as a Go text/template.
+func checkTemplate(s string) error {
+	if s == "" {
+		return nil
+	}
+	return tmpl.Check(s)
+}
+
+// checkURL verifies a webhook URL. Templated URLs only need an http(s) scheme prefix,
+// since the host may be filled in at runtime. References that could not be
+// resolved are skipped; resolveSecrets already reported them. Errors don't
+// echo the URL, which may hold a resolved secret.
+func checkURL(raw string) error {
+	if strings.HasPrefix(raw, "${") {
+		return nil
+	}
+	lower := strings.ToLower(raw)
+	if !strings.HasPrefix(lower, "http://") && !strings.HasPrefix(lower, "https://") {
+		return fmt.Errorf("must start with http:// or https://")
+	}
+	if strings.Contains(raw, "{{") || strings.Contains(raw, "${") {
+		return nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("invalid URL: %v", err)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("missing host")
+	}
+	return nil
+}
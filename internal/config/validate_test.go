@@ -0,0 +1,186 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// checkConfig writes src to a temp config.toml and checks it.
+func checkConfig(t *testing.T, src string) (FileConfig, map[string]string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte(src), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	fc, errs := CheckFileConfig(path)
+	byField := make(map[string]string, len(errs))
+	for _, e := range errs {
+		byField[e.Field] = e.Message
+	}
+	return fc, byField
+}
+
+func TestCheckFileConfigMissingFile(t *testing.T) {
+	if _, errs := CheckFileConfig(filepath.Join(t.TempDir(), "missing.toml")); len(errs) != 0 {
+		t.Fatalf("expected a missing file to be valid, got %v", errs)
+	}
+}
+
+func TestCheckFileConfigValidation(t *testing.T) {
+	_, errs := checkConfig(t, `
+unknown_top = 1
+
+[password_policy]
+min_strength = 7
+
+[sms]
+code_length = 3
+code_ttl = 30
+
+[smtp]
+port = 70000
+
+[[password_hooks]]
+name = "app"
+enabled = true
+url = "ftp://app.example.com/sync"
+body = "{{.Username"
+method = "BREW"
+`)
+
+	want := map[string]string{
+		"unknown_top":                  "unknown key",
+		"password_policy.min_strength": "must be between 0 and 4",
+		"sms.code_length":              "must be between 4 and 10",
+		"sms.code_ttl":                 "must be between 60 and 86400",
+		"smtp.port":                    "must be between 1 and 65535",
+		"password_hooks[0].url":        "must start with http:// or https://",
+		"password_hooks[0].body":       "invalid template",
+		"password_hooks[0].method":     "unsupported HTTP method",
+	}
+	for field, msg := range want {
+		if got, ok := errs[field]; !ok || !strings.Contains(got, msg) {
+			t.Errorf("%s: expected %q, got %q", field, msg, got)
+		}
+	}
+	if len(errs) != len(want) {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+}
+
+func TestCheckFileConfigResolvesRefsBeforeValidating(t *testing.T) {
+	dir := t.TempDir()
+	secretFile := filepath.Join(dir, "secret")
+	if err := os.WriteFile(secretFile, []byte("whsec_not-base64!\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_HOOK_URL", "ftp://hooks.example.com/secret-token")
+	t.Setenv("TEST_GOOD_URL", "https://hooks.example.com/sync")
+
+	fc, errs := checkConfig(t, `
+[[password_hooks]]
+name = "bad"
+enabled = true
+url = "${env:TEST_HOOK_URL}"
+body = "x"
+signing_secret = "${file:`+secretFile+`}"
+
+[[password_hooks]]
+name = "good"
+enabled = true
+url = "${env:TEST_GOOD_URL}"
+body = "x"
+`)
+
+	if msg := errs["password_hooks[0].url"]; !strings.Contains(msg, "must start with http:// or https://") {
+		t.Errorf("resolved URL not validated: %q", msg)
+	}
+	if strings.Contains(errs["password_hooks[0].url"], "secret-token") {
+		t.Errorf("error echoes the resolved URL: %q", errs["password_hooks[0].url"])
+	}
+	if msg := errs["password_hooks[0].signing_secret"]; !strings.Contains(msg, "invalid whsec_ secret") {
+		t.Errorf("resolved signing secret not validated: %q", msg)
+	}
+	if len(errs) != 2 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+	if got := fc.PasswordHooks[1].URL; got != "https://hooks.example.com/sync" {
+		t.Errorf("expected resolved URL, got %q", got)
+	}
+}
+
+func TestCheckFileConfigUnresolvableRefs(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing")
+	_, errs := checkConfig(t, `
+[[password_hooks]]
+name = "app"
+enabled = true
+url = "${env:TEST_UNSET_HOOK_URL}"
+body = "x"
+signing_secret = "${file:`+missing+`}"
+
+[[password_hooks.headers]]
+key = "Authorization"
+value = "Bearer ${env:TEST_UNSET_TOKEN}"
+
+[smtp]
+password = "${file:`+missing+`}"
+`)
+
+	want := map[string]string{
+		"password_hooks[0].url":              "environment variable TEST_UNSET_HOOK_URL is not set",
+		"password_hooks[0].signing_secret":   "read secret file",
+		"password_hooks[0].headers[0].value": "environment variable TEST_UNSET_TOKEN is not set",
+		"smtp.password":                      "read secret file",
+	}
+	for field, msg := range want {
+		if got, ok := errs[field]; !ok || !strings.Contains(got, msg) {
+			t.Errorf("%s: expected %q, got %q", field, msg, got)
+		}
+	}
+	if len(errs) != len(want) {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+}
+
+func TestResolveEnvValue(t *testing.T) {
+	t.Setenv("TEST_API_KEY", "k3y")
+	tests := []struct {
+		in, want string
+		wantErr  bool
+	}{
+		{in: "plain", want: "plain"},
+		{in: "$TEST_API_KEY", want: "k3y"},
+		{in: "key=${env:TEST_API_KEY}", want: "key=k3y"},
+		{in: "$TEST_UNSET_KEY", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ResolveEnvValue(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ResolveEnvValue(%q) = %q, %v; want %q, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestCheckFileConfigTLSFiles(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing-ca.pem")
+	_, errs := checkConfig(t, `
+[[password_hooks]]
+name = "app"
+url = "https://app.example.com/sync"
+body = "x"
+ca_file = "`+missing+`"
+
+[[sms_providers]]
+type = "cm"
+ca_file = "`+missing+`"
+`)
+
+	for _, field := range []string{"password_hooks[0].tls", "sms_providers[0].tls"} {
+		if !strings.Contains(errs[field], "read CA file") {
+			t.Errorf("%s: expected CA file error, got %q", field, errs[field])
+		}
+	}
+}
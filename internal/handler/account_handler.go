@@ -4,24 +4,43 @@ import (
 	"encoding/base64"
 	"net/http"
 
+	"tinyauth-sidecar/internal/middleware"
 	"tinyauth-sidecar/internal/service"
 
 	"github.com/gin-gonic/gin"
 )
 
-type AccountHandler struct{ account *service.AccountService }
+type AccountHandler struct {
+	account *service.AccountService
+	stepUp  *middleware.StepUp
+}
 
-func NewAccountHandler(account *service.AccountService) *AccountHandler { return &AccountHandler{account: account} }
+func NewAccountHandler(account *service.AccountService, stepUp *middleware.StepUp) *AccountHandler {
+	return &AccountHandler{account: account, stepUp: stepUp}
+}
 
-func (h *AccountHandler) Register(r *gin.RouterGroup) {
+// Register adds the account routes. Changes that could hand the account to
+// someone else (email, phone, TOTP, security codes) need a recent
+// re-authentication (POST /account/reauth).
+func (h *AccountHandler) Register(r *gin.RouterGroup, reauthRL *middleware.RateLimiter) {
 	r.GET("/account/profile", h.Profile)
 	r.POST("/account/change-password", h.ChangePassword)
-	r.POST("/account/phone", h.UpdatePhone)
-	r.POST("/account/email", h.UpdateEmail)
+	r.POST("/account/phone/verify", h.ConfirmPhone)
+	r.POST("/account/language", h.UpdateLanguage)
 	r.POST("/account/totp/setup", h.TotpSetup)
-	r.POST("/account/totp/enable", h.TotpEnable)
-	r.POST("/account/totp/disable", h.TotpDisable)
-	r.POST("/account/totp/recover", h.TotpRecover)
+	r.GET("/account/reauth", h.ReauthStatus)
+	r.POST("/account/reauth", reauthRL.Middleware(), h.Reauth)
+	r.POST("/account/otp/send", h.OTPSend)
+
+	sensitive := r.Group("", h.stepUp.Require())
+	sensitive.POST("/account/phone", h.UpdatePhone)
+	sensitive.POST("/account/email", h.UpdateEmail)
+	sensitive.POST("/account/totp/enable", h.TotpEnable)
+	sensitive.POST("/account/totp/disable", h.TotpDisable)
+	sensitive.POST("/account/totp/recover", h.TotpRecover)
+	sensitive.POST("/account/otp/setup", h.OTPSetup)
+	sensitive.POST("/account/otp/enable", h.OTPEnable)
+	sensitive.POST("/account/otp/disable", h.OTPDisable)
 }
 
 func username(c *gin.Context) string {
@@ -48,7 +67,7 @@ func (h *AccountHandler) ChangePassword(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if err := h.account.ChangePassword(username(c), req.OldPassword, req.NewPassword, c.ClientIP()); err != nil {
+	if err := h.account.ChangePassword(username(c), req.OldPassword, req.NewPassword, c.ClientIP(), c.GetHeader("Accept-Language")); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -63,7 +82,24 @@ func (h *AccountHandler) UpdatePhone(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if err := h.account.SetPhone(username(c), req.Phone); err != nil {
+	pending, err := h.account.SetPhone(username(c), req.Phone, c.ClientIP(), c.GetHeader("Accept-Language"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true, "pending": pending})
+}
+
+// ConfirmPhone activates a pending phone number with the SMS code.
+func (h *AccountHandler) ConfirmPhone(c *gin.Context) {
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.account.ConfirmPhone(username(c), req.Code, c.ClientIP()); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -78,7 +114,23 @@ func (h *AccountHandler) UpdateEmail(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if err := h.account.SetEmail(username(c), req.Email); err != nil {
+	if err := h.account.SetEmail(username(c), req.Email, c.ClientIP(), c.GetHeader("Accept-Language")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+// UpdateLanguage sets the language used for mail to the user.
+func (h *AccountHandler) UpdateLanguage(c *gin.Context) {
+	var req struct {
+		Language string `json:"language"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.account.SetLanguage(username(c), req.Language); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -107,7 +159,7 @@ func (h *AccountHandler) TotpEnable(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if err := h.account.TotpEnable(username(c), req.Secret, req.Code); err != nil {
+	if err := h.account.TotpEnable(username(c), req.Secret, req.Code, c.ClientIP(), c.GetHeader("Accept-Language")); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -115,12 +167,14 @@ func (h *AccountHandler) TotpEnable(c *gin.Context) {
 }
 
 func (h *AccountHandler) TotpDisable(c *gin.Context) {
-	var req struct{ Password string `json:"password"` }
+	var req struct {
+		Password string `json:"password"`
+	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if err := h.account.TotpDisable(username(c), req.Password); err != nil {
+	if err := h.account.TotpDisable(username(c), req.Password, c.ClientIP(), c.GetHeader("Accept-Language")); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -137,9 +191,89 @@ func (h *AccountHandler) TotpRecover(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if err := h.account.TotpRecover(username(c), req.RecoveryKey, req.Secret, req.Code); err != nil {
+	if err := h.account.TotpRecover(username(c), req.RecoveryKey, req.Secret, req.Code, c.ClientIP(), c.GetHeader("Accept-Language")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+// OTPSetup sends an enrollment code for the sidecar second factor.
+func (h *AccountHandler) OTPSetup(c *gin.Context) {
+	var req struct {
+		Method string `json:"method"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.account.OTPSetup(username(c), req.Method, c.ClientIP(), c.GetHeader("Accept-Language")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+// OTPEnable confirms the enrollment code and enables the second factor.
+func (h *AccountHandler) OTPEnable(c *gin.Context) {
+	var req struct {
+		Method string `json:"method"`
+		Code   string `json:"code"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.account.OTPEnable(username(c), req.Method, req.Code, c.ClientIP()); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+// OTPDisable removes the second factor.
+func (h *AccountHandler) OTPDisable(c *gin.Context) {
+	if err := h.account.OTPDisable(username(c), c.ClientIP()); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+// OTPSend sends a step-up code over the user's second factor for Reauth.
+func (h *AccountHandler) OTPSend(c *gin.Context) {
+	if err := h.account.SendStepUpCode(username(c), c.ClientIP(), c.GetHeader("Accept-Language")); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"ok": true})
 }
+
+// Reauth checks the password or a TOTP code (plus a security code when
+// enabled) and grants the step-up cookie.
+func (h *AccountHandler) Reauth(c *gin.Context) {
+	var req struct {
+		Password string `json:"password"`
+		TOTP     string `json:"totp"`
+		OTP      string `json:"otp"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.account.Reauthenticate(username(c), req.Password, req.TOTP, req.OTP, c.ClientIP()); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	exp := h.stepUp.Grant(c, username(c))
+	c.JSON(http.StatusOK, gin.H{"ok": true, "expiresAt": exp.Unix()})
+}
+
+// ReauthStatus reports until when the step-up cookie is valid (0 if not).
+func (h *AccountHandler) ReauthStatus(c *gin.Context) {
+	var exp int64
+	if until := h.stepUp.Until(c, username(c)); !until.IsZero() {
+		exp = until.Unix()
+	}
+	c.JSON(http.StatusOK, gin.H{"expiresAt": exp})
+}
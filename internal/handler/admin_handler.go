@@ -1,10 +1,12 @@
 package handler
 
 import (
+	"errors"
 	"log"
 	"net/http"
 
 	"tinyauth-sidecar/internal/config"
+	"tinyauth-sidecar/internal/middleware"
 	"tinyauth-sidecar/internal/provider"
 	"tinyauth-sidecar/internal/service"
 	"tinyauth-sidecar/internal/store"
@@ -15,14 +17,17 @@ import (
 type AdminHandler struct {
 	cfg       *config.Config
 	mail      *service.MailService
-	sms       provider.SMSProvider
+	sms       *provider.SMSRouter
+	smsLog    *store.SMSLog
 	usersSvc  *service.UserFileService
 	store     *store.Store
 	dockerSvc *service.DockerService
+	hooks     *service.HookDeliveryService
+	stepUp    *middleware.StepUp
 }
 
-func NewAdminHandler(cfg *config.Config, mail *service.MailService, sms provider.SMSProvider, usersSvc *service.UserFileService, st *store.Store, dockerSvc *service.DockerService) *AdminHandler {
-	return &AdminHandler{cfg: cfg, mail: mail, sms: sms, usersSvc: usersSvc, store: st, dockerSvc: dockerSvc}
+func NewAdminHandler(cfg *config.Config, mail *service.MailService, sms *provider.SMSRouter, smsLog *store.SMSLog, usersSvc *service.UserFileService, st *store.Store, dockerSvc *service.DockerService, hooks *service.HookDeliveryService, stepUp *middleware.StepUp) *AdminHandler {
+	return &AdminHandler{cfg: cfg, mail: mail, sms: sms, smsLog: smsLog, usersSvc: usersSvc, store: st, dockerSvc: dockerSvc, hooks: hooks, stepUp: stepUp}
 }
 
 // isAdmin checks whether the authenticated user has role "admin".
@@ -48,14 +53,24 @@ func (h *AdminHandler) requireAdmin() gin.HandlerFunc {
 	}
 }
 
+// Register adds the admin routes. Reads only need the admin role; actions
+// also need a recent re-authentication (POST /account/reauth).
 func (h *AdminHandler) Register(r *gin.RouterGroup) {
 	admin := r.Group("", h.requireAdmin())
-	admin.POST("/admin/test-email", h.TestEmail)
-	admin.POST("/admin/test-sms", h.TestSMS)
+	admin.GET("/admin/sms-messages", h.SMSMessages)
 	admin.GET("/admin/status", h.Status)
-	admin.POST("/admin/reload-config", h.ReloadConfig)
-	admin.POST("/admin/restart-tinyauth", h.RestartTinyauth)
+	admin.GET("/admin/config/check", h.CheckConfig)
 	admin.GET("/admin/tinyauth-health", h.TinyauthHealth)
+	admin.GET("/admin/hook-deliveries", h.HookDeliveries)
+
+	actions := admin.Group("", h.stepUp.Require())
+	actions.POST("/admin/test-email", h.TestEmail)
+	actions.POST("/admin/test-sms", h.TestSMS)
+	actions.POST("/admin/reload-config", h.ReloadConfig)
+	actions.POST("/admin/restart-tinyauth", h.RestartTinyauth)
+	actions.POST("/admin/hook-deliveries/:id/retry", h.RetryHookDelivery)
+	actions.DELETE("/admin/hook-deliveries/:id", h.DiscardHookDelivery)
+	actions.POST("/admin/password-hooks/test", h.TestPasswordHook)
 }
 
 func (h *AdminHandler) TestEmail(c *gin.Context) {
@@ -67,16 +82,19 @@ func (h *AdminHandler) TestEmail(c *gin.Context) {
 		return
 	}
 
-	if err := h.mail.SendTestEmail(req.To); err != nil {
+	if err := h.mail.SendTestEmail(req.To, c.GetHeader("Accept-Language")); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"ok": true})
 }
 
+// TestSMS sends a test SMS through the normal routing, or only through the
+// provider named in "provider".
 func (h *AdminHandler) TestSMS(c *gin.Context) {
 	var req struct {
-		To string `json:"to"`
+		To       string `json:"to"`
+		Provider string `json:"provider"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil || req.To == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "missing 'to' field"})
@@ -88,32 +106,87 @@ func (h *AdminHandler) TestSMS(c *gin.Context) {
 		return
 	}
 
-	if err := h.sms.SendSMS(req.To, "TinyAuth test SMS"); err != nil {
+	var err error
+	if req.Provider != "" {
+		err = h.sms.SendSMSVia(req.Provider, req.To, "TinyAuth test SMS")
+	} else {
+		err = h.sms.SendSMS(req.To, "TinyAuth test SMS")
+	}
+	if errors.Is(err, provider.ErrUnknownSMSProvider) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"ok": true})
 }
 
+// SMSMessages lists recently sent SMS with their delivery status, newest first.
+func (h *AdminHandler) SMSMessages(c *gin.Context) {
+	type smsMessage struct {
+		ID        string `json:"id"`
+		Provider  string `json:"provider"`
+		To        string `json:"to"`
+		Status    string `json:"status"`
+		Detail    string `json:"detail,omitempty"`
+		CreatedAt int64  `json:"createdAt"`
+		UpdatedAt int64  `json:"updatedAt"`
+	}
+	messages := []smsMessage{}
+	for _, e := range h.smsLog.List() {
+		messages = append(messages, smsMessage(e))
+	}
+	c.JSON(http.StatusOK, gin.H{"messages": messages})
+}
+
 func (h *AdminHandler) Status(c *gin.Context) {
 	userCount := 0
 	if users, err := h.usersSvc.ReadAll(); err == nil {
 		userCount = len(users)
 	}
 
+	pending, failed := h.hooks.Counts()
+	mailPending, mailFailed := h.mail.QueueCounts()
+	smsProviders := []provider.SMSProviderStatus{}
+	if h.sms != nil {
+		smsProviders = h.sms.Status()
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"email":           h.cfg.SMTPHost != "",
-		"sms":             h.sms != nil,
-		"usernameIsEmail": h.cfg.UsernameIsEmail,
-		"userCount":       userCount,
+		"email":                 h.cfg.MailEnabled(),
+		"sms":                   h.sms != nil,
+		"smsProviders":          smsProviders,
+		"usernameIsEmail":       h.cfg.UsernameIsEmail,
+		"userCount":             userCount,
+		"hookDeliveriesPending": pending,
+		"hookDeliveriesFailed":  failed,
+		"mailQueuePending":      mailPending,
+		"mailQueueFailed":       mailFailed,
 	})
 }
 
 func (h *AdminHandler) ReloadConfig(c *gin.Context) {
-	fileCfg := config.LoadFileConfig()
+	fileCfg, errs := config.LoadFileConfig()
+	if len(errs) > 0 && h.cfg.ConfigStrict {
+		log.Printf("[admin] config.toml reload by %s rejected: %d problem(s)", username(c), len(errs))
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "invalid config", "errors": errs})
+		return
+	}
 	h.cfg.ApplyFileConfig(fileCfg)
 	log.Printf("[admin] config.toml reloaded by %s", username(c))
-	c.JSON(http.StatusOK, gin.H{"ok": true})
+	c.JSON(http.StatusOK, gin.H{"ok": true, "errors": errs})
+}
+
+// CheckConfig validates config.toml on disk without applying it.
+func (h *AdminHandler) CheckConfig(c *gin.Context) {
+	path := config.ConfigPath()
+	_, errs := config.CheckFileConfig(path)
+	if errs == nil {
+		errs = []config.ValidationError{}
+	}
+	c.JSON(http.StatusOK, gin.H{"path": path, "valid": len(errs) == 0, "errors": errs})
 }
 
 func (h *AdminHandler) RestartTinyauth(c *gin.Context) {
@@ -133,3 +206,72 @@ func (h *AdminHandler) TinyauthHealth(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, gin.H{"running": running})
 }
+
+// HookDeliveries lists pending and failed password hook deliveries.
+func (h *AdminHandler) HookDeliveries(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"deliveries": h.hooks.Deliveries()})
+}
+
+func (h *AdminHandler) RetryHookDelivery(c *gin.Context) {
+	if err := h.hooks.Retry(c.Param("id"), username(c)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+func (h *AdminHandler) DiscardHookDelivery(c *gin.Context) {
+	if err := h.hooks.Discard(c.Param("id"), username(c)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+// TestPasswordHook renders a password hook's request for a sample user with the
+// password redacted and, with "send": true, performs it.
+func (h *AdminHandler) TestPasswordHook(c *gin.Context) {
+	var req struct {
+		Hook        string `json:"hook"`
+		Username    string `json:"username"`
+		Password    string `json:"password"`
+		OldPassword string `json:"oldPassword"`
+		Role        string `json:"role"`
+		Send        bool   `json:"send"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Hook == "" || req.Username == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing 'hook' or 'username' field"})
+		return
+	}
+	if req.Password == "" {
+		req.Password = "Sample-Passw0rd!"
+	}
+	if req.Role == "" {
+		if meta := h.store.GetUserMeta(req.Username); meta != nil {
+			req.Role = meta.Role
+		}
+	}
+	hashed, err := service.HashPassword(req.Password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := provider.PasswordChangeContext{
+		Email:          req.Username,
+		Password:       req.Password,
+		HashedPassword: hashed,
+		OldPassword:    req.OldPassword,
+		Role:           req.Role,
+	}
+	res, err := h.hooks.DryRun(req.Hook, ctx, req.Send, username(c))
+	if errors.Is(err, service.ErrHookNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, res)
+}
@@ -4,16 +4,18 @@ import (
 	"net/http"
 
 	"tinyauth-sidecar/internal/config"
+	"tinyauth-sidecar/internal/middleware"
 
 	"github.com/gin-gonic/gin"
 )
 
 type AuthHandler struct {
-	cfg *config.Config
+	cfg    *config.Config
+	stepUp *middleware.StepUp
 }
 
-func NewAuthHandler(cfg *config.Config) *AuthHandler {
-	return &AuthHandler{cfg: cfg}
+func NewAuthHandler(cfg *config.Config, stepUp *middleware.StepUp) *AuthHandler {
+	return &AuthHandler{cfg: cfg, stepUp: stepUp}
 }
 
 func (h *AuthHandler) Register(r *gin.RouterGroup) {
@@ -28,5 +30,6 @@ func (h *AuthHandler) Check(c *gin.Context) {
 }
 
 func (h *AuthHandler) Logout(c *gin.Context) {
+	h.stepUp.Revoke(c)
 	c.JSON(http.StatusOK, gin.H{"ok": true, "redirectUrl": h.cfg.TinyauthLogoutURL})
 }
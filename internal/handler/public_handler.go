@@ -19,9 +19,10 @@ func NewPublicHandler(account *service.AccountService, cfg *config.Config) *Publ
 	return &PublicHandler{account: account, cfg: cfg}
 }
 
-func (h *PublicHandler) Register(r *gin.RouterGroup, resetEmailRL, forgotSmsRL, resetSmsRL *middleware.RateLimiter) {
+func (h *PublicHandler) Register(r *gin.RouterGroup, resetEmailRL, forgotSmsRL, resetSmsRL, verifyEmailRL *middleware.RateLimiter) {
 	r.POST("/password-reset/request", resetEmailRL.Middleware(), h.RequestReset)
 	r.POST("/password-reset/confirm", h.ConfirmReset)
+	r.POST("/email/verify", verifyEmailRL.Middleware(), h.VerifyEmail)
 	r.GET("/health", h.Health)
 	r.GET("/features", h.Features)
 	r.POST("/auth/forgot-password-sms", forgotSmsRL.Middleware(), h.ForgotPasswordSMS)
@@ -40,7 +41,7 @@ func (h *PublicHandler) RequestReset(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	_ = h.account.RequestPasswordReset(req.Username, c.ClientIP())
+	_ = h.account.RequestPasswordReset(req.Username, c.ClientIP(), c.GetHeader("Accept-Language"))
 	c.JSON(http.StatusOK, gin.H{"ok": true, "message": "If user exists, reset email sent"})
 }
 
@@ -53,7 +54,23 @@ func (h *PublicHandler) ConfirmReset(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if err := h.account.ResetPassword(req.Token, req.NewPassword, c.ClientIP()); err != nil {
+	if err := h.account.ResetPassword(req.Token, req.NewPassword, c.ClientIP(), c.GetHeader("Accept-Language")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+// VerifyEmail confirms a pending email change with the token from the verification link.
+func (h *PublicHandler) VerifyEmail(c *gin.Context) {
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.account.VerifyEmail(req.Token, c.ClientIP(), c.GetHeader("Accept-Language")); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -63,7 +80,7 @@ func (h *PublicHandler) ConfirmReset(c *gin.Context) {
 func (h *PublicHandler) Features(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"smsEnabled":      h.account.SMSEnabled(),
-		"emailEnabled":    h.cfg.SMTPHost != "",
+		"emailEnabled":    h.cfg.MailEnabled(),
 		"usernameIsEmail": h.cfg.UsernameIsEmail,
 		"backgroundImage": h.cfg.BackgroundImage,
 		"title":           h.cfg.Title,
@@ -82,7 +99,7 @@ func (h *PublicHandler) ForgotPasswordSMS(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "phone required"})
 		return
 	}
-	_ = h.account.RequestSMSReset(req.Phone, c.ClientIP())
+	_ = h.account.RequestSMSReset(req.Phone, c.ClientIP(), c.GetHeader("Accept-Language"))
 	c.JSON(http.StatusOK, gin.H{"ok": true, "message": "If a user is associated with this phone, a code was sent"})
 }
 
@@ -100,7 +117,7 @@ func (h *PublicHandler) ResetPasswordSMS(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "phone, code, and newPassword required"})
 		return
 	}
-	if err := h.account.ResetPasswordSMS(req.Phone, req.Code, req.NewPassword, c.ClientIP()); err != nil {
+	if err := h.account.ResetPasswordSMS(req.Phone, req.Code, req.NewPassword, c.ClientIP(), c.GetHeader("Accept-Language")); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"tinyauth-sidecar/internal/provider"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SMSReportHandler receives delivery reports from SMS gateways. Gateways
+// can't send CSRF tokens, so it is registered outside the CSRF-protected
+// group; each provider's report_token authenticates the callback instead.
+type SMSReportHandler struct {
+	sms *provider.SMSRouter
+}
+
+func NewSMSReportHandler(sms *provider.SMSRouter) *SMSReportHandler {
+	return &SMSReportHandler{sms: sms}
+}
+
+func (h *SMSReportHandler) Register(r *gin.RouterGroup) {
+	r.GET("/sms/reports/:provider", h.Report)
+	r.POST("/sms/reports/:provider", h.Report)
+}
+
+func (h *SMSReportHandler) Report(c *gin.Context) {
+	if h.sms == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "SMS not configured"})
+		return
+	}
+	name := c.Param("provider")
+	err := h.sms.HandleDeliveryReport(name, c.Query("token"), c.Request)
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	case errors.Is(err, provider.ErrUnknownSMSProvider), errors.Is(err, provider.ErrReportsDisabled):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, provider.ErrReportToken):
+		log.Printf("[sms] rejected delivery report for %s from %s: bad token", name, c.ClientIP())
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	default:
+		log.Printf("[sms] bad delivery report for %s: %v", name, err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,117 @@
+// Package httpclient builds HTTP clients for outbound calls (webhooks, tinyauth)
+// with custom CA bundles and optional client certificates for mutual TLS.
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TLSOptions configures TLS for an outbound client.
+type TLSOptions struct {
+	// CAFile is a PEM bundle trusted in addition to the system roots and the global extra CAs.
+	CAFile string
+	// ClientCertFile and ClientKeyFile enable mutual TLS. Both must be set.
+	ClientCertFile string
+	ClientKeyFile  string
+	// SkipVerify disables server certificate verification. Prefer CAFile.
+	SkipVerify bool
+}
+
+var (
+	extraMu  sync.RWMutex
+	extraPEM [][]byte
+)
+
+// SetExtraCAFiles loads PEM bundles (comma-separated paths) that every client
+// trusts in addition to the system roots. Called once at startup with EXTRA_CA_FILE.
+func SetExtraCAFiles(paths string) error {
+	var pems [][]byte
+	for _, p := range strings.Split(paths, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		data, err := readPEM(p)
+		if err != nil {
+			return err
+		}
+		pems = append(pems, data)
+	}
+
+	extraMu.Lock()
+	extraPEM = pems
+	extraMu.Unlock()
+	return nil
+}
+
+// New returns an HTTP client with the given timeout and TLS options.
+func New(timeout time.Duration, opts TLSOptions) (*http.Client, error) {
+	tlsCfg, err := opts.Config()
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsCfg
+	return &http.Client{Timeout: timeout, Transport: transport}, nil
+}
+
+// Config builds the tls.Config for opts.
+func (opts TLSOptions) Config() (*tls.Config, error) {
+	if opts.SkipVerify {
+		return &tls.Config{InsecureSkipVerify: true}, nil
+	}
+
+	tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	extraMu.RLock()
+	pems := append([][]byte(nil), extraPEM...)
+	extraMu.RUnlock()
+	if opts.CAFile != "" {
+		data, err := readPEM(opts.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pems = append(pems, data)
+	}
+	if len(pems) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		for _, data := range pems {
+			pool.AppendCertsFromPEM(data)
+		}
+		tlsCfg.RootCAs = pool
+	}
+
+	if opts.ClientCertFile != "" || opts.ClientKeyFile != "" {
+		if opts.ClientCertFile == "" || opts.ClientKeyFile == "" {
+			return nil, fmt.Errorf("client_cert_file and client_key_file must both be set")
+		}
+		cert, err := tls.LoadX509KeyPair(opts.ClientCertFile, opts.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+	return tlsCfg, nil
+}
+
+// readPEM reads a CA bundle and checks that it contains at least one certificate.
+func readPEM(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read CA file: %w", err)
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("CA file %s contains no PEM certificates", path)
+	}
+	return data, nil
+}
@@ -0,0 +1,281 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DKIM canonicalization algorithms (RFC 6376 section 3.4).
+const (
+	CanonSimple  = "simple"
+	CanonRelaxed = "relaxed"
+)
+
+// DefaultDKIMHeaders are the header fields signed when none are configured.
+var DefaultDKIMHeaders = []string{"From", "To", "Cc", "Reply-To", "Subject", "Date", "Message-Id", "MIME-Version", "Content-Type"}
+
+// DKIMOptions configures a DKIM signer.
+type DKIMOptions struct {
+	Domain   string
+	Selector string
+	// KeyFile is a PEM RSA (PKCS#1 or PKCS#8) or Ed25519 (PKCS#8) private key.
+	KeyFile string
+	// Headers lists the header fields to sign; From is always signed.
+	Headers []string
+	// Canonicalization is "header/body", e.g. "relaxed/simple"; a single
+	// value applies to both. Empty means "relaxed/relaxed".
+	Canonicalization string
+}
+
+// DKIMSigner adds a DKIM-Signature header to outgoing messages.
+type DKIMSigner struct {
+	domain, selector string
+	headers          []string
+	headerC, bodyC   string
+	algorithm        string
+	signer           crypto.Signer
+}
+
+// NewDKIMSigner loads the private key and checks the options.
+func NewDKIMSigner(opts DKIMOptions) (*DKIMSigner, error) {
+	if opts.Domain == "" || opts.Selector == "" {
+		return nil, errors.New("dkim: domain and selector are required")
+	}
+	headerC, bodyC, err := ParseCanonicalization(opts.Canonicalization)
+	if err != nil {
+		return nil, err
+	}
+	key, algorithm, err := loadDKIMKey(opts.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	headers := opts.Headers
+	if len(headers) == 0 {
+		headers = DefaultDKIMHeaders
+	}
+	hasFrom := false
+	for _, h := range headers {
+		if strings.EqualFold(h, "From") {
+			hasFrom = true
+		}
+	}
+	if !hasFrom {
+		headers = append([]string{"From"}, headers...)
+	}
+
+	return &DKIMSigner{
+		domain:    opts.Domain,
+		selector:  opts.Selector,
+		headers:   headers,
+		headerC:   headerC,
+		bodyC:     bodyC,
+		algorithm: algorithm,
+		signer:    key,
+	}, nil
+}
+
+// Domain returns the signing domain (d=).
+func (s *DKIMSigner) Domain() string {
+	return s.domain
+}
+
+// ParseCanonicalization splits "header/body" into its parts, defaulting to relaxed.
+func ParseCanonicalization(c string) (header, body string, err error) {
+	if c == "" {
+		return CanonRelaxed, CanonRelaxed, nil
+	}
+	header, body, found := strings.Cut(strings.ToLower(c), "/")
+	if !found {
+		body = header
+	}
+	for _, v := range []string{header, body} {
+		if v != CanonSimple && v != CanonRelaxed {
+			return "", "", fmt.Errorf("dkim: canonicalization must be simple or relaxed, got %q", c)
+		}
+	}
+	return header, body, nil
+}
+
+func loadDKIMKey(path string) (crypto.Signer, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("dkim key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, "", fmt.Errorf("dkim key %s: no PEM block", path)
+	}
+	var key any
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, "", fmt.Errorf("dkim key %s: unsupported PEM type %q", path, block.Type)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("dkim key %s: %w", path, err)
+	}
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return k, "rsa-sha256", nil
+	case ed25519.PrivateKey:
+		return k, "ed25519-sha256", nil
+	default:
+		return nil, "", fmt.Errorf("dkim key %s: unsupported key type %T", path, key)
+	}
+}
+
+// Sign returns msg with a DKIM-Signature header prepended. Line endings are
+// normalized to CRLF first, so the signed and the sent bytes are identical.
+func (s *DKIMSigner) Sign(msg []byte) ([]byte, error) {
+	msg = normalizeCRLF(msg)
+	head, body, found := bytes.Cut(msg, []byte("\r\n\r\n"))
+	if !found {
+		head, body = bytes.TrimSuffix(msg, []byte("\r\n")), nil
+	} else {
+		head = msg[:len(head)+2]
+	}
+	fields := splitHeaderFields(head)
+
+	bodyHash := sha256.Sum256(canonicalBody(body, s.bodyC))
+
+	// Sign the last not yet used instance of each listed field (RFC 6376 5.4.2).
+	h := sha256.New()
+	used := make(map[int]bool)
+	var signed []string
+	for _, name := range s.headers {
+		for i := len(fields) - 1; i >= 0; i-- {
+			if used[i] || !strings.EqualFold(fieldName(fields[i]), name) {
+				continue
+			}
+			used[i] = true
+			h.Write([]byte(canonicalHeader(fields[i], s.headerC)))
+			signed = append(signed, strings.ToLower(name))
+			break
+		}
+	}
+
+	value := fmt.Sprintf("v=1; a=%s; c=%s/%s; d=%s; s=%s;\r\n\tt=%s; h=%s;\r\n\tbh=%s;\r\n\tb=",
+		s.algorithm, s.headerC, s.bodyC, s.domain, s.selector,
+		strconv.FormatInt(time.Now().Unix(), 10), strings.Join(signed, ":"),
+		base64.StdEncoding.EncodeToString(bodyHash[:]))
+	sigField := "DKIM-Signature: " + value
+	h.Write([]byte(strings.TrimSuffix(canonicalHeader(sigField+"\r\n", s.headerC), "\r\n")))
+	digest := h.Sum(nil)
+
+	var sig []byte
+	var err error
+	if s.algorithm == "ed25519-sha256" {
+		sig, err = s.signer.Sign(rand.Reader, digest, crypto.Hash(0))
+	} else {
+		sig, err = s.signer.Sign(rand.Reader, digest, crypto.SHA256)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("dkim sign: %w", err)
+	}
+
+	var out bytes.Buffer
+	out.WriteString(sigField)
+	out.WriteString(foldBase64(base64.StdEncoding.EncodeToString(sig)))
+	out.WriteString("\r\n")
+	out.Write(msg)
+	return out.Bytes(), nil
+}
+
+// normalizeCRLF converts bare LF line endings to CRLF.
+func normalizeCRLF(msg []byte) []byte {
+	if !bytes.Contains(msg, []byte("\n")) || bytes.Count(msg, []byte("\r\n")) == bytes.Count(msg, []byte("\n")) {
+		return msg
+	}
+	msg = bytes.ReplaceAll(msg, []byte("\r\n"), []byte("\n"))
+	return bytes.ReplaceAll(msg, []byte("\n"), []byte("\r\n"))
+}
+
+// splitHeaderFields splits a CRLF header block into fields, keeping folded
+// continuation lines and the trailing CRLF with their field.
+func splitHeaderFields(head []byte) []string {
+	var fields []string
+	for _, line := range strings.SplitAfter(string(head), "\r\n") {
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			fields[len(fields)-1] += line
+			continue
+		}
+		fields = append(fields, line)
+	}
+	return fields
+}
+
+func fieldName(field string) string {
+	name, _, _ := strings.Cut(field, ":")
+	return strings.TrimRight(name, " \t")
+}
+
+// canonicalHeader canonicalizes one header field including its CRLF.
+func canonicalHeader(field, c string) string {
+	if c == CanonSimple {
+		return field
+	}
+	name, value, _ := strings.Cut(field, ":")
+	value = strings.ReplaceAll(value, "\r\n", "")
+	value = strings.Join(strings.FieldsFunc(value, isWSP), " ")
+	return strings.ToLower(strings.TrimRight(name, " \t")) + ":" + value + "\r\n"
+}
+
+// canonicalBody canonicalizes the message body (RFC 6376 3.4.3 and 3.4.4).
+func canonicalBody(body []byte, c string) []byte {
+	if c == CanonRelaxed {
+		lines := strings.Split(string(body), "\r\n")
+		for i, l := range lines {
+			l = strings.TrimRight(l, " \t")
+			lines[i] = strings.Join(strings.FieldsFunc(l, isWSP), " ")
+			if len(l) > 0 && isWSP(rune(l[0])) && lines[i] != "" {
+				lines[i] = " " + lines[i]
+			}
+		}
+		body = []byte(strings.Join(lines, "\r\n"))
+	}
+	body = bytes.TrimRight(body, "\r\n")
+	if len(body) == 0 {
+		if c == CanonSimple {
+			return []byte("\r\n")
+		}
+		return nil
+	}
+	return append(body, "\r\n"...)
+}
+
+func isWSP(r rune) bool {
+	return r == ' ' || r == '\t'
+}
+
+// foldBase64 folds a long signature value so header lines stay short.
+func foldBase64(s string) string {
+	const width = 72
+	var b strings.Builder
+	for len(s) > width {
+		b.WriteString(s[:width])
+		b.WriteString("\r\n\t")
+		s = s[width:]
+	}
+	b.WriteString(s)
+	return b.String()
+}
@@ -0,0 +1,160 @@
+package mailer
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// Examples from RFC 6376 section 3.4.5.
+func TestDKIMCanonicalization(t *testing.T) {
+	fields := splitHeaderFields([]byte("A: X\r\nB : Y\t\r\n\tZ  \r\n"))
+	var relaxed, simple string
+	for _, f := range fields {
+		relaxed += canonicalHeader(f, CanonRelaxed)
+		simple += canonicalHeader(f, CanonSimple)
+	}
+	if relaxed != "a:X\r\nb:Y Z\r\n" {
+		t.Errorf("relaxed header = %q", relaxed)
+	}
+	if simple != "A: X\r\nB : Y\t\r\n\tZ  \r\n" {
+		t.Errorf("simple header = %q", simple)
+	}
+
+	body := []byte(" C \r\nD \t E\r\n\r\n\r\n")
+	if got := string(canonicalBody(body, CanonRelaxed)); got != " C\r\nD E\r\n" {
+		t.Errorf("relaxed body = %q", got)
+	}
+	if got := string(canonicalBody(body, CanonSimple)); got != " C \r\nD \t E\r\n" {
+		t.Errorf("simple body = %q", got)
+	}
+	if got := string(canonicalBody(nil, CanonSimple)); got != "\r\n" {
+		t.Errorf("simple empty body = %q", got)
+	}
+	if got := canonicalBody([]byte("\r\n"), CanonRelaxed); len(got) != 0 {
+		t.Errorf("relaxed empty body = %q", got)
+	}
+}
+
+func writeKey(t *testing.T, key any) string {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "dkim.pem")
+	os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600)
+	return path
+}
+
+// verifyDKIM checks the first DKIM-Signature of msg the way a receiver would.
+func verifyDKIM(t *testing.T, msg []byte, pub crypto.PublicKey) map[string]string {
+	t.Helper()
+	head, body, _ := strings.Cut(string(msg), "\r\n\r\n")
+	fields := splitHeaderFields([]byte(head + "\r\n"))
+	sigField := fields[0]
+	if fieldName(sigField) != "DKIM-Signature" {
+		t.Fatalf("first header is %q", sigField)
+	}
+	tags := map[string]string{}
+	_, value, _ := strings.Cut(sigField, ":")
+	for _, tag := range strings.Split(value, ";") {
+		k, v, _ := strings.Cut(tag, "=")
+		tags[strings.TrimSpace(k)] = strings.Join(strings.Fields(v), "")
+	}
+	hc, bc, _ := strings.Cut(tags["c"], "/")
+
+	bh := sha256.Sum256(canonicalBody([]byte(body), bc))
+	if got := base64.StdEncoding.EncodeToString(bh[:]); got != tags["bh"] {
+		t.Fatalf("body hash = %s, header says %s", got, tags["bh"])
+	}
+
+	h := sha256.New()
+	used := map[int]bool{}
+	for _, name := range strings.Split(tags["h"], ":") {
+		for i := len(fields) - 1; i > 0; i-- {
+			if !used[i] && strings.EqualFold(fieldName(fields[i]), name) {
+				used[i] = true
+				h.Write([]byte(canonicalHeader(fields[i], hc)))
+				break
+			}
+		}
+	}
+	unsigned := regexp.MustCompile(`b=[^;]*$`).ReplaceAllString(strings.TrimSuffix(sigField, "\r\n"), "b=")
+	h.Write([]byte(strings.TrimSuffix(canonicalHeader(unsigned+"\r\n", hc), "\r\n")))
+
+	sig, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		t.Fatalf("decode b=: %v", err)
+	}
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		err = rsa.VerifyPKCS1v15(k, crypto.SHA256, h.Sum(nil), sig)
+	case ed25519.PublicKey:
+		if !ed25519.Verify(k, h.Sum(nil), sig) {
+			err = rsa.ErrVerification
+		}
+	}
+	if err != nil {
+		t.Fatalf("signature does not verify: %v", err)
+	}
+	return tags
+}
+
+func TestDKIMSignVerifies(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	edPub, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	msg := "From: Tinyauth <noreply@example.com>\r\nTo: alice@example.com\r\nSubject: Reset  your\r\n password\r\n" +
+		"Date: Mon, 02 Jan 2006 15:04:05 +0000\r\nX-Other: 1\r\n\r\nHello  there \r\n\r\n"
+
+	for _, tc := range []struct {
+		key   any
+		pub   crypto.PublicKey
+		canon string
+		alg   string
+	}{
+		{rsaKey, &rsaKey.PublicKey, "", "rsa-sha256"},
+		{rsaKey, &rsaKey.PublicKey, "simple/simple", "rsa-sha256"},
+		{edKey, edPub, "relaxed/simple", "ed25519-sha256"},
+	} {
+		s, err := NewDKIMSigner(DKIMOptions{Domain: "example.com", Selector: "mail", KeyFile: writeKey(t, tc.key), Canonicalization: tc.canon})
+		if err != nil {
+			t.Fatal(err)
+		}
+		signed, err := s.Sign([]byte(msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasSuffix(string(signed), msg) {
+			t.Fatalf("message was modified")
+		}
+		tags := verifyDKIM(t, signed, tc.pub)
+		if tags["a"] != tc.alg || tags["d"] != "example.com" || tags["s"] != "mail" {
+			t.Errorf("unexpected tags %v", tags)
+		}
+		if tags["h"] != "from:to:subject:date" {
+			t.Errorf("h = %q", tags["h"])
+		}
+	}
+}
+
+func TestDKIMAlwaysSignsFrom(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	s, err := NewDKIMSigner(DKIMOptions{Domain: "example.com", Selector: "mail", KeyFile: writeKey(t, key), Headers: []string{"Subject"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, _ := s.Sign([]byte("From: a@example.com\nSubject: x\n\nbody\n"))
+	if tags := verifyDKIM(t, signed, &key.PublicKey); tags["h"] != "from:subject" {
+		t.Errorf("h = %q", tags["h"])
+	}
+}
@@ -0,0 +1,36 @@
+package mailer
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileTransport writes each message to an .eml file instead of sending it,
+// for development. Files can contain reset links, so they are only readable
+// by the sidecar.
+type FileTransport struct {
+	dir string
+}
+
+// NewFileTransport creates a transport that writes into dir.
+func NewFileTransport(dir string) *FileTransport {
+	return &FileTransport{dir: dir}
+}
+
+// Send writes the message to <dir>/<time>-<random>.eml.
+func (t *FileTransport) Send(m Message) error {
+	if err := os.MkdirAll(t.dir, 0o700); err != nil {
+		return fmt.Errorf("mkdir mail dir: %w", err)
+	}
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	name := time.Now().UTC().Format("20060102T150405.000000000Z") + "-" + hex.EncodeToString(suffix) + ".eml"
+	if err := os.WriteFile(filepath.Join(t.dir, name), m.Raw, 0o600); err != nil {
+		return fmt.Errorf("write %s: %w", name, err)
+	}
+	return nil
+}
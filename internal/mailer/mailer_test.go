@@ -0,0 +1,206 @@
+package mailer
+
+import (
+	"bufio"
+	"encoding/base64"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeSMTP is a minimal SMTP server offering AUTH LOGIN without TLS (allowed on localhost).
+type fakeSMTP struct {
+	ln net.Listener
+
+	mu       sync.Mutex
+	noAuth   bool // leave AUTH out of the EHLO reply
+	conns    int
+	commands []string
+	messages []string
+}
+
+func newFakeSMTP(t *testing.T) *fakeSMTP {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeSMTP{ln: ln}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			f.mu.Lock()
+			f.conns++
+			f.mu.Unlock()
+			go f.serve(c)
+		}
+	}()
+	return f
+}
+
+func (f *fakeSMTP) serve(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	reply := func(s string) { c.Write([]byte(s + "\r\n")) }
+	b64 := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+
+	reply("220 fake ESMTP")
+	var data *strings.Builder
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if data != nil {
+			if line == "." {
+				f.mu.Lock()
+				f.messages = append(f.messages, data.String())
+				f.mu.Unlock()
+				data = nil
+				reply("250 queued")
+			} else {
+				data.WriteString(line + "\n")
+			}
+			continue
+		}
+		f.mu.Lock()
+		f.commands = append(f.commands, line)
+		f.mu.Unlock()
+		switch {
+		case strings.HasPrefix(line, "EHLO"):
+			f.mu.Lock()
+			noAuth := f.noAuth
+			f.mu.Unlock()
+			if noAuth {
+				reply("250 fake")
+			} else {
+				reply("250-fake")
+				reply("250 AUTH LOGIN")
+			}
+		case line == "AUTH LOGIN":
+			reply("334 " + b64("Username:"))
+		case line == b64("user"):
+			reply("334 " + b64("Password:"))
+		case line == b64("secret"):
+			reply("235 authenticated")
+		case line == "DATA":
+			data = &strings.Builder{}
+			reply("354 go ahead")
+		case line == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func TestSMTPSenderLoginAuthAndReuse(t *testing.T) {
+	f := newFakeSMTP(t)
+	s := NewSMTPSender(SMTPOptions{
+		Host:     "127.0.0.1",
+		Port:     f.ln.Addr().(*net.TCPAddr).Port,
+		Username: "user",
+		Password: "secret",
+		Auth:     AuthLogin,
+		HeloName: "auth.example.com",
+	})
+	msg := Message{From: "Tinyauth <noreply@example.com>", To: []string{"alice@example.com"}, Raw: []byte("Subject: hi\r\n\r\nhello\r\n")}
+	for i := 0; i < 3; i++ {
+		if err := s.Send(msg); err != nil {
+			t.Fatalf("send %d: %v", i, err)
+		}
+	}
+	s.Close()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.conns != 1 {
+		t.Errorf("connections = %d, want 1", f.conns)
+	}
+	if len(f.messages) != 3 {
+		t.Errorf("messages = %d, want 3", len(f.messages))
+	}
+	if f.commands[0] != "EHLO auth.example.com" {
+		t.Errorf("first command = %q", f.commands[0])
+	}
+	if !strings.Contains(strings.Join(f.commands, "\n"), "MAIL FROM:<noreply@example.com>") {
+		t.Errorf("envelope sender missing: %v", f.commands)
+	}
+}
+
+func TestSMTPSenderRequiresSTARTTLS(t *testing.T) {
+	f := newFakeSMTP(t)
+	s := NewSMTPSender(SMTPOptions{Host: "127.0.0.1", Port: f.ln.Addr().(*net.TCPAddr).Port, Security: SecuritySTARTTLS})
+	err := s.Send(Message{From: "a@example.com", To: []string{"b@example.com"}, Raw: []byte("\r\n")})
+	if err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Fatalf("expected STARTTLS error, got %v", err)
+	}
+}
+
+func TestSMTPSenderAuthNotOffered(t *testing.T) {
+	f := newFakeSMTP(t)
+	f.mu.Lock()
+	f.noAuth = true
+	f.mu.Unlock()
+	port := f.ln.Addr().(*net.TCPAddr).Port
+	msg := Message{From: "a@example.com", To: []string{"b@example.com"}, Raw: []byte("\r\n")}
+
+	// Credentials without an explicit mechanism must not fall back to unauthenticated mail.
+	s := NewSMTPSender(SMTPOptions{Host: "127.0.0.1", Port: port, Username: "user", Password: "secret"})
+	if err := s.Send(msg); err == nil || !strings.Contains(err.Error(), "does not support AUTH") {
+		t.Fatalf("expected AUTH error, got %v", err)
+	}
+	s.Close()
+
+	// Without a username the relay is used unauthenticated.
+	s = NewSMTPSender(SMTPOptions{Host: "127.0.0.1", Port: port})
+	defer s.Close()
+	if err := s.Send(msg); err != nil {
+		t.Fatalf("unauthenticated send: %v", err)
+	}
+}
+
+func TestSendmailTransport(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "sendmail")
+	out := filepath.Join(dir, "out")
+	os.WriteFile(script, []byte("#!/bin/sh\necho \"$@\" > "+out+".args\ncat > "+out+"\n"), 0o755)
+
+	err := NewSendmailTransport(script).Send(Message{
+		From: "Tinyauth <noreply@example.com>",
+		To:   []string{"alice@example.com"},
+		Raw:  []byte("Subject: hi\r\n\r\nhello\r\n"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	args, _ := os.ReadFile(out + ".args")
+	if got := strings.TrimSpace(string(args)); got != "-i -f noreply@example.com -- alice@example.com" {
+		t.Errorf("args = %q", got)
+	}
+	if body, _ := os.ReadFile(out); !strings.Contains(string(body), "hello") {
+		t.Errorf("stdin = %q", body)
+	}
+}
+
+func TestFileTransport(t *testing.T) {
+	dir := t.TempDir()
+	if err := NewFileTransport(dir).Send(Message{Raw: []byte("Subject: hi\r\n\r\nhello\r\n")}); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("files = %v", files)
+	}
+	if fi, _ := os.Stat(files[0]); fi.Mode().Perm() != 0o600 {
+		t.Errorf("mode = %v", fi.Mode().Perm())
+	}
+}
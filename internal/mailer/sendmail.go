@@ -0,0 +1,45 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const sendmailTimeout = 30 * time.Second
+
+// SendmailTransport pipes messages into a sendmail-compatible binary
+// (sendmail, msmtp, ssmtp, postfix's sendmail, ...).
+type SendmailTransport struct {
+	path string
+}
+
+// NewSendmailTransport creates a transport that runs the binary at path.
+func NewSendmailTransport(path string) *SendmailTransport {
+	return &SendmailTransport{path: path}
+}
+
+// Send runs "<path> -i -f <from> -- <to>..." with the message on stdin.
+func (t *SendmailTransport) Send(m Message) error {
+	from, err := m.EnvelopeFrom()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sendmailTimeout)
+	defer cancel()
+
+	args := append([]string{"-i", "-f", from, "--"}, m.To...)
+	cmd := exec.CommandContext(ctx, t.path, args...)
+	cmd.Stdin = bytes.NewReader(m.Raw)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%s: %w: %s", t.path, err, msg)
+		}
+		return fmt.Errorf("%s: %w", t.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,304 @@
+// Package mailer delivers rendered messages over SMTP, a sendmail binary or to files.
+package mailer
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"tinyauth-sidecar/internal/httpclient"
+)
+
+// SMTP security modes and auth mechanisms.
+const (
+	SecurityNone     = "none"
+	SecuritySTARTTLS = "starttls"
+	SecurityTLS      = "tls"
+
+	AuthPlain   = "plain"
+	AuthLogin   = "login"
+	AuthCRAMMD5 = "crammd5"
+	AuthNone    = "none"
+)
+
+const (
+	defaultSMTPTimeout = 30 * time.Second
+	// idleTimeout closes pooled connections before typical server idle limits.
+	idleTimeout  = 30 * time.Second
+	maxIdleConns = 2
+)
+
+// SMTPOptions configures an SMTP sender.
+type SMTPOptions struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	// Security is "none", "starttls" or "tls". Empty picks by port:
+	// 465 = tls, 587 = starttls, anything else = none.
+	Security string
+	// Auth is "plain", "login", "crammd5" or "none". Empty means plain when a
+	// username is set and none otherwise.
+	Auth string
+	// HeloName is sent in EHLO/HELO; empty uses "localhost".
+	HeloName string
+	TLS      httpclient.TLSOptions
+	Timeout  time.Duration
+}
+
+// ResolvedSecurity returns the security mode, applying the port-based default.
+func (o SMTPOptions) ResolvedSecurity() string {
+	if o.Security != "" {
+		return o.Security
+	}
+	switch o.Port {
+	case 465:
+		return SecurityTLS
+	case 587:
+		return SecuritySTARTTLS
+	default:
+		return SecurityNone
+	}
+}
+
+// ResolvedAuth returns the auth mechanism, applying the username-based default.
+func (o SMTPOptions) ResolvedAuth() string {
+	if o.Auth != "" {
+		return o.Auth
+	}
+	if o.Username == "" {
+		return AuthNone
+	}
+	return AuthPlain
+}
+
+// SMTPSender sends messages over SMTP and keeps a few idle connections open
+// so a batch of queued messages reuses one session.
+type SMTPSender struct {
+	opts SMTPOptions
+
+	mu   sync.Mutex
+	idle []*smtpConn
+}
+
+type smtpConn struct {
+	client   *smtp.Client
+	conn     net.Conn
+	lastUsed time.Time
+}
+
+// NewSMTPSender creates a sender. Connections are opened on first use.
+func NewSMTPSender(opts SMTPOptions) *SMTPSender {
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultSMTPTimeout
+	}
+	return &SMTPSender{opts: opts}
+}
+
+// Send delivers m.Raw to the recipients.
+func (s *SMTPSender) Send(m Message) error {
+	from, err := m.EnvelopeFrom()
+	if err != nil {
+		return err
+	}
+	c, err := s.get()
+	if err != nil {
+		return err
+	}
+	if err := s.send(c, from, m.To, m.Raw); err != nil {
+		// The session is reusable after a rejected message if RSET still works.
+		if c.client.Reset() == nil {
+			s.put(c)
+		} else {
+			c.client.Close()
+		}
+		return err
+	}
+	s.put(c)
+	return nil
+}
+
+func (s *SMTPSender) send(c *smtpConn, from string, to []string, msg []byte) error {
+	c.conn.SetDeadline(time.Now().Add(s.opts.Timeout))
+	if err := c.client.Mail(from); err != nil {
+		return fmt.Errorf("MAIL FROM: %w", err)
+	}
+	for _, rcpt := range to {
+		if err := c.client.Rcpt(rcpt); err != nil {
+			return fmt.Errorf("RCPT TO %s: %w", rcpt, err)
+		}
+	}
+	w, err := c.client.Data()
+	if err != nil {
+		return fmt.Errorf("DATA: %w", err)
+	}
+	if _, err := w.Write(msg); err != nil {
+		return fmt.Errorf("DATA: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("DATA: %w", err)
+	}
+	return nil
+}
+
+// get returns a live idle connection or dials a new one.
+func (s *SMTPSender) get() (*smtpConn, error) {
+	for {
+		s.mu.Lock()
+		if len(s.idle) == 0 {
+			s.mu.Unlock()
+			return s.dial()
+		}
+		c := s.idle[len(s.idle)-1]
+		s.idle = s.idle[:len(s.idle)-1]
+		s.mu.Unlock()
+
+		if time.Since(c.lastUsed) < idleTimeout {
+			c.conn.SetDeadline(time.Now().Add(s.opts.Timeout))
+			if c.client.Noop() == nil {
+				return c, nil
+			}
+		}
+		c.client.Close()
+	}
+}
+
+func (s *SMTPSender) put(c *smtpConn) {
+	c.lastUsed = time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.idle) >= maxIdleConns {
+		go c.client.Quit()
+		return
+	}
+	s.idle = append(s.idle, c)
+}
+
+// Close ends all idle sessions.
+func (s *SMTPSender) Close() {
+	s.mu.Lock()
+	idle := s.idle
+	s.idle = nil
+	s.mu.Unlock()
+	for _, c := range idle {
+		c.client.Quit()
+	}
+}
+
+func (s *SMTPSender) dial() (*smtpConn, error) {
+	o := s.opts
+	addr := net.JoinHostPort(o.Host, strconv.Itoa(o.Port))
+	security := o.ResolvedSecurity()
+
+	var tlsCfg *tls.Config
+	if security != SecurityNone {
+		var err error
+		if tlsCfg, err = o.TLS.Config(); err != nil {
+			return nil, fmt.Errorf("smtp tls: %w", err)
+		}
+		tlsCfg.ServerName = o.Host
+	}
+
+	dialer := &net.Dialer{Timeout: o.Timeout}
+	var conn net.Conn
+	var err error
+	if security == SecurityTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsCfg)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("smtp dial %s: %w", addr, err)
+	}
+	conn.SetDeadline(time.Now().Add(o.Timeout))
+
+	client, err := smtp.NewClient(conn, o.Host)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("smtp greeting: %w", err)
+	}
+	c := &smtpConn{client: client, conn: conn}
+	if err := s.handshake(c, security, tlsCfg); err != nil {
+		client.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (s *SMTPSender) handshake(c *smtpConn, security string, tlsCfg *tls.Config) error {
+	o := s.opts
+	if o.HeloName != "" {
+		if err := c.client.Hello(o.HeloName); err != nil {
+			return fmt.Errorf("smtp EHLO: %w", err)
+		}
+	}
+	if security == SecuritySTARTTLS {
+		if ok, _ := c.client.Extension("STARTTLS"); !ok {
+			return errors.New("smtp server does not support STARTTLS")
+		}
+		if err := c.client.StartTLS(tlsCfg); err != nil {
+			return fmt.Errorf("smtp STARTTLS: %w", err)
+		}
+	}
+
+	var auth smtp.Auth
+	switch o.ResolvedAuth() {
+	case AuthNone:
+		return nil
+	case AuthLogin:
+		auth = &loginAuth{username: o.Username, password: o.Password, host: o.Host}
+	case AuthCRAMMD5:
+		auth = smtp.CRAMMD5Auth(o.Username, o.Password)
+	default:
+		auth = smtp.PlainAuth("", o.Username, o.Password, o.Host)
+	}
+	// Credentials are configured, so a server that doesn't offer AUTH (or a
+	// stripped EHLO reply) must not silently turn into unauthenticated mail.
+	if ok, _ := c.client.Extension("AUTH"); !ok {
+		return errors.New("smtp server does not support AUTH")
+	}
+	if err := c.client.Auth(auth); err != nil {
+		return fmt.Errorf("smtp auth: %w", err)
+	}
+	return nil
+}
+
+// loginAuth implements the LOGIN mechanism. Like smtp.PlainAuth it refuses to
+// send credentials over an unencrypted connection, except to localhost.
+type loginAuth struct {
+	username, password, host string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	prompt := strings.ToLower(strings.TrimSpace(string(fromServer)))
+	switch {
+	case strings.HasPrefix(prompt, "user"):
+		return []byte(a.username), nil
+	case strings.HasPrefix(prompt, "pass"):
+		return []byte(a.password), nil
+	}
+	return nil, fmt.Errorf("unexpected LOGIN challenge %q", fromServer)
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
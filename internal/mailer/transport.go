@@ -0,0 +1,31 @@
+package mailer
+
+import (
+	"fmt"
+	"net/mail"
+)
+
+// Message is a rendered mail. Raw is the complete RFC 5322 message; the other
+// fields are kept for transports that post the parts to an API.
+type Message struct {
+	From    string // header value, e.g. "Tinyauth <noreply@example.com>"
+	To      []string
+	Subject string
+	Text    string
+	HTML    string
+	Raw     []byte
+}
+
+// EnvelopeFrom returns the bare address of m.From for the SMTP envelope.
+func (m Message) EnvelopeFrom() (string, error) {
+	addr, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return "", fmt.Errorf("invalid from address %q: %w", m.From, err)
+	}
+	return addr.Address, nil
+}
+
+// Transport delivers messages.
+type Transport interface {
+	Send(m Message) error
+}
@@ -1,9 +1,11 @@
 package middleware
 
 import (
+	"log"
 	"net/http"
 
 	"tinyauth-sidecar/internal/config"
+	"tinyauth-sidecar/internal/httpclient"
 
 	"github.com/gin-gonic/gin"
 )
@@ -12,6 +14,11 @@ import (
 // forwardauth endpoint on every request. No caching.
 func SessionMiddleware(cfg *config.Config) gin.HandlerFunc {
 	verifyURL := cfg.TinyauthVerifyURL
+	client, err := httpclient.New(0, cfg.TinyauthTLSOptions())
+	if err != nil {
+		log.Printf("[session] tinyauth TLS settings: %v", err)
+		client = http.DefaultClient
+	}
 
 	return func(c *gin.Context) {
 		if verifyURL == "" {
@@ -51,7 +58,7 @@ func SessionMiddleware(cfg *config.Config) gin.HandlerFunc {
 		}
 		req.Header.Set("X-Forwarded-For", forwardedFor)
 
-		resp, err := http.DefaultClient.Do(req)
+		resp, err := client.Do(req)
 		if err != nil {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
 			return
@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestStepUpRequire(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	now := time.Unix(1700000000, 0)
+	s := NewStepUp([]byte("0123456789abcdef0123456789abcdef"), 5*time.Minute)
+	s.now = func() time.Time { return now }
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set("username", c.GetHeader("X-User"))
+		c.Next()
+	})
+	r.POST("/reauth", func(c *gin.Context) {
+		s.Grant(c, c.GetString("username"))
+		c.Status(http.StatusNoContent)
+	})
+	r.POST("/sensitive", s.Require(), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	do := func(path, user, cookie string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.Header.Set("X-User", user)
+		if cookie != "" {
+			req.Header.Set("Cookie", cookie)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do("/sensitive", "frank", ""); w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "reauth_required") {
+		t.Fatalf("without cookie: got %d %s", w.Code, w.Body.String())
+	}
+
+	w := do("/reauth", "frank", "")
+	cookie := w.Result().Cookies()[0]
+	if cookie.Name != stepUpCookieName || !cookie.HttpOnly || cookie.SameSite != http.SameSiteStrictMode || cookie.MaxAge != 300 {
+		t.Fatalf("unexpected cookie %+v", cookie)
+	}
+	header := cookie.Name + "=" + cookie.Value
+
+	if w := do("/sensitive", "frank", header); w.Code != http.StatusNoContent {
+		t.Fatalf("with cookie: got %d", w.Code)
+	}
+	if w := do("/sensitive", "mallory", header); w.Code != http.StatusForbidden {
+		t.Fatalf("cookie for another user: got %d", w.Code)
+	}
+	ts, mac, _ := strings.Cut(cookie.Value, ".")
+	if w := do("/sensitive", "frank", cookie.Name+"=9"+ts+"."+mac); w.Code != http.StatusForbidden {
+		t.Fatalf("extended expiry: got %d", w.Code)
+	}
+
+	now = now.Add(5 * time.Minute)
+	if w := do("/sensitive", "frank", header); w.Code != http.StatusForbidden {
+		t.Fatalf("expired cookie: got %d", w.Code)
+	}
+}
@@ -0,0 +1,62 @@
+// Package phone normalizes phone numbers to E.164 ("+" followed by up to 15 digits).
+package phone
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrInvalid is returned for input that cannot be turned into an E.164 number.
+var ErrInvalid = errors.New("invalid phone number")
+
+// NormalizeE164 converts number to E.164. Spaces, dashes, dots and parentheses
+// are ignored and a "00" prefix is read as "+". The "(0)" trunk prefix written
+// after the country code, as in "+31 (0)6 12345678", is dropped. National
+// numbers (leading "0") need defaultCountry, the calling code without "+"
+// (e.g. "31"); without it they are rejected. Numbers without any prefix are
+// assumed to already start with the country code.
+func NormalizeE164(number, defaultCountry string) (string, error) {
+	s := strings.TrimSpace(number)
+	if s == "" {
+		return "", ErrInvalid
+	}
+
+	international := false
+	switch {
+	case strings.HasPrefix(s, "+"):
+		international = true
+		s = s[1:]
+	case strings.HasPrefix(s, "00"):
+		international = true
+		s = s[2:]
+	}
+	if international {
+		// "(0)" marks the trunk prefix dialled only within the country.
+		s = strings.Replace(s, "(0)", "", 1)
+	}
+
+	var digits strings.Builder
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == ' ' || r == '-' || r == '.' || r == '(' || r == ')' || r == '/':
+		default:
+			return "", ErrInvalid
+		}
+	}
+	d := digits.String()
+
+	if !international && strings.HasPrefix(d, "0") {
+		cc := strings.TrimPrefix(strings.TrimSpace(defaultCountry), "+")
+		if cc == "" {
+			return "", ErrInvalid
+		}
+		d = cc + strings.TrimPrefix(d, "0")
+	}
+
+	if len(d) < 8 || len(d) > 15 || d[0] == '0' {
+		return "", ErrInvalid
+	}
+	return "+" + d, nil
+}
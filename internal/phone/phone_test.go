@@ -0,0 +1,34 @@
+package phone
+
+import "testing"
+
+func TestNormalizeE164(t *testing.T) {
+	tests := []struct {
+		in, country, want string
+	}{
+		{in: "+31 6 12345678", want: "+31612345678"},
+		{in: "0031 6-1234 5678", want: "+31612345678"},
+		{in: "+31 (0)6 12345678", want: "+31612345678"},
+		{in: "0031 (0)6 12345678", want: "+31612345678"},
+		{in: "+44 (0)20 7946 0958", want: "+442079460958"},
+		{in: "06 12345678", country: "31", want: "+31612345678"},
+		{in: "(06) 12345678", country: "+31", want: "+31612345678"},
+		{in: "31612345678", want: "+31612345678"},
+		{in: "06 12345678"},
+		{in: "+31 6 1234 abcd"},
+		{in: "+12345"},
+		{in: ""},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeE164(tt.in, tt.country)
+		if tt.want == "" {
+			if err != ErrInvalid {
+				t.Errorf("NormalizeE164(%q, %q) = %q, %v; want ErrInvalid", tt.in, tt.country, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("NormalizeE164(%q, %q) = %q, %v; want %q", tt.in, tt.country, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package provider
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"tinyauth-sidecar/internal/config"
+)
+
+// Lifecycle event types. Keep in sync with config.EventTypes.
+const (
+	EventPasswordChanged        = "password.changed"
+	EventPasswordResetRequested = "password.reset_requested"
+	EventTOTPEnabled            = "totp.enabled"
+	EventTOTPDisabled           = "totp.disabled"
+	EventEmailChanged           = "email.changed"
+	EventPhoneChanged           = "phone.changed"
+	EventUserCreated            = "user.created"
+	EventUserDeleted            = "user.deleted"
+	EventUserRoleChanged        = "user.role_changed"
+	EventUserLockedOut          = "user.locked_out"
+)
+
+// Event is a user lifecycle event. Data holds event-specific template
+// variables, e.g. OldEmail/NewEmail for email.changed.
+type Event struct {
+	Type     string
+	Username string
+	Role     string
+	IP       string
+	Time     time.Time
+	Data     map[string]string
+}
+
+// EventHook is called asynchronously for subscribed lifecycle events.
+type EventHook interface {
+	Config() config.EventHookConfig
+	Subscribed(eventType string) bool
+	OnEvent(ev Event) error
+}
+
+// WebhookEventHook sends lifecycle events to a configurable webhook.
+type WebhookEventHook struct {
+	cfg    config.EventHookConfig
+	client *http.Client
+}
+
+// NewWebhookEventHook creates a webhook-based event hook from config.
+// Returns nil if not enabled, URL is empty, no events are subscribed or the
+// TLS files can't be loaded.
+func NewWebhookEventHook(cfg config.EventHookConfig) EventHook {
+	if !cfg.Enabled || cfg.URL == "" {
+		return nil
+	}
+	if cfg.Body == "" || len(cfg.Events) == 0 {
+		log.Printf("[event-hook] %s: enabled but body or events is empty", cfg.Name)
+		return nil
+	}
+	client := newWebhookClient("[event-hook]", cfg.WebhookConfig)
+	if client == nil {
+		return nil
+	}
+	log.Printf("[event-hook] %s: webhook configured: %s %s (events: %v)", cfg.Name, cfg.Method, cfg.URL, cfg.Events)
+	return &WebhookEventHook{cfg: cfg, client: client}
+}
+
+// Config returns the hook configuration.
+func (h *WebhookEventHook) Config() config.EventHookConfig {
+	return h.cfg
+}
+
+// Subscribed reports whether the hook wants events of this type.
+func (h *WebhookEventHook) Subscribed(eventType string) bool {
+	return slices.Contains(h.cfg.Events, "*") || slices.Contains(h.cfg.Events, eventType)
+}
+
+// MatchesEventFilters reports whether the hook's domain/role/user filters select this event.
+func MatchesEventFilters(cfg config.EventHookConfig, ev Event) bool {
+	return matchesFilters("[event-hook]", cfg.WebhookConfig, ev.Username, ev.Role)
+}
+
+// eventHookData builds the template data for event hook templates:
+// {{.Event}}, {{.Email}}, {{.Username}}, {{.User}}, {{.Domain}}, {{.Role}},
+// {{.IP}}, {{.Time}} plus the event's Data and the hook's env map.
+func eventHookData(ev Event, env map[string]string) map[string]string {
+	user, domain := splitEmail(ev.Username)
+	vars := map[string]string{
+		"Event":    ev.Type,
+		"Email":    ev.Username,
+		"Username": ev.Username,
+		"User":     user,
+		"Domain":   domain,
+		"Role":     ev.Role,
+		"IP":       ev.IP,
+		"Time":     ev.Time.UTC().Format(time.RFC3339),
+	}
+	for k, v := range ev.Data {
+		if _, ok := vars[k]; !ok {
+			vars[k] = v
+		}
+	}
+	return buildTemplateData(env, vars)
+}
+
+// OnEvent sends the event to the webhook.
+func (h *WebhookEventHook) OnEvent(ev Event) error {
+	status, err := sendWebhook(h.client, h.cfg.WebhookConfig, eventHookData(ev, h.cfg.Env), "")
+	if err != nil {
+		return fmt.Errorf("%s: %w", h.cfg.Name, err)
+	}
+
+	log.Printf("[event-hook] %s: %s for %s delivered (HTTP %d)", h.cfg.Name, ev.Type, ev.Username, status)
+	return nil
+}
@@ -0,0 +1,169 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+
+	"tinyauth-sidecar/internal/config"
+	"tinyauth-sidecar/internal/tmpl"
+)
+
+// maxExecOutput limits how much stderr is kept for logs and errors.
+const maxExecOutput = 2048
+
+// ExecPasswordHook runs a local command for each password change. The password
+// is written to the command's stdin and never appears in its arguments.
+type ExecPasswordHook struct {
+	cfg config.WebhookConfig
+}
+
+// NewExecPasswordHook creates an exec password hook from config.
+// Returns nil if not enabled or the command is empty.
+func NewExecPasswordHook(cfg config.WebhookConfig) PasswordChangeHook {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.Exec.Command == "" {
+		log.Printf("[password-hook] %s: exec hook enabled but command is empty", cfg.Name)
+		return nil
+	}
+	if cfg.Exec.Stdin == "" {
+		cfg.Exec.Stdin = "{{.Password}}\n"
+	}
+	if len(cfg.Exec.SuccessCodes) == 0 {
+		cfg.Exec.SuccessCodes = []int{0}
+	}
+	log.Printf("[password-hook] %s: exec hook configured: %s (filters: domains=%v roles=%v emails=%v)",
+		cfg.Name, cfg.Exec.Command, cfg.FilterDomains, cfg.FilterRoles, cfg.FilterUsers)
+	return &ExecPasswordHook{cfg: cfg}
+}
+
+// Config returns the hook configuration.
+func (h *ExecPasswordHook) Config() config.WebhookConfig {
+	return h.cfg
+}
+
+// OnPasswordChanged runs the command with the new password on stdin.
+func (h *ExecPasswordHook) OnPasswordChanged(ctx PasswordChangeContext) error {
+	if err := h.run(ctx); err != nil {
+		return fmt.Errorf("%s: %w", h.cfg.Name, err)
+	}
+	log.Printf("[password-hook] %s: command succeeded for %s", h.cfg.Name, ctx.Email)
+	return nil
+}
+
+// RollbackPasswordChange runs the command again with the old password, which
+// is only known for a self-service password change.
+func (h *ExecPasswordHook) RollbackPasswordChange(ctx PasswordChangeContext) error {
+	if ctx.OldPassword == "" {
+		return fmt.Errorf("%s: rollback needs the old password", h.cfg.Name)
+	}
+	old := ctx
+	old.Password, old.HashedPassword = ctx.OldPassword, ctx.OldHashedPassword
+	if err := h.run(old); err != nil {
+		return fmt.Errorf("%s: rollback: %w", h.cfg.Name, err)
+	}
+	log.Printf("[password-hook] %s: command rolled back for %s", h.cfg.Name, ctx.Email)
+	return nil
+}
+
+func (h *ExecPasswordHook) run(ctx PasswordChangeContext) error {
+	ec := h.cfg.Exec
+	data := passwordHookData(ctx, h.cfg.Env)
+
+	// Arguments are visible in the process list, so they never get plaintext passwords.
+	argData := make(map[string]string, len(data))
+	for k, v := range data {
+		if k != "Password" && k != "OldPassword" {
+			argData[k] = v
+		}
+	}
+	args := make([]string, 0, len(ec.Args))
+	for i, a := range ec.Args {
+		arg, err := tmpl.Render(fmt.Sprintf("args[%d]", i), a, argData)
+		if err != nil {
+			return fmt.Errorf("template args[%d]: %w", i, err)
+		}
+		args = append(args, arg)
+	}
+	stdin, err := tmpl.Render("stdin", ec.Stdin, data)
+	if err != nil {
+		return fmt.Errorf("template stdin: %w", err)
+	}
+
+	timeout := time.Duration(h.cfg.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	runCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(runCtx, ec.Command, args...)
+	cmd.Dir = ec.Dir
+	cmd.Env = allowedEnv(ec.EnvAllow)
+	cmd.Stdin = strings.NewReader(stdin)
+	var stderr bytes.Buffer
+	cmd.Stderr = &limitedWriter{w: &stderr, n: maxExecOutput}
+	cmd.WaitDelay = time.Second
+
+	err = cmd.Run()
+	errOut := strings.TrimSpace(stderr.String())
+	if errOut != "" {
+		log.Printf("[password-hook] %s: stderr: %s", h.cfg.Name, errOut)
+	}
+
+	if runCtx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("command timed out after %s", timeout)
+	}
+	code := 0
+	if err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return fmt.Errorf("run %s: %w", ec.Command, err)
+		}
+		code = exitErr.ExitCode()
+	}
+	if !slices.Contains(ec.SuccessCodes, code) {
+		if errOut != "" {
+			return fmt.Errorf("exit code %d: %s", code, errOut)
+		}
+		return fmt.Errorf("exit code %d", code)
+	}
+	return nil
+}
+
+// allowedEnv returns the sidecar's environment variables named in allow.
+func allowedEnv(allow []string) []string {
+	env := []string{}
+	for _, name := range allow {
+		if v, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+v)
+		}
+	}
+	return env
+}
+
+// limitedWriter keeps the first n bytes written and discards the rest.
+type limitedWriter struct {
+	w *bytes.Buffer
+	n int
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if room := l.n - l.w.Len(); room > 0 {
+		if len(p) > room {
+			l.w.Write(p[:room])
+		} else {
+			l.w.Write(p)
+		}
+	}
+	return len(p), nil
+}
@@ -0,0 +1,102 @@
+package provider
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"tinyauth-sidecar/internal/config"
+)
+
+// execHookScript writes a shell script into a temp dir and returns its path.
+func execHookScript(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "hook.sh")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func execHookConfig(ec config.ExecHookConfig) config.WebhookConfig {
+	return config.WebhookConfig{Name: "exec-test", Type: config.HookTypeExec, Enabled: true, Timeout: 5, Exec: ec}
+}
+
+func TestExecPasswordHookPasswordOnlyOnStdin(t *testing.T) {
+	out := t.TempDir()
+	script := execHookScript(t, `out="$1"; shift
+cat > "$out/stdin"
+printf '%s\n' "$@" > "$out/args"
+env > "$out/env"
+`)
+	t.Setenv("EXEC_TEST_ALLOWED", "visible")
+	t.Setenv("EXEC_TEST_SECRET", "hidden")
+
+	hook := NewPasswordHook(execHookConfig(config.ExecHookConfig{
+		Command:  script,
+		Args:     []string{out, "{{.Username}}", "{{.Password}}"},
+		EnvAllow: []string{"EXEC_TEST_ALLOWED", "EXEC_TEST_UNSET"},
+	}))
+	if err := hook.OnPasswordChanged(PasswordChangeContext{Email: "alice@example.com", Password: "n3w-Secret"}); err != nil {
+		t.Fatalf("OnPasswordChanged: %v", err)
+	}
+
+	read := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(out, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	if got := read("stdin"); got != "n3w-Secret\n" {
+		t.Errorf("expected the password on stdin, got %q", got)
+	}
+	args := read("args")
+	if !strings.HasPrefix(args, "alice@example.com\n") || strings.Contains(args, "n3w-Secret") {
+		t.Errorf("unexpected args %q", args)
+	}
+	env := read("env")
+	if !strings.Contains(env, "EXEC_TEST_ALLOWED=visible") {
+		t.Errorf("allowlisted variable missing from env:\n%s", env)
+	}
+	for _, hidden := range []string{"EXEC_TEST_SECRET", "EXEC_TEST_UNSET", "n3w-Secret"} {
+		if strings.Contains(env, hidden) {
+			t.Errorf("env contains %q:\n%s", hidden, env)
+		}
+	}
+}
+
+func TestExecPasswordHookExitCode(t *testing.T) {
+	script := execHookScript(t, `cat > /dev/null
+echo "user unknown" >&2
+exit 3
+`)
+	hook := NewPasswordHook(execHookConfig(config.ExecHookConfig{Command: script}))
+	err := hook.OnPasswordChanged(PasswordChangeContext{Email: "bob@example.com", Password: "x"})
+	if err == nil || !strings.Contains(err.Error(), "exit code 3: user unknown") {
+		t.Fatalf("expected exit code error with stderr, got %v", err)
+	}
+
+	hook = NewPasswordHook(execHookConfig(config.ExecHookConfig{Command: script, SuccessCodes: []int{0, 3}}))
+	if err := hook.OnPasswordChanged(PasswordChangeContext{Email: "bob@example.com", Password: "x"}); err != nil {
+		t.Fatalf("expected exit code 3 to count as success, got %v", err)
+	}
+}
+
+func TestExecPasswordHookTimeout(t *testing.T) {
+	script := execHookScript(t, "exec sleep 30\n")
+	cfg := execHookConfig(config.ExecHookConfig{Command: script})
+	cfg.Timeout = 1
+	hook := NewPasswordHook(cfg)
+
+	start := time.Now()
+	err := hook.OnPasswordChanged(PasswordChangeContext{Email: "carol@example.com", Password: "x"})
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("command was not killed on timeout (took %s)", elapsed)
+	}
+}
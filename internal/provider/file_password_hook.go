@@ -0,0 +1,358 @@
+package provider
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"tinyauth-sidecar/internal/config"
+	"tinyauth-sidecar/internal/tmpl"
+)
+
+// fileLocks serializes writes per target path, so several hooks (or a removal
+// running next to a delivery) can share one file.
+var fileLocks sync.Map // path -> *sync.Mutex
+
+func lockFile(path string) func() {
+	v, _ := fileLocks.LoadOrStore(path, &sync.Mutex{})
+	mu := v.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// UserPruner is implemented by hooks that keep their own copy of the user
+// list and drop users that no longer exist in users.txt.
+type UserPruner interface {
+	PruneUsers(existing []string) error
+}
+
+// FilePasswordHook keeps an htpasswd or dovecot passwd-file in sync with
+// users.txt. Every write replaces the file atomically. It records the entries
+// it wrote in a hidden ".<name>.sidecar" file next to the target, so pruning
+// never touches entries managed by someone else.
+type FilePasswordHook struct {
+	cfg config.WebhookConfig
+}
+
+// NewFilePasswordHook creates a file sync password hook from config.
+// Returns nil if not enabled or the path is empty.
+func NewFilePasswordHook(cfg config.WebhookConfig) PasswordChangeHook {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.File.Path == "" {
+		log.Printf("[password-hook] %s: file hook enabled but path is empty", cfg.Name)
+		return nil
+	}
+	if cfg.File.Hash == "" {
+		cfg.File.Hash = "bcrypt"
+	}
+	if cfg.File.Username == "" {
+		cfg.File.Username = "{{.Username}}"
+	}
+	log.Printf("[password-hook] %s: file hook configured: %s (format %s, hash %s, filters: domains=%v roles=%v emails=%v)",
+		cfg.Name, cfg.File.Path, cfg.File.Format, cfg.File.Hash, cfg.FilterDomains, cfg.FilterRoles, cfg.FilterUsers)
+	return &FilePasswordHook{cfg: cfg}
+}
+
+// Config returns the hook configuration.
+func (h *FilePasswordHook) Config() config.WebhookConfig {
+	return h.cfg
+}
+
+// OnPasswordChanged writes the user's new password entry to the file.
+func (h *FilePasswordHook) OnPasswordChanged(ctx PasswordChangeContext) error {
+	if err := h.setPassword(ctx, ctx.Password, ctx.HashedPassword); err != nil {
+		return fmt.Errorf("%s: %w", h.cfg.Name, err)
+	}
+	log.Printf("[password-hook] %s: %s updated for %s", h.cfg.Name, h.cfg.File.Path, ctx.Email)
+	return nil
+}
+
+// RollbackPasswordChange restores the previous entry. With hash = "bcrypt" the
+// old hash from users.txt is enough; other hashes need the old plaintext
+// password (self-service password change).
+func (h *FilePasswordHook) RollbackPasswordChange(ctx PasswordChangeContext) error {
+	bcryptMode := h.cfg.File.Hash == "bcrypt"
+	if ctx.OldPassword == "" && !(bcryptMode && ctx.OldHashedPassword != "") {
+		return fmt.Errorf("%s: rollback needs the old password", h.cfg.Name)
+	}
+	if err := h.setPassword(ctx, ctx.OldPassword, ctx.OldHashedPassword); err != nil {
+		return fmt.Errorf("%s: rollback: %w", h.cfg.Name, err)
+	}
+	log.Printf("[password-hook] %s: %s rolled back for %s", h.cfg.Name, h.cfg.File.Path, ctx.Email)
+	return nil
+}
+
+// PruneUsers removes entries written by this hook whose user is not in
+// existing. Entries the hook didn't write, comments and blank lines are kept
+// as they are.
+func (h *FilePasswordHook) PruneUsers(existing []string) error {
+	keep := make(map[string]bool, len(existing))
+	for _, u := range existing {
+		name, err := h.entryName(PasswordChangeContext{Email: u})
+		if err != nil {
+			return fmt.Errorf("%s: %w", h.cfg.Name, err)
+		}
+		keep[name] = true
+	}
+
+	managed, err := readManaged(h.managedPath())
+	if err != nil {
+		return fmt.Errorf("%s: %w", h.cfg.Name, err)
+	}
+	drop := make(map[string]bool)
+	for name := range managed {
+		if !keep[name] {
+			drop[name] = true
+		}
+	}
+	if len(drop) == 0 {
+		return nil
+	}
+	removed, err := h.removeEntries(drop)
+	if err != nil {
+		return fmt.Errorf("%s: %w", h.cfg.Name, err)
+	}
+	if len(removed) > 0 {
+		log.Printf("[password-hook] %s: pruned %v from %s", h.cfg.Name, removed, h.cfg.File.Path)
+	}
+	return nil
+}
+
+// removeEntries drops the entries named in drop from the file and from the
+// list of managed entries.
+func (h *FilePasswordHook) removeEntries(drop map[string]bool) ([]string, error) {
+	unlock := lockFile(h.cfg.File.Path)
+	defer unlock()
+	lines, err := readLines(h.cfg.File.Path)
+	if err != nil {
+		return nil, err
+	}
+	kept := lines[:0]
+	var removed []string
+	for _, line := range lines {
+		if name, ok := entryKey(line); ok && drop[name] {
+			removed = append(removed, name)
+			continue
+		}
+		kept = append(kept, line)
+	}
+	if len(removed) > 0 {
+		if err := writeLinesAtomic(h.cfg.File.Path, kept); err != nil {
+			return nil, err
+		}
+	}
+	if err := h.updateManaged(func(managed map[string]bool) {
+		for name := range drop {
+			delete(managed, name)
+		}
+	}); err != nil {
+		return removed, err
+	}
+	return removed, nil
+}
+
+// managedPath is the file listing the entries written by this hook.
+func (h *FilePasswordHook) managedPath() string {
+	return filepath.Join(filepath.Dir(h.cfg.File.Path), "."+filepath.Base(h.cfg.File.Path)+".sidecar")
+}
+
+// updateManaged applies fn to the list of managed entries and saves it if it
+// changed. The caller holds the file lock.
+func (h *FilePasswordHook) updateManaged(fn func(map[string]bool)) error {
+	path := h.managedPath()
+	managed, err := readManaged(path)
+	if err != nil {
+		return err
+	}
+	before := len(managed)
+	fn(managed)
+	if len(managed) == before {
+		return nil
+	}
+	names := make([]string, 0, len(managed))
+	for name := range managed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return writeLinesAtomic(path, names)
+}
+
+func readManaged(path string) (map[string]bool, error) {
+	lines, err := readLines(path)
+	if err != nil {
+		return nil, err
+	}
+	managed := make(map[string]bool, len(lines))
+	for _, line := range lines {
+		if name, ok := entryKey(line); ok {
+			managed[name] = true
+		}
+	}
+	return managed, nil
+}
+
+func (h *FilePasswordHook) setPassword(ctx PasswordChangeContext, password, hashedPassword string) error {
+	fc := h.cfg.File
+	name, err := h.entryName(ctx)
+	if err != nil {
+		return err
+	}
+	value, err := h.hashValue(password, hashedPassword)
+	if err != nil {
+		return err
+	}
+
+	unlock := lockFile(fc.Path)
+	defer unlock()
+	lines, err := readLines(fc.Path)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for i, line := range lines {
+		if key, ok := entryKey(line); !ok || key != name {
+			continue
+		}
+		// Keep the dovecot fields after the password (uid, gid, home, extra).
+		rest := ""
+		if fields := strings.SplitN(line, ":", 3); len(fields) == 3 && fc.Format == "dovecot" {
+			rest = ":" + fields[2]
+		}
+		lines[i] = name + ":" + value + rest
+		found = true
+		break
+	}
+	if !found {
+		line := name + ":" + value
+		if fc.Extra != "" {
+			extra, err := tmpl.Render("extra", fc.Extra, passwordHookData(ctx, h.cfg.Env))
+			if err != nil {
+				return fmt.Errorf("template extra: %w", err)
+			}
+			line += ":" + extra
+		}
+		lines = append(lines, line)
+	}
+	if err := writeLinesAtomic(fc.Path, lines); err != nil {
+		return err
+	}
+	return h.updateManaged(func(managed map[string]bool) { managed[name] = true })
+}
+
+// entryName renders the username template for the user in ctx.
+func (h *FilePasswordHook) entryName(ctx PasswordChangeContext) (string, error) {
+	name, err := tmpl.Render("username", h.cfg.File.Username, passwordHookData(ctx, h.cfg.Env))
+	if err != nil {
+		return "", fmt.Errorf("template username: %w", err)
+	}
+	name = strings.TrimSpace(name)
+	if name == "" || strings.ContainsAny(name, ":\n") {
+		return "", fmt.Errorf("invalid entry name %q", name)
+	}
+	return name, nil
+}
+
+// hashValue returns the password field in the file's format.
+func (h *FilePasswordHook) hashValue(password, hashedPassword string) (string, error) {
+	fc := h.cfg.File
+	switch fc.Hash {
+	case "sha":
+		sum := sha1.Sum([]byte(password))
+		return "{SHA}" + base64.StdEncoding.EncodeToString(sum[:]), nil
+	case "ssha512":
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		sum := sha512.Sum512(append([]byte(password), salt...))
+		return "{SSHA512}" + base64.StdEncoding.EncodeToString(append(sum[:], salt...)), nil
+	default:
+		if hashedPassword == "" {
+			return "", fmt.Errorf("no password hash available")
+		}
+		if fc.Format == "dovecot" {
+			return "{BLF-CRYPT}" + hashedPassword, nil
+		}
+		return hashedPassword, nil
+	}
+}
+
+// entryKey returns the user name of a password file line, or false for
+// comments and blank lines.
+func entryKey(line string) (string, bool) {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+		return "", false
+	}
+	name, _, _ := strings.Cut(line, ":")
+	return name, true
+}
+
+func readLines(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var lines []string
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		lines = append(lines, sc.Text())
+	}
+	return lines, sc.Err()
+}
+
+// writeLinesAtomic writes lines to a temp file in the target directory and
+// renames it over path, keeping the mode of an existing file (0640 otherwise).
+func writeLinesAtomic(path string, lines []string) error {
+	mode := os.FileMode(0o640)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+
+	var buf bytes.Buffer
+	for _, line := range lines {
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,116 @@
+package provider
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tinyauth-sidecar/internal/config"
+)
+
+func fileHookConfig(path string, fc config.FileHookConfig) config.WebhookConfig {
+	fc.Path = path
+	return config.WebhookConfig{Name: "file-test", Type: config.HookTypeFile, Enabled: true, File: fc}
+}
+
+func TestFilePasswordHookDovecotPrunesRemovedUsers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "passwd")
+	initial := "# managed by tinyauth-sidecar\n" +
+		"alice@example.com:{BLF-CRYPT}$2a$10$old::::::userdb_quota_rule=*:storage=1G\n" +
+		"bob@example.com:{BLF-CRYPT}$2a$10$bob::::::\n"
+	if err := os.WriteFile(path, []byte(initial), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	hook := NewPasswordHook(fileHookConfig(path, config.FileHookConfig{Format: "dovecot", Extra: ":::::"}))
+
+	if err := hook.OnPasswordChanged(PasswordChangeContext{Email: "alice@example.com", HashedPassword: "$2a$10$new"}); err != nil {
+		t.Fatalf("OnPasswordChanged: %v", err)
+	}
+	if err := hook.OnPasswordChanged(PasswordChangeContext{Email: "carol@example.com", HashedPassword: "$2a$10$carol"}); err != nil {
+		t.Fatalf("OnPasswordChanged: %v", err)
+	}
+	// alice was removed from users.txt; bob's entry wasn't written by the hook.
+	if err := hook.(UserPruner).PruneUsers([]string{"carol@example.com"}); err != nil {
+		t.Fatalf("PruneUsers: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# managed by tinyauth-sidecar\n" +
+		"bob@example.com:{BLF-CRYPT}$2a$10$bob::::::\n" +
+		"carol@example.com:{BLF-CRYPT}$2a$10$carol::::::\n"
+	if string(data) != want {
+		t.Fatalf("unexpected file:\n%s\nwant:\n%s", data, want)
+	}
+	if fi, _ := os.Stat(path); fi.Mode().Perm() != 0o600 {
+		t.Fatalf("expected mode 0600 to be kept, got %v", fi.Mode().Perm())
+	}
+}
+
+func TestFilePasswordHookDovecotKeepsFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "passwd")
+	initial := "alice@example.com:{BLF-CRYPT}$2a$10$old::::::userdb_quota_rule=*:storage=1G\n"
+	if err := os.WriteFile(path, []byte(initial), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	hook := NewPasswordHook(fileHookConfig(path, config.FileHookConfig{Format: "dovecot"}))
+	if err := hook.OnPasswordChanged(PasswordChangeContext{Email: "alice@example.com", HashedPassword: "$2a$10$new"}); err != nil {
+		t.Fatalf("OnPasswordChanged: %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	if want := "alice@example.com:{BLF-CRYPT}$2a$10$new::::::userdb_quota_rule=*:storage=1G\n"; string(data) != want {
+		t.Fatalf("unexpected file:\n%s\nwant:\n%s", data, want)
+	}
+}
+
+func TestFilePasswordHookPruneOnlyManagedEntries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	if err := os.WriteFile(path, []byte("other:$2a$10$other\n"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	hook := NewPasswordHook(fileHookConfig(path, config.FileHookConfig{Format: "htpasswd"}))
+
+	for _, u := range []string{"alice@example.com", "bob@example.com"} {
+		if err := hook.OnPasswordChanged(PasswordChangeContext{Email: u, HashedPassword: "$2a$10$" + u}); err != nil {
+			t.Fatalf("OnPasswordChanged: %v", err)
+		}
+	}
+	if err := hook.(UserPruner).PruneUsers([]string{"alice@example.com"}); err != nil {
+		t.Fatalf("PruneUsers: %v", err)
+	}
+
+	data, _ := os.ReadFile(path)
+	want := "other:$2a$10$other\nalice@example.com:$2a$10$alice@example.com\n"
+	if string(data) != want {
+		t.Fatalf("unexpected file:\n%s\nwant:\n%s", data, want)
+	}
+	managed, _ := os.ReadFile(filepath.Join(filepath.Dir(path), ".htpasswd.sidecar"))
+	if string(managed) != "alice@example.com\n" {
+		t.Fatalf("unexpected managed list %q", managed)
+	}
+}
+
+func TestFilePasswordHookHtpasswdSHARollback(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	hook := NewPasswordHook(fileHookConfig(path, config.FileHookConfig{Format: "htpasswd", Hash: "sha", Username: "{{.User}}"}))
+
+	ctx := PasswordChangeContext{Email: "dave@example.com", Password: "password", OldPassword: "old"}
+	if err := hook.OnPasswordChanged(ctx); err != nil {
+		t.Fatalf("OnPasswordChanged: %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	if got := strings.TrimSpace(string(data)); got != "dave:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=" {
+		t.Fatalf("unexpected entry %q", got)
+	}
+
+	if err := hook.(RollbackHook).RollbackPasswordChange(ctx); err != nil {
+		t.Fatalf("RollbackPasswordChange: %v", err)
+	}
+	data, _ = os.ReadFile(path)
+	if got := strings.TrimSpace(string(data)); got != "dave:{SHA}wA27ydrfvh4jLpOnKd1HUvreCr8=" {
+		t.Fatalf("unexpected rolled back entry %q", got)
+	}
+}
@@ -0,0 +1,185 @@
+package provider
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"time"
+
+	"tinyauth-sidecar/internal/config"
+	"tinyauth-sidecar/internal/tmpl"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// LDAPPasswordHook writes password changes to an LDAP directory.
+type LDAPPasswordHook struct {
+	cfg config.WebhookConfig
+}
+
+// NewLDAPPasswordHook creates an LDAP password hook from config.
+// Returns nil if not enabled or the LDAP URL or user DN is empty.
+func NewLDAPPasswordHook(cfg config.WebhookConfig) PasswordChangeHook {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.LDAP.URL == "" || cfg.LDAP.UserDN == "" {
+		log.Printf("[password-hook] %s: ldap hook enabled but url or user_dn is empty", cfg.Name)
+		return nil
+	}
+	if cfg.LDAP.Mode == "" {
+		cfg.LDAP.Mode = "modify"
+	}
+	if cfg.LDAP.Hash == "" {
+		cfg.LDAP.Hash = "ssha"
+	}
+	if cfg.LDAP.Attribute == "" {
+		cfg.LDAP.Attribute = "userPassword"
+	}
+	log.Printf("[password-hook] %s: ldap hook configured: %s (mode %s, filters: domains=%v roles=%v emails=%v)",
+		cfg.Name, cfg.LDAP.URL, cfg.LDAP.Mode, cfg.FilterDomains, cfg.FilterRoles, cfg.FilterUsers)
+	return &LDAPPasswordHook{cfg: cfg}
+}
+
+// Config returns the hook configuration.
+func (h *LDAPPasswordHook) Config() config.WebhookConfig {
+	return h.cfg
+}
+
+// OnPasswordChanged sets the user's new password in the directory.
+func (h *LDAPPasswordHook) OnPasswordChanged(ctx PasswordChangeContext) error {
+	if err := h.setPassword(ctx, ctx.Password, ctx.HashedPassword); err != nil {
+		return fmt.Errorf("%s: %w", h.cfg.Name, err)
+	}
+	log.Printf("[password-hook] %s: ldap password updated for %s", h.cfg.Name, ctx.Email)
+	return nil
+}
+
+// RollbackPasswordChange restores the previous password. In crypt mode the old
+// bcrypt hash is enough; otherwise the old plaintext password must be known
+// (self-service password change).
+func (h *LDAPPasswordHook) RollbackPasswordChange(ctx PasswordChangeContext) error {
+	cryptMode := h.cfg.LDAP.Mode == "modify" && h.cfg.LDAP.Hash == "crypt"
+	if ctx.OldPassword == "" && !(cryptMode && ctx.OldHashedPassword != "") {
+		return fmt.Errorf("%s: rollback needs the old password", h.cfg.Name)
+	}
+	if err := h.setPassword(ctx, ctx.OldPassword, ctx.OldHashedPassword); err != nil {
+		return fmt.Errorf("%s: rollback: %w", h.cfg.Name, err)
+	}
+	log.Printf("[password-hook] %s: ldap password rolled back for %s", h.cfg.Name, ctx.Email)
+	return nil
+}
+
+func (h *LDAPPasswordHook) setPassword(ctx PasswordChangeContext, password, hashedPassword string) error {
+	lc := h.cfg.LDAP
+	data := dnTemplateData(ctx, h.cfg.Env)
+
+	userDN, err := tmpl.Render("user_dn", lc.UserDN, data)
+	if err != nil {
+		return fmt.Errorf("template user_dn: %w", err)
+	}
+
+	conn, err := h.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if lc.BindDN != "" {
+		bindDN, err := tmpl.Render("bind_dn", lc.BindDN, data)
+		if err != nil {
+			return fmt.Errorf("template bind_dn: %w", err)
+		}
+		if err := conn.Bind(bindDN, lc.BindPassword); err != nil {
+			return fmt.Errorf("bind as %s: %w", bindDN, err)
+		}
+	}
+
+	if lc.Mode == "exop" {
+		if _, err := conn.PasswordModify(ldap.NewPasswordModifyRequest(userDN, "", password)); err != nil {
+			return fmt.Errorf("password modify %s: %w", userDN, err)
+		}
+		return nil
+	}
+
+	var value string
+	switch lc.Hash {
+	case "crypt":
+		value = "{CRYPT}" + hashedPassword
+	default:
+		value, err = sshaHash(password)
+		if err != nil {
+			return err
+		}
+	}
+	req := ldap.NewModifyRequest(userDN, nil)
+	req.Replace(lc.Attribute, []string{value})
+	if err := conn.Modify(req); err != nil {
+		return fmt.Errorf("modify %s: %w", userDN, err)
+	}
+	return nil
+}
+
+// dnTemplateData returns the password hook variables for the DN templates,
+// with the user's values escaped (RFC 4514) so a username containing ",", "+",
+// "=" or "\" can't change which entry is bound or modified. Env values are
+// configured by the admin and may hold DN parts, so they are used as-is.
+func dnTemplateData(ctx PasswordChangeContext, env map[string]string) map[string]string {
+	data := passwordHookData(ctx, env)
+	for _, k := range []string{"Email", "Username", "User", "Domain", "Role"} {
+		data[k] = ldap.EscapeDN(data[k])
+	}
+	return data
+}
+
+// dial connects to the LDAP server, using LDAPS or StartTLS with the hook's
+// CA and client certificate settings.
+func (h *LDAPPasswordHook) dial() (*ldap.Conn, error) {
+	lc := h.cfg.LDAP
+	u, err := url.Parse(lc.URL)
+	if err != nil {
+		return nil, fmt.Errorf("ldap url: %w", err)
+	}
+
+	tlsCfg, err := h.cfg.TLSOptions().Config()
+	if err != nil {
+		return nil, fmt.Errorf("tls: %w", err)
+	}
+	if tlsCfg.ServerName == "" {
+		tlsCfg.ServerName = u.Hostname()
+	}
+
+	timeout := time.Duration(h.cfg.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	conn, err := ldap.DialURL(lc.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: timeout}),
+		ldap.DialWithTLSConfig(tlsCfg))
+	if err != nil {
+		return nil, fmt.Errorf("dial %s: %w", lc.URL, err)
+	}
+	conn.SetTimeout(timeout)
+
+	if lc.StartTLS {
+		if err := conn.StartTLS(tlsCfg); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("starttls: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+// sshaHash returns an RFC 2307 {SSHA} value: base64(SHA1(password + salt) + salt).
+func sshaHash(password string) (string, error) {
+	salt := make([]byte, 8)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	sum := sha1.Sum(append([]byte(password), salt...))
+	return "{SSHA}" + base64.StdEncoding.EncodeToString(append(sum[:], salt...)), nil
+}
@@ -0,0 +1,261 @@
+package provider
+
+import (
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"tinyauth-sidecar/internal/config"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+const (
+	testBindDN       = "cn=admin,dc=example,dc=com"
+	testBindPassword = "admin-secret"
+)
+
+// ldapTestServer is a minimal in-process LDAP server that accepts simple binds,
+// modify, password modify and StartTLS, and records the resulting passwords.
+type ldapTestServer struct {
+	t        *testing.T
+	ln       net.Listener
+	tlsCfg   *tls.Config
+	caFile   string
+	mu       sync.Mutex
+	attrs    map[string]string // dn -> userPassword value (modify)
+	exop     map[string]string // dn -> new password (password modify)
+	startTLS bool
+}
+
+func newLDAPTestServer(t *testing.T, ldaps bool) *ldapTestServer {
+	t.Helper()
+
+	// Borrow a certificate for 127.0.0.1 from httptest.
+	certSrv := httptest.NewTLSServer(http.NotFoundHandler())
+	t.Cleanup(certSrv.Close)
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certSrv.Certificate().Raw})
+	if err := os.WriteFile(caFile, caPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tlsCfg := &tls.Config{Certificates: certSrv.TLS.Certificates}
+
+	var ln net.Listener
+	var err error
+	if ldaps {
+		ln, err = tls.Listen("tcp", "127.0.0.1:0", tlsCfg)
+	} else {
+		ln, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &ldapTestServer{t: t, ln: ln, tlsCfg: tlsCfg, caFile: caFile, attrs: map[string]string{}, exop: map[string]string{}}
+	t.Cleanup(func() { ln.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *ldapTestServer) url(scheme string) string {
+	return scheme + "://" + s.ln.Addr().String()
+}
+
+func (s *ldapTestServer) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *ldapTestServer) handle(conn net.Conn) {
+	defer func() { conn.Close() }()
+	bound := false
+	for {
+		p, err := ber.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		msgID := p.Children[0].Value.(int64)
+		op := p.Children[1]
+
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			dn := op.Children[1].Value.(string)
+			password := op.Children[2].Data.String()
+			bound = dn == testBindDN && password == testBindPassword
+			code := int64(ldap.LDAPResultSuccess)
+			if !bound {
+				code = ldap.LDAPResultInvalidCredentials
+			}
+			s.reply(conn, msgID, ldap.ApplicationBindResponse, code)
+
+		case ldap.ApplicationModifyRequest:
+			if !bound {
+				s.reply(conn, msgID, ldap.ApplicationModifyResponse, ldap.LDAPResultInsufficientAccessRights)
+				continue
+			}
+			dn := op.Children[0].Value.(string)
+			for _, change := range op.Children[1].Children {
+				attr := change.Children[1]
+				if attr.Children[0].Value.(string) == "userPassword" {
+					s.mu.Lock()
+					s.attrs[dn] = attr.Children[1].Children[0].Data.String()
+					s.mu.Unlock()
+				}
+			}
+			s.reply(conn, msgID, ldap.ApplicationModifyResponse, ldap.LDAPResultSuccess)
+
+		case ldap.ApplicationExtendedRequest:
+			oid := op.Children[0].Data.String()
+			switch oid {
+			case "1.3.6.1.4.1.1466.20037": // StartTLS
+				s.reply(conn, msgID, ldap.ApplicationExtendedResponse, ldap.LDAPResultSuccess)
+				tlsConn := tls.Server(conn, s.tlsCfg)
+				if err := tlsConn.Handshake(); err != nil {
+					return
+				}
+				conn = tlsConn
+				s.mu.Lock()
+				s.startTLS = true
+				s.mu.Unlock()
+			case "1.3.6.1.4.1.4203.1.11.1": // password modify
+				if !bound {
+					s.reply(conn, msgID, ldap.ApplicationExtendedResponse, ldap.LDAPResultInsufficientAccessRights)
+					continue
+				}
+				value := ber.DecodePacket(op.Children[1].Data.Bytes())
+				var dn, newPassword string
+				for _, c := range value.Children {
+					switch c.Tag {
+					case 0:
+						dn = c.Data.String()
+					case 2:
+						newPassword = c.Data.String()
+					}
+				}
+				s.mu.Lock()
+				s.exop[dn] = newPassword
+				s.mu.Unlock()
+				s.reply(conn, msgID, ldap.ApplicationExtendedResponse, ldap.LDAPResultSuccess)
+			default:
+				s.reply(conn, msgID, ldap.ApplicationExtendedResponse, ldap.LDAPResultProtocolError)
+			}
+
+		case ldap.ApplicationUnbindRequest:
+			return
+		}
+	}
+}
+
+func (s *ldapTestServer) reply(conn net.Conn, msgID int64, tag ber.Tag, code int64) {
+	env := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	env.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, msgID, "MessageID"))
+	res := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Response")
+	res.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, "resultCode"))
+	res.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matchedDN"))
+	res.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "diagnosticMessage"))
+	env.AppendChild(res)
+	if _, err := conn.Write(env.Bytes()); err != nil {
+		s.t.Logf("ldap test server write: %v", err)
+	}
+}
+
+func ldapHookConfig(s *ldapTestServer, lc config.LDAPHookConfig) config.WebhookConfig {
+	lc.BindDN = testBindDN
+	lc.BindPassword = testBindPassword
+	lc.UserDN = "uid={{.User}},ou={{.Domain}},dc=example,dc=com"
+	return config.WebhookConfig{
+		Name:    "ldap-test",
+		Type:    config.HookTypeLDAP,
+		Enabled: true,
+		Timeout: 5,
+		CAFile:  s.caFile,
+		LDAP:    lc,
+	}
+}
+
+func TestLDAPPasswordHookModifySSHAOverLDAPS(t *testing.T) {
+	s := newLDAPTestServer(t, true)
+	hook := NewPasswordHook(ldapHookConfig(s, config.LDAPHookConfig{URL: s.url("ldaps")}))
+
+	err := hook.OnPasswordChanged(PasswordChangeContext{Email: "alice@mail.example", Password: "n3w-Secret"})
+	if err != nil {
+		t.Fatalf("OnPasswordChanged: %v", err)
+	}
+
+	value := s.attrs["uid=alice,ou=mail.example,dc=example,dc=com"]
+	if !strings.HasPrefix(value, "{SSHA}") {
+		t.Fatalf("expected {SSHA} userPassword, got %q", value)
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, "{SSHA}"))
+	if err != nil || len(raw) <= sha1.Size {
+		t.Fatalf("invalid SSHA value %q: %v", value, err)
+	}
+	sum := sha1.Sum(append([]byte("n3w-Secret"), raw[sha1.Size:]...))
+	if string(sum[:]) != string(raw[:sha1.Size]) {
+		t.Fatalf("SSHA value does not match the new password")
+	}
+}
+
+func TestLDAPPasswordHookExopOverStartTLS(t *testing.T) {
+	s := newLDAPTestServer(t, false)
+	hook := NewPasswordHook(ldapHookConfig(s, config.LDAPHookConfig{URL: s.url("ldap"), StartTLS: true, Mode: "exop"}))
+
+	ctx := PasswordChangeContext{Email: "bob@example.com", Password: "n3w-Secret", OldPassword: "old-Secret"}
+	if err := hook.OnPasswordChanged(ctx); err != nil {
+		t.Fatalf("OnPasswordChanged: %v", err)
+	}
+	if !s.startTLS {
+		t.Fatalf("expected StartTLS before bind")
+	}
+	dn := "uid=bob,ou=example.com,dc=example,dc=com"
+	if got := s.exop[dn]; got != "n3w-Secret" {
+		t.Fatalf("expected password modify to %q, got %q", "n3w-Secret", got)
+	}
+
+	if err := hook.(RollbackHook).RollbackPasswordChange(ctx); err != nil {
+		t.Fatalf("RollbackPasswordChange: %v", err)
+	}
+	if got := s.exop[dn]; got != "old-Secret" {
+		t.Fatalf("expected rollback to %q, got %q", "old-Secret", got)
+	}
+}
+
+func TestLDAPPasswordHookRejectedBind(t *testing.T) {
+	s := newLDAPTestServer(t, false)
+	cfg := ldapHookConfig(s, config.LDAPHookConfig{URL: s.url("ldap"), Hash: "crypt"})
+	cfg.LDAP.BindPassword = "wrong"
+	hook := NewPasswordHook(cfg)
+
+	err := hook.OnPasswordChanged(PasswordChangeContext{Email: "carol@example.com", Password: "x", HashedPassword: "$2a$10$abc"})
+	if err == nil || !strings.Contains(err.Error(), "bind") {
+		t.Fatalf("expected bind error, got %v", err)
+	}
+}
+
+func TestLDAPPasswordHookEscapesDNValues(t *testing.T) {
+	s := newLDAPTestServer(t, false)
+	hook := NewPasswordHook(ldapHookConfig(s, config.LDAPHookConfig{URL: s.url("ldap"), Mode: "exop"}))
+
+	if err := hook.OnPasswordChanged(PasswordChangeContext{Email: "a,ou=admins@example.com", Password: "n3w-Secret"}); err != nil {
+		t.Fatalf("OnPasswordChanged: %v", err)
+	}
+	dn := `uid=a\,ou=admins,ou=example.com,dc=example,dc=com`
+	if got := s.exop[dn]; got != "n3w-Secret" {
+		t.Fatalf("expected password modify of %s, got %v", dn, s.exop)
+	}
+}
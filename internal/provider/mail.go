@@ -0,0 +1,52 @@
+package provider
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"tinyauth-sidecar/internal/config"
+	"tinyauth-sidecar/internal/httpclient"
+	"tinyauth-sidecar/internal/mailer"
+)
+
+// WebhookMailTransport sends mail through an HTTP mail API (Postmark, Mailgun,
+// SendGrid, ...) described by a webhook config, like WebhookSMSProvider.
+type WebhookMailTransport struct {
+	cfg    config.WebhookConfig
+	client *http.Client
+}
+
+// NewWebhookMailTransport creates a mail transport from the [email.http] config.
+func NewWebhookMailTransport(cfg config.WebhookConfig) (*WebhookMailTransport, error) {
+	client, err := httpclient.New(time.Duration(cfg.Timeout)*time.Second, cfg.TLSOptions())
+	if err != nil {
+		return nil, fmt.Errorf("[email.http] tls: %w", err)
+	}
+	log.Printf("[mail] HTTP mail transport configured: %s %s", cfg.Method, cfg.URL)
+	return &WebhookMailTransport{cfg: cfg, client: client}, nil
+}
+
+// Send renders the webhook with the message parts and sends it.
+func (t *WebhookMailTransport) Send(m mailer.Message) error {
+	fromName, fromAddress := "", m.From
+	if addr, err := mail.ParseAddress(m.From); err == nil {
+		fromName, fromAddress = addr.Name, addr.Address
+	}
+	data := buildTemplateData(t.cfg.Env, map[string]string{
+		"From":        m.From,
+		"FromAddress": fromAddress,
+		"FromName":    fromName,
+		"To":          strings.Join(m.To, ","),
+		"Subject":     m.Subject,
+		"Text":        m.Text,
+		"HTML":        m.HTML,
+		"MIME":        string(m.Raw),
+	})
+
+	_, err := sendWebhook(t.client, t.cfg, data, "")
+	return err
+}
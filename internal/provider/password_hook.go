@@ -1,13 +1,46 @@
 package provider
 
+import "tinyauth-sidecar/internal/config"
+
 // PasswordChangeContext contains all info about a password change event.
+// OldPassword is only known when the user changed their own password;
+// OldHashedPassword is the hash that was in users.txt before the change.
 type PasswordChangeContext struct {
-	Email    string
-	Password string
-	Role     string
+	Email             string
+	Password          string
+	HashedPassword    string
+	OldPassword       string
+	OldHashedPassword string
+	Role              string
+	// MessageID is the webhook-id for signed webhook requests. The hook outbox
+	// keeps one per delivery so retries are recognisable; empty = a fresh ID.
+	MessageID string
 }
 
 // PasswordChangeHook is called after a successful local password change.
+// Config returns the [[password_hooks]] entry the hook was built from.
 type PasswordChangeHook interface {
+	Config() config.WebhookConfig
 	OnPasswordChanged(ctx PasswordChangeContext) error
 }
+
+// RollbackHook is implemented by hooks that can undo a password change when a
+// later required hook fails.
+type RollbackHook interface {
+	RollbackPasswordChange(ctx PasswordChangeContext) error
+}
+
+// NewPasswordHook creates a password hook of the configured type.
+// Returns nil if the hook is disabled or incomplete.
+func NewPasswordHook(cfg config.WebhookConfig) PasswordChangeHook {
+	switch cfg.Type {
+	case config.HookTypeLDAP:
+		return NewLDAPPasswordHook(cfg)
+	case config.HookTypeExec:
+		return NewExecPasswordHook(cfg)
+	case config.HookTypeFile:
+		return NewFilePasswordHook(cfg)
+	default:
+		return NewWebhookPasswordHook(cfg)
+	}
+}
@@ -0,0 +1,299 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"unicode/utf16"
+)
+
+// SMPP 3.4 command IDs. Responses have the high bit set.
+const (
+	smppGenericNack     uint32 = 0x80000000
+	smppSubmitSM        uint32 = 0x00000004
+	smppDeliverSM       uint32 = 0x00000005
+	smppUnbind          uint32 = 0x00000006
+	smppBindTransceiver uint32 = 0x00000009
+	smppEnquireLink     uint32 = 0x00000015
+	smppResp            uint32 = 0x80000000
+)
+
+// SMPP optional parameter tags.
+const (
+	smppTagReceiptedMessageID uint16 = 0x001E
+	smppTagMessagePayload     uint16 = 0x0424
+	smppTagMessageState       uint16 = 0x0427
+)
+
+const (
+	smppHeaderLen  = 16
+	smppMaxPDULen  = 64 << 10
+	smppMaxGSMLen  = 160 // septets in one SMS with data_coding 0
+	smppMaxUCS2Len = 140 // bytes in one SMS with data_coding 8
+)
+
+// smppStatusNames names the command_status values SMSCs commonly return.
+var smppStatusNames = map[uint32]string{
+	0x00000001: "ESME_RINVMSGLEN (invalid message length)",
+	0x00000003: "ESME_RINVCMDID (invalid command)",
+	0x00000004: "ESME_RINVBNDSTS (incorrect bind status)",
+	0x00000005: "ESME_RALYBND (already bound)",
+	0x00000008: "ESME_RSYSERR (system error)",
+	0x0000000A: "ESME_RINVSRCADR (invalid source address)",
+	0x0000000B: "ESME_RINVDSTADR (invalid destination address)",
+	0x0000000D: "ESME_RBINDFAIL (bind failed)",
+	0x0000000E: "ESME_RINVPASWD (invalid password)",
+	0x0000000F: "ESME_RINVSYSID (invalid system ID)",
+	0x00000014: "ESME_RMSGQFUL (message queue full)",
+	0x00000045: "ESME_RSUBMITFAIL (submit failed)",
+	0x00000058: "ESME_RTHROTTLED (throttled)",
+	0x00000061: "ESME_RINVSCHED (invalid scheduled delivery time)",
+	0x00000062: "ESME_RINVEXPIRY (invalid validity period)",
+}
+
+func smppStatusError(op string, status uint32) error {
+	if name, ok := smppStatusNames[status]; ok {
+		return fmt.Errorf("smpp %s: %s", op, name)
+	}
+	return fmt.Errorf("smpp %s: command_status 0x%08X", op, status)
+}
+
+type smppPDU struct {
+	command uint32
+	status  uint32
+	seq     uint32
+	body    []byte
+}
+
+func (p smppPDU) marshal() []byte {
+	b := make([]byte, smppHeaderLen, smppHeaderLen+len(p.body))
+	binary.BigEndian.PutUint32(b[0:], uint32(smppHeaderLen+len(p.body)))
+	binary.BigEndian.PutUint32(b[4:], p.command)
+	binary.BigEndian.PutUint32(b[8:], p.status)
+	binary.BigEndian.PutUint32(b[12:], p.seq)
+	return append(b, p.body...)
+}
+
+func readSMPPPDU(r io.Reader) (smppPDU, error) {
+	var hdr [smppHeaderLen]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return smppPDU{}, err
+	}
+	length := binary.BigEndian.Uint32(hdr[0:])
+	if length < smppHeaderLen || length > smppMaxPDULen {
+		return smppPDU{}, fmt.Errorf("smpp: invalid PDU length %d", length)
+	}
+	p := smppPDU{
+		command: binary.BigEndian.Uint32(hdr[4:]),
+		status:  binary.BigEndian.Uint32(hdr[8:]),
+		seq:     binary.BigEndian.Uint32(hdr[12:]),
+		body:    make([]byte, length-smppHeaderLen),
+	}
+	if _, err := io.ReadFull(r, p.body); err != nil {
+		return smppPDU{}, err
+	}
+	return p, nil
+}
+
+// smppWriter builds a PDU body.
+type smppWriter struct {
+	bytes.Buffer
+}
+
+func (w *smppWriter) cstring(s string) {
+	w.WriteString(s)
+	w.WriteByte(0)
+}
+
+func (w *smppWriter) tlv(tag uint16, value []byte) {
+	var hdr [4]byte
+	binary.BigEndian.PutUint16(hdr[0:], tag)
+	binary.BigEndian.PutUint16(hdr[2:], uint16(len(value)))
+	w.Write(hdr[:])
+	w.Write(value)
+}
+
+// smppReader parses a PDU body; the first error sticks.
+type smppReader struct {
+	b   []byte
+	err error
+}
+
+var errSMPPShort = errors.New("smpp: truncated PDU body")
+
+func (r *smppReader) cstring() string {
+	if r.err != nil {
+		return ""
+	}
+	i := bytes.IndexByte(r.b, 0)
+	if i < 0 {
+		r.err = errSMPPShort
+		return ""
+	}
+	s := string(r.b[:i])
+	r.b = r.b[i+1:]
+	return s
+}
+
+func (r *smppReader) byte() byte {
+	if r.err != nil {
+		return 0
+	}
+	if len(r.b) < 1 {
+		r.err = errSMPPShort
+		return 0
+	}
+	v := r.b[0]
+	r.b = r.b[1:]
+	return v
+}
+
+func (r *smppReader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.b) < n {
+		r.err = errSMPPShort
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+// tlvs reads the optional parameters that make up the rest of the body.
+func (r *smppReader) tlvs() map[uint16][]byte {
+	m := make(map[uint16][]byte)
+	for r.err == nil && len(r.b) >= 4 {
+		tag := binary.BigEndian.Uint16(r.b[0:])
+		n := int(binary.BigEndian.Uint16(r.b[2:]))
+		r.b = r.b[4:]
+		m[tag] = r.bytes(n)
+	}
+	return m
+}
+
+// smppText encodes a message as data_coding 0 (SMSC default alphabet) when
+// every character is the same in ASCII and GSM 03.38, and as UCS-2 otherwise.
+func smppText(msg string) (dataCoding byte, text []byte) {
+	gsm := true
+	for _, r := range msg {
+		if !isGSMSafe(r) {
+			gsm = false
+			break
+		}
+	}
+	if gsm {
+		return 0x00, []byte(msg)
+	}
+	units := utf16.Encode([]rune(msg))
+	text = make([]byte, 2*len(units))
+	for i, u := range units {
+		binary.BigEndian.PutUint16(text[2*i:], u)
+	}
+	return 0x08, text
+}
+
+// isGSMSafe reports whether r has the same code in ASCII and the GSM 03.38
+// default alphabet. Characters like @ $ _ { } differ and go out as UCS-2.
+func isGSMSafe(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return true
+	}
+	return strings.ContainsRune(" !\"#%&'()*+,-./:;<=>?\n\r", r)
+}
+
+// smppAddress returns the TON, NPI and address for a sender or E.164 number:
+// international numbers are TON 1/NPI 1, short codes TON 0/NPI 1 and
+// alphanumeric sender IDs TON 5/NPI 0.
+func smppAddress(addr string) (ton, npi byte, out string) {
+	if addr == "" {
+		return 0, 0, ""
+	}
+	digits := strings.TrimPrefix(addr, "+")
+	if strings.Trim(digits, "0123456789") != "" {
+		return 5, 0, addr
+	}
+	if strings.HasPrefix(addr, "+") || len(digits) > 8 {
+		return 1, 1, digits
+	}
+	return 0, 1, digits
+}
+
+var (
+	receiptIDRe   = regexp.MustCompile(`(?i)\bid:(\S+)`)
+	receiptStatRe = regexp.MustCompile(`(?i)\bstat:(\S+)`)
+	receiptErrRe  = regexp.MustCompile(`(?i)\berr:(\S+)`)
+)
+
+// smppMessageStates maps the message_state TLV to receipt stat values.
+var smppMessageStates = map[byte]string{
+	1: "ENROUTE", 2: "DELIVRD", 3: "EXPIRED", 4: "DELETED",
+	5: "UNDELIV", 6: "ACCEPTD", 7: "UNKNOWN", 8: "REJECTD",
+}
+
+// parseSMPPReceipt extracts a delivery report from a deliver_sm body. ok is
+// false for anything that isn't a delivery receipt (e.g. a reply SMS).
+func parseSMPPReceipt(body []byte) (rep DeliveryReport, ok bool, err error) {
+	r := &smppReader{b: body}
+	r.cstring()          // service_type
+	r.bytes(2)           // source_addr_ton, source_addr_npi
+	r.cstring()          // source_addr
+	r.bytes(2)           // dest_addr_ton, dest_addr_npi
+	r.cstring()          // destination_addr
+	esmClass := r.byte() // esm_class
+	r.bytes(2)           // protocol_id, priority_flag
+	r.cstring()          // schedule_delivery_time
+	r.cstring()          // validity_period
+	r.bytes(4)           // registered_delivery .. sm_default_msg_id
+	text := string(r.bytes(int(r.byte())))
+	tlvs := r.tlvs()
+	if r.err != nil {
+		return DeliveryReport{}, false, r.err
+	}
+	if esmClass&0x3C != 0x04 {
+		return DeliveryReport{}, false, nil
+	}
+	if p, ok := tlvs[smppTagMessagePayload]; ok && text == "" {
+		text = string(p)
+	}
+
+	var id, stat, errCode string
+	if m := receiptIDRe.FindStringSubmatch(text); m != nil {
+		id = m[1]
+	}
+	if m := receiptStatRe.FindStringSubmatch(text); m != nil {
+		stat = strings.ToUpper(m[1])
+	}
+	if m := receiptErrRe.FindStringSubmatch(text); m != nil {
+		errCode = m[1]
+	}
+	if v, ok := tlvs[smppTagReceiptedMessageID]; ok && len(v) > 0 {
+		id = string(bytes.TrimRight(v, "\x00"))
+	}
+	if v, ok := tlvs[smppTagMessageState]; ok && len(v) == 1 && stat == "" {
+		stat = smppMessageStates[v[0]]
+	}
+	if id == "" || stat == "" {
+		return DeliveryReport{}, false, fmt.Errorf("smpp: delivery receipt without id or stat: %q", text)
+	}
+
+	rep = DeliveryReport{ID: id, Detail: stat}
+	switch stat {
+	case "DELIVRD":
+		rep.Status = SMSStatusDelivered
+	case "ENROUTE", "ACCEPTD":
+		rep.Status = SMSStatusSent
+	default: // EXPIRED, DELETED, UNDELIV, UNKNOWN, REJECTD
+		rep.Status = SMSStatusFailed
+	}
+	if errCode != "" && strings.Trim(errCode, "0") != "" {
+		rep.Detail += " (error " + errCode + ")"
+	}
+	return rep, true, nil
+}
@@ -1,17 +1,9 @@
 package provider
 
 import (
-	"bytes"
-	"crypto/tls"
 	"encoding/json"
-	"fmt"
-	"io"
 	"log"
 	"net/http"
-	"os"
-	"text/template"
-	"strings"
-	"time"
 
 	"tinyauth-sidecar/internal/config"
 )
@@ -21,83 +13,137 @@ type SMSProvider interface {
 	SendSMS(to, message string) error
 }
 
-// WebhookSMSConfig holds the configuration for webhook-based SMS.
-type WebhookSMSConfig struct {
-	URL           string
-	Method        string
-	ContentType   string
-	Body          string
-	Headers       map[string]string
-	Env           map[string]string
-	SkipTLSVerify bool
+// SMS delivery states, normalized over all providers.
+const (
+	SMSStatusSent      = "sent" // accepted by the provider, no report yet
+	SMSStatusDelivered = "delivered"
+	SMSStatusFailed    = "failed"
+)
+
+// DeliveryReport is a delivery status update for a sent message. Detail holds
+// the provider's own status and error code.
+type DeliveryReport struct {
+	ID     string
+	Status string
+	Detail string
+}
+
+// TrackedSMSProvider is implemented by providers that return a message ID,
+// so later delivery reports can be matched to the message.
+type TrackedSMSProvider interface {
+	SendTrackedSMS(to, message string) (id string, err error)
+}
+
+// DeliveryReportParser is implemented by providers whose delivery reports
+// arrive as HTTP callbacks.
+type DeliveryReportParser interface {
+	ParseDeliveryReports(r *http.Request) ([]DeliveryReport, error)
+}
+
+// DeliveryReportSource is implemented by providers that receive delivery
+// reports on their own connection (SMPP).
+type DeliveryReportSource interface {
+	OnDeliveryReport(fn func(DeliveryReport))
+}
+
+// NewSMSProvider creates an SMS provider of the configured type.
+// Returns nil if the provider is disabled or incomplete.
+func NewSMSProvider(cfg config.SMSProviderConfig) SMSProvider {
+	if !cfg.Enabled {
+		return nil
+	}
+	switch cfg.Type {
+	case config.SMSTypeCM:
+		return NewCMSMSProvider(cfg)
+	case config.SMSTypeMessageBird:
+		return NewMessageBirdSMSProvider(cfg)
+	case config.SMSTypeTwilio:
+		return NewTwilioSMSProvider(cfg)
+	case config.SMSTypeSMPP:
+		return NewSMPPSMSProvider(cfg)
+	default:
+		return NewWebhookSMSProviderFromConfig(cfg.WebhookConfig)
+	}
 }
 
 // WebhookSMSProvider sends SMS via a configurable webhook.
 type WebhookSMSProvider struct {
-	config WebhookSMSConfig
+	cfg    config.WebhookConfig
+	client *http.Client
 }
 
 // NewWebhookSMSProvider creates a WebhookSMSProvider from environment variables.
 // Returns nil if SMS is not configured/enabled.
 func NewWebhookSMSProvider() SMSProvider {
-	enabled := os.Getenv("SMS_ENABLED")
+	enabled := config.Getenv("SMS_ENABLED")
 	if enabled == "" || (enabled != "1" && enabled != "true" && enabled != "yes") {
 		return nil
 	}
 
-	url := os.Getenv("SMS_WEBHOOK_URL")
+	url := config.Getenv("SMS_WEBHOOK_URL")
 	if url == "" {
 		log.Printf("[sms] SMS_ENABLED but SMS_WEBHOOK_URL not set")
 		return nil
 	}
 
-	method := os.Getenv("SMS_WEBHOOK_METHOD")
+	method := config.Getenv("SMS_WEBHOOK_METHOD")
 	if method == "" {
 		method = "POST"
 	}
 
-	contentType := os.Getenv("SMS_WEBHOOK_CONTENT_TYPE")
+	contentType := config.Getenv("SMS_WEBHOOK_CONTENT_TYPE")
 	if contentType == "" {
 		contentType = "application/json"
 	}
 
-	body := os.Getenv("SMS_WEBHOOK_BODY")
+	body := config.Getenv("SMS_WEBHOOK_BODY")
 	if body == "" {
 		log.Printf("[sms] SMS_WEBHOOK_BODY not set")
 		return nil
 	}
 
 	var headers map[string]string
-	if raw := os.Getenv("SMS_WEBHOOK_HEADERS"); raw != "" {
+	if raw := config.Getenv("SMS_WEBHOOK_HEADERS"); raw != "" {
 		if err := json.Unmarshal([]byte(raw), &headers); err != nil {
 			log.Printf("[sms] failed to parse SMS_WEBHOOK_HEADERS: %v", err)
 		}
 	}
 
 	var env map[string]string
-	if raw := os.Getenv("SMS_WEBHOOK_ENV"); raw != "" {
+	if raw := config.Getenv("SMS_WEBHOOK_ENV"); raw != "" {
 		if err := json.Unmarshal([]byte(raw), &env); err != nil {
 			log.Printf("[sms] failed to parse SMS_WEBHOOK_ENV: %v", err)
 		}
 	}
+	resolveEnvMap("[sms]", env)
+	resolveRefMap("[sms] header", headers)
+
+	signingSecret, err := config.ResolveRefs(config.Getenv("SMS_WEBHOOK_SIGNING_SECRET"))
+	if err != nil {
+		log.Printf("[sms] SMS_WEBHOOK_SIGNING_SECRET: %v", err)
+	}
 
 	skipTLS := false
-	if v := os.Getenv("SMS_WEBHOOK_SKIP_TLS_VERIFY"); v == "1" || v == "true" || v == "yes" {
+	if v := config.Getenv("SMS_WEBHOOK_SKIP_TLS_VERIFY"); v == "1" || v == "true" || v == "yes" {
 		skipTLS = true
 	}
 
-	log.Printf("[sms] webhook SMS provider configured: %s %s", method, url)
-	return &WebhookSMSProvider{
-		config: WebhookSMSConfig{
-			URL:           url,
-			Method:        method,
-			ContentType:   contentType,
-			Body:          body,
-			Headers:       headers,
-			Env:           env,
-			SkipTLSVerify: skipTLS,
-		},
-	}
+	return newWebhookSMSProvider(config.WebhookConfig{
+		Name:           "sms",
+		Enabled:        true,
+		URL:            url,
+		Method:         method,
+		ContentType:    contentType,
+		Body:           body,
+		Headers:        config.HeaderEntriesFromMap(headers),
+		Timeout:        15,
+		SkipTLSVerify:  skipTLS,
+		Env:            env,
+		SigningSecret:  signingSecret,
+		CAFile:         config.Getenv("SMS_WEBHOOK_CA_FILE"),
+		ClientCertFile: config.Getenv("SMS_WEBHOOK_CLIENT_CERT_FILE"),
+		ClientKeyFile:  config.Getenv("SMS_WEBHOOK_CLIENT_KEY_FILE"),
+	})
 }
 
 // NewWebhookSMSProviderFromConfig creates a WebhookSMSProvider from a WebhookConfig (TOML).
@@ -110,96 +156,32 @@ func NewWebhookSMSProviderFromConfig(cfg config.WebhookConfig) SMSProvider {
 		log.Printf("[sms] config enabled but body is empty")
 		return nil
 	}
-	log.Printf("[sms] webhook SMS provider configured from config.toml: %s %s", cfg.Method, cfg.URL)
-	return &WebhookSMSProvider{
-		config: WebhookSMSConfig{
-			URL:           cfg.URL,
-			Method:        cfg.Method,
-			ContentType:   cfg.ContentType,
-			Body:          cfg.Body,
-			Headers:       headerEntriesToMap(cfg.Headers),
-			SkipTLSVerify: cfg.SkipTLSVerify,
-		},
+	return newWebhookSMSProvider(cfg)
+}
+
+// newWebhookSMSProvider builds the HTTP client for cfg. Returns nil (logged)
+// on a TLS setup error.
+func newWebhookSMSProvider(cfg config.WebhookConfig) SMSProvider {
+	client := newWebhookClient("[sms]", cfg)
+	if client == nil {
+		return nil
 	}
+	log.Printf("[sms] webhook SMS provider configured: %s %s", cfg.Method, cfg.URL)
+	return &WebhookSMSProvider{cfg: cfg, client: client}
 }
 
 // SendSMS sends an SMS message via the configured webhook.
 func (p *WebhookSMSProvider) SendSMS(to, message string) error {
-	data := buildTemplateData(p.config.Env, map[string]string{
+	data := buildTemplateData(p.cfg.Env, map[string]string{
 		"To":      to,
 		"Message": message,
 	})
 
-	urlStr, err := executeSMSTemplate("url", p.config.URL, data)
-	if err != nil {
-		return fmt.Errorf("template url: %w", err)
-	}
-
-	bodyStr, err := executeSMSTemplate("body", p.config.Body, data)
+	status, err := sendWebhook(p.client, p.cfg, data, "")
 	if err != nil {
-		return fmt.Errorf("template body: %w", err)
+		return err
 	}
 
-	req, err := http.NewRequest(p.config.Method, urlStr, bytes.NewBufferString(bodyStr))
-	if err != nil {
-		return fmt.Errorf("new request: %w", err)
-	}
-
-	req.Header.Set("Content-Type", p.config.ContentType)
-
-	for k, v := range p.config.Headers {
-		headerVal, err := executeSMSTemplate("header-"+k, v, data)
-		if err != nil {
-			return fmt.Errorf("template header %s: %w", k, err)
-		}
-		req.Header.Set(k, headerVal)
-	}
-
-	client := &http.Client{Timeout: 15 * time.Second}
-	if p.config.SkipTLSVerify {
-		client.Transport = &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		}
-	}
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("http request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
-	if resp.StatusCode >= 400 {
-		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(respBody))
-	}
-
-	log.Printf("[sms] sent SMS to %s via webhook (HTTP %d)", to, resp.StatusCode)
+	log.Printf("[sms] sent SMS to %s via webhook (HTTP %d)", to, status)
 	return nil
 }
-
-func executeSMSTemplate(name, tmplStr string, data map[string]string) (string, error) {
-	tmpl, err := template.New(name).Funcs(template.FuncMap{
-		"jsonEscape":          jsonEscape,
-		"digitsOnly":          digitsOnly,
-		"replace": strings.ReplaceAll,
-	}).Parse(tmplStr)
-	if err != nil {
-		return "", err
-	}
-	var buf bytes.Buffer
-	if err := tmpl.Execute(&buf, data); err != nil {
-		return "", err
-	}
-	return buf.String(), nil
-}
-
-func headerEntriesToMap(entries []config.HeaderEntry) map[string]string {
-	if len(entries) == 0 {
-		return nil
-	}
-	m := make(map[string]string, len(entries))
-	for _, e := range entries {
-		m[e.Key] = e.Value
-	}
-	return m
-}
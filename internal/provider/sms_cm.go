@@ -0,0 +1,149 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"tinyauth-sidecar/internal/config"
+)
+
+const cmDefaultURL = "https://gw.cmtelecom.com/v1.0/message"
+
+// CMSMSProvider sends SMS through the CM.com Business Messaging JSON API.
+type CMSMSProvider struct {
+	gw    *smsGateway
+	token string
+}
+
+// NewCMSMSProvider creates a type = "cm" provider. Returns nil if incomplete.
+func NewCMSMSProvider(cfg config.SMSProviderConfig) SMSProvider {
+	if cfg.CM.ProductToken == "" || cfg.Sender == "" {
+		log.Printf("[sms] %s: cm.product_token and sender are required", cfg.Name)
+		return nil
+	}
+	gw := newSMSGateway(cfg, cmDefaultURL)
+	if gw == nil {
+		return nil
+	}
+	log.Printf("[sms] CM.com SMS provider %s configured", cfg.Name)
+	return &CMSMSProvider{gw: gw, token: cfg.CM.ProductToken}
+}
+
+type cmRequest struct {
+	Messages struct {
+		Authentication struct {
+			ProductToken string `json:"producttoken"`
+		} `json:"authentication"`
+		Msg []cmMessage `json:"msg"`
+	} `json:"messages"`
+}
+
+type cmMessage struct {
+	AllowedChannels []string      `json:"allowedChannels"`
+	From            string        `json:"from"`
+	To              []cmRecipient `json:"to"`
+	Body            struct {
+		Type    string `json:"type"`
+		Content string `json:"content"`
+	} `json:"body"`
+	Reference   string `json:"reference"`
+	MinimumPart int    `json:"minimumNumberOfMessageParts"`
+	MaximumPart int    `json:"maximumNumberOfMessageParts"`
+}
+
+type cmRecipient struct {
+	Number string `json:"number"`
+}
+
+type cmResponse struct {
+	Details   string `json:"details"`
+	ErrorCode int    `json:"errorCode"`
+	Messages  []struct {
+		Status           string `json:"status"`
+		Reference        string `json:"reference"`
+		MessageDetails   string `json:"messageDetails"`
+		MessageErrorCode int    `json:"messageErrorCode"`
+	} `json:"messages"`
+}
+
+// SendSMS sends an SMS through CM.com.
+func (p *CMSMSProvider) SendSMS(to, message string) error {
+	return sendTracked(p, to, message)
+}
+
+// SendTrackedSMS sends an SMS and returns the reference used in CM delivery reports.
+func (p *CMSMSProvider) SendTrackedSMS(to, message string) (string, error) {
+	msg := cmMessage{
+		AllowedChannels: []string{"SMS"},
+		From:            p.gw.sender,
+		Reference:       newSMSReference(),
+		MinimumPart:     1,
+		MaximumPart:     8,
+	}
+	// CM expects international numbers with a 00 prefix.
+	msg.To = []cmRecipient{{Number: "00" + msisdn(to)}}
+	// "auto" switches to Unicode only when the text needs it.
+	msg.Body.Type = "auto"
+	msg.Body.Content = message
+
+	var req cmRequest
+	req.Messages.Authentication.ProductToken = p.token
+	req.Messages.Msg = []cmMessage{msg}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+
+	status, respBody, err := p.gw.post(p.gw.url, "application/json", body, nil)
+	if err != nil {
+		return "", err
+	}
+	var resp cmResponse
+	if jerr := json.Unmarshal(respBody, &resp); jerr != nil {
+		if status >= 400 {
+			return "", fmt.Errorf("cm: HTTP %d: %s", status, truncate(respBody))
+		}
+		return "", fmt.Errorf("cm: unexpected response: %s", truncate(respBody))
+	}
+	for _, m := range resp.Messages {
+		if m.MessageErrorCode != 0 || (m.Status != "" && !strings.EqualFold(m.Status, "Accepted")) {
+			return "", fmt.Errorf("cm: message %s: %s (error %d)", strings.ToLower(m.Status), m.MessageDetails, m.MessageErrorCode)
+		}
+	}
+	if status >= 400 || resp.ErrorCode != 0 {
+		return "", fmt.Errorf("cm: HTTP %d: %s (error %d)", status, resp.Details, resp.ErrorCode)
+	}
+
+	log.Printf("[sms] sent SMS to %s via %s (reference %s)", to, p.gw.name, msg.Reference)
+	return msg.Reference, nil
+}
+
+// cmStatuses maps CM delivery report status codes.
+var cmStatuses = map[string]string{
+	"0": SMSStatusSent, // accepted by the operator
+	"1": SMSStatusFailed,
+	"2": SMSStatusDelivered,
+	"3": SMSStatusFailed,
+}
+
+// ParseDeliveryReports reads a CM delivery report sent as GET or form POST
+// (REFERENCE, STATUS, STATUSDESCRIPTION, ERRORCODE).
+func (p *CMSMSProvider) ParseDeliveryReports(r *http.Request) ([]DeliveryReport, error) {
+	v, err := reportValues(r)
+	if err != nil {
+		return nil, err
+	}
+	ref, code := v["reference"], v["status"]
+	status, ok := cmStatuses[code]
+	if ref == "" || !ok {
+		return nil, fmt.Errorf("cm report: missing reference or unknown status %q", code)
+	}
+	detail := v["statusdescription"]
+	if e := v["errorcode"]; e != "" && e != "0" {
+		detail = strings.TrimSpace(fmt.Sprintf("%s (error %s)", detail, e))
+	}
+	return []DeliveryReport{{ID: ref, Status: status, Detail: detail}}, nil
+}
@@ -0,0 +1,106 @@
+package provider
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"tinyauth-sidecar/internal/config"
+	"tinyauth-sidecar/internal/httpclient"
+)
+
+// smsGateway is the HTTP plumbing shared by the built-in SMS gateway clients.
+type smsGateway struct {
+	name   string
+	url    string
+	sender string
+	report string
+	client *http.Client
+}
+
+// newSMSGateway builds the HTTP client for cfg. defaultURL is used when the
+// provider has no url of its own. Returns nil (logged) on a TLS setup error.
+func newSMSGateway(cfg config.SMSProviderConfig, defaultURL string) *smsGateway {
+	client, err := httpclient.New(time.Duration(cfg.Timeout)*time.Second, cfg.TLSOptions())
+	if err != nil {
+		log.Printf("[sms] %s: tls: %v", cfg.Name, err)
+		return nil
+	}
+	url := cfg.URL
+	if url == "" {
+		url = defaultURL
+	}
+	return &smsGateway{name: cfg.Name, url: url, sender: cfg.Sender, report: cfg.ReportURL, client: client}
+}
+
+// post sends body to url and returns the status and up to 64 KiB of the
+// response body. Only transport errors are returned as errors; the caller
+// parses the provider's error format.
+func (g *smsGateway) post(url, contentType string, body []byte, header http.Header) (int, []byte, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, fmt.Errorf("create request: %w", err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("http request: %w", err)
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	return resp.StatusCode, respBody, nil
+}
+
+// sendTracked implements SendSMS for gateways that return a message ID.
+func sendTracked(p TrackedSMSProvider, to, message string) error {
+	_, err := p.SendTrackedSMS(to, message)
+	return err
+}
+
+// newSMSReference returns a random message reference for gateways that let
+// the sender pick the ID used in delivery reports.
+func newSMSReference() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// msisdn strips the + from an E.164 number.
+func msisdn(number string) string {
+	return strings.TrimPrefix(number, "+")
+}
+
+// truncate shortens provider error bodies for log and error messages.
+func truncate(b []byte) string {
+	s := strings.TrimSpace(string(b))
+	if len(s) > 200 {
+		return s[:200] + "..."
+	}
+	return s
+}
+
+// reportValues returns the query and form parameters of a delivery report
+// request with lower-cased names, since gateways differ in their casing.
+func reportValues(r *http.Request) (map[string]string, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, fmt.Errorf("parse report: %w", err)
+	}
+	v := make(map[string]string, len(r.Form))
+	for k, vals := range r.Form {
+		if len(vals) > 0 {
+			v[strings.ToLower(k)] = vals[0]
+		}
+	}
+	return v, nil
+}
@@ -0,0 +1,329 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"tinyauth-sidecar/internal/config"
+)
+
+type memRecorder struct {
+	mu     sync.Mutex
+	status map[string]string
+}
+
+func (m *memRecorder) RecordSMS(provider, id, to, status string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.status[provider+"/"+id] = status
+	return nil
+}
+
+func (m *memRecorder) UpdateSMSStatus(provider, id, status, detail string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.status[provider+"/"+id]; !ok {
+		return false, nil
+	}
+	m.status[provider+"/"+id] = status
+	return true, nil
+}
+
+func (m *memRecorder) get(key string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.status[key]
+}
+
+func gatewayConfig(name, typ, url string) config.SMSProviderConfig {
+	return config.SMSProviderConfig{
+		WebhookConfig: config.WebhookConfig{Name: name, Type: typ, Enabled: true, URL: url, Timeout: 5},
+		Sender:        "TinyAuth",
+		ReportToken:   "secret",
+	}
+}
+
+const trickyMessage = `Code "123" \ é`
+
+func TestCMSMSProvider(t *testing.T) {
+	var got map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		msg := got["messages"].(map[string]any)["msg"].([]any)[0].(map[string]any)
+		if msg["to"].([]any)[0].(map[string]any)["number"] == "0031600000000" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"details":"No valid recipients","errorCode":203,"messages":[{"status":"Rejected","messageDetails":"Gsm '0031600000000' is not a number.","messageErrorCode":305}]}`)
+			return
+		}
+		fmt.Fprintf(w, `{"details":"Created 1 message(s)","errorCode":0,"messages":[{"status":"Accepted","reference":%q,"messageErrorCode":0}]}`, msg["reference"])
+	}))
+	defer srv.Close()
+
+	cfg := gatewayConfig("cm", config.SMSTypeCM, srv.URL)
+	cfg.CM.ProductToken = "tok"
+	rec := &memRecorder{status: map[string]string{}}
+	r := NewSMSRouter([]config.SMSProviderConfig{cfg}, nil, rec)
+
+	p := r.routes[0].provider.(TrackedSMSProvider)
+	id, err := p.SendTrackedSMS("+31612345678", trickyMessage)
+	if err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	msg := got["messages"].(map[string]any)["msg"].([]any)[0].(map[string]any)
+	if msg["body"].(map[string]any)["content"] != trickyMessage || msg["from"] != "TinyAuth" || msg["reference"] != id {
+		t.Fatalf("unexpected CM request: %v", got)
+	}
+	if got["messages"].(map[string]any)["authentication"].(map[string]any)["producttoken"] != "tok" {
+		t.Fatalf("missing product token: %v", got)
+	}
+
+	_, err = p.SendTrackedSMS("+31600000000", "x")
+	if err == nil || !strings.Contains(err.Error(), "is not a number") {
+		t.Fatalf("expected CM message error, got %v", err)
+	}
+
+	// Delivery report as GET.
+	if err := r.SendSMS("+31612345678", "x"); err != nil {
+		t.Fatal(err)
+	}
+	var ref string
+	for k := range rec.status {
+		ref = strings.TrimPrefix(k, "cm/")
+	}
+	req := httptest.NewRequest(http.MethodGet, "/?STATUS=2&REFERENCE="+ref, nil)
+	if err := r.HandleDeliveryReport("cm", "wrong", req); err != ErrReportToken {
+		t.Fatalf("bad token: %v", err)
+	}
+	if err := r.HandleDeliveryReport("cm", "secret", req); err != nil {
+		t.Fatalf("report: %v", err)
+	}
+	if s := rec.get("cm/" + ref); s != SMSStatusDelivered {
+		t.Fatalf("status = %q, want delivered", s)
+	}
+}
+
+func TestMessageBirdSMSProvider(t *testing.T) {
+	var got messageBirdRequest
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&got)
+		if got.Recipients[0] == "31600000000" {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			fmt.Fprint(w, `{"errors":[{"code":9,"description":"no (correct) recipients found","parameter":"recipients"}]}`)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"id":"mb-1","recipients":{"totalCount":1}}`)
+	}))
+	defer srv.Close()
+
+	cfg := gatewayConfig("mb", config.SMSTypeMessageBird, srv.URL)
+	cfg.MessageBird.AccessKey = "key"
+	cfg.ReportURL = "https://auth.example.com/manage/api/sms/reports/mb?token=secret"
+	rec := &memRecorder{status: map[string]string{}}
+	r := NewSMSRouter([]config.SMSProviderConfig{cfg}, nil, rec)
+
+	if err := r.SendSMS("+31612345678", trickyMessage); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if auth != "AccessKey key" || got.Body != trickyMessage || got.Recipients[0] != "31612345678" || got.ReportURL != cfg.ReportURL {
+		t.Fatalf("unexpected MessageBird request: %s %+v", auth, got)
+	}
+	if rec.get("mb/mb-1") != SMSStatusSent {
+		t.Fatalf("message not recorded: %v", rec.status)
+	}
+
+	err := r.SendSMSVia("mb", "+31600000000", "x")
+	if err == nil || !strings.Contains(err.Error(), "recipients: no (correct) recipients found (code 9)") {
+		t.Fatalf("expected MessageBird error, got %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/?id=mb-1&status=delivery_failed&statusErrorCode=5", nil)
+	if err := r.HandleDeliveryReport("mb", "secret", req); err != nil {
+		t.Fatalf("report: %v", err)
+	}
+	if s := rec.get("mb/mb-1"); s != SMSStatusFailed {
+		t.Fatalf("status = %q, want failed", s)
+	}
+}
+
+func TestTwilioSMSProvider(t *testing.T) {
+	var form url.Values
+	var path, user, pass string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		user, pass, _ = r.BasicAuth()
+		body, _ := io.ReadAll(r.Body)
+		form, _ = url.ParseQuery(string(body))
+		if form.Get("To") == "+31600000000" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"code":21211,"message":"The 'To' number +31600000000 is not a valid phone number.","status":400}`)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"sid":"SM123","status":"queued","error_code":null,"error_message":null}`)
+	}))
+	defer srv.Close()
+
+	cfg := gatewayConfig("twilio", config.SMSTypeTwilio, srv.URL)
+	cfg.Sender = "+15005550006"
+	cfg.Twilio = config.SMSTwilioConfig{AccountSID: "AC1", AuthToken: "tok"}
+	cfg.ReportURL = "https://auth.example.com/manage/api/sms/reports/twilio?token=secret"
+	rec := &memRecorder{status: map[string]string{}}
+	r := NewSMSRouter([]config.SMSProviderConfig{cfg}, nil, rec)
+
+	if err := r.SendSMS("+31612345678", trickyMessage); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if path != "/2010-04-01/Accounts/AC1/Messages.json" || user != "AC1" || pass != "tok" {
+		t.Fatalf("unexpected request %s as %s:%s", path, user, pass)
+	}
+	if form.Get("Body") != trickyMessage || form.Get("From") != "+15005550006" || form.Get("StatusCallback") != cfg.ReportURL {
+		t.Fatalf("unexpected form: %v", form)
+	}
+
+	err := r.SendSMSVia("twilio", "+31600000000", "x")
+	if err == nil || !strings.Contains(err.Error(), "not a valid phone number. (code 21211)") {
+		t.Fatalf("expected Twilio error, got %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("MessageSid=SM123&MessageStatus=delivered"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if err := r.HandleDeliveryReport("twilio", "secret", req); err != nil {
+		t.Fatalf("report: %v", err)
+	}
+	if s := rec.get("twilio/SM123"); s != SMSStatusDelivered {
+		t.Fatalf("status = %q, want delivered", s)
+	}
+}
+
+// stubSMSC is a minimal SMPP server: it accepts one bind, answers submit_sm
+// with a message ID and then sends a delivery receipt for it.
+func stubSMSC(t *testing.T, password string) (addr string, submitted chan []byte) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	submitted = make(chan []byte, 4)
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		n := 0
+		for {
+			pdu, err := readSMPPPDU(conn)
+			if err != nil {
+				return
+			}
+			switch pdu.command {
+			case smppBindTransceiver:
+				r := &smppReader{b: pdu.body}
+				r.cstring()
+				status := uint32(0)
+				if r.cstring() != password {
+					status = 0x0E
+				}
+				conn.Write(smppPDU{command: smppBindTransceiver | smppResp, status: status, seq: pdu.seq, body: []byte("smsc\x00")}.marshal())
+			case smppSubmitSM:
+				n++
+				id := fmt.Sprintf("msg%d", n)
+				submitted <- pdu.body
+				conn.Write(smppPDU{command: smppSubmitSM | smppResp, seq: pdu.seq, body: []byte(id + "\x00")}.marshal())
+
+				var w smppWriter
+				w.cstring("")
+				w.Write([]byte{1, 1})
+				w.cstring("31612345678")
+				w.Write([]byte{5, 0})
+				w.cstring("TinyAuth")
+				w.WriteByte(0x04) // esm_class: delivery receipt
+				w.Write([]byte{0, 0})
+				w.cstring("")
+				w.cstring("")
+				w.Write([]byte{0, 0, 0, 0})
+				text := "id:" + id + " sub:001 dlvrd:001 submit date:2601011200 done date:2601011201 stat:DELIVRD err:000 text:x"
+				w.WriteByte(byte(len(text)))
+				w.WriteString(text)
+				conn.Write(smppPDU{command: smppDeliverSM, seq: 1000 + uint32(n), body: w.Bytes()}.marshal())
+			case smppDeliverSM | smppResp, smppEnquireLink | smppResp:
+			case smppEnquireLink:
+				conn.Write(smppPDU{command: smppEnquireLink | smppResp, seq: pdu.seq}.marshal())
+			case smppUnbind:
+				conn.Write(smppPDU{command: smppUnbind | smppResp, seq: pdu.seq}.marshal())
+				return
+			}
+		}
+	}()
+	return ln.Addr().String(), submitted
+}
+
+func TestSMPPSMSProvider(t *testing.T) {
+	addr, submitted := stubSMSC(t, "pw")
+	cfg := gatewayConfig("smpp", config.SMSTypeSMPP, "")
+	cfg.SMPP = config.SMSSMPPConfig{Address: addr, SystemID: "tinyauth", Password: "pw"}
+	rec := &memRecorder{status: map[string]string{}}
+	r := NewSMSRouter([]config.SMSProviderConfig{cfg}, nil, rec)
+	defer r.routes[0].provider.(*SMPPSMSProvider).Close()
+
+	if err := r.SendSMS("+31612345678", "Code: 123456"); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	body := <-submitted
+	rd := &smppReader{b: body}
+	rd.cstring()
+	srcTON, _ := rd.byte(), rd.byte()
+	src := rd.cstring()
+	rd.bytes(2)
+	dst := rd.cstring()
+	rd.bytes(5) // esm_class .. validity_period
+	rd.bytes(2) // registered_delivery, replace_if_present_flag
+	dc := rd.byte()
+	rd.byte()
+	text := string(rd.bytes(int(rd.byte())))
+	if srcTON != 5 || src != "TinyAuth" || dst != "31612345678" || dc != 0 || text != "Code: 123456" {
+		t.Fatalf("submit_sm: ton=%d src=%q dst=%q dc=%d text=%q", srcTON, src, dst, dc, text)
+	}
+
+	// The receipt may arrive before or after the send is recorded.
+	deadline := time.Now().Add(2 * time.Second)
+	for rec.get("smpp/msg1") != SMSStatusDelivered {
+		if time.Now().After(deadline) {
+			t.Fatalf("status = %q, want delivered", rec.get("smpp/msg1"))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Non-GSM text goes out as UCS-2.
+	if err := r.SendSMS("+31612345678", "Code €5"); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if dc, _ := smppText("Code €5"); dc != 0x08 {
+		t.Fatalf("data_coding = %d, want 8", dc)
+	}
+	<-submitted
+}
+
+func TestSMPPBindFailure(t *testing.T) {
+	addr, _ := stubSMSC(t, "pw")
+	cfg := gatewayConfig("smpp", config.SMSTypeSMPP, "")
+	cfg.SMPP = config.SMSSMPPConfig{Address: addr, SystemID: "tinyauth", Password: "wrong"}
+	p := NewSMSProvider(cfg)
+	err := p.SendSMS("+31612345678", "x")
+	if err == nil || !strings.Contains(err.Error(), "ESME_RINVPASWD") {
+		t.Fatalf("expected bind error, got %v", err)
+	}
+}
@@ -0,0 +1,123 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"tinyauth-sidecar/internal/config"
+)
+
+const messageBirdDefaultURL = "https://rest.messagebird.com/messages"
+
+// MessageBirdSMSProvider sends SMS through the MessageBird (Bird) REST API.
+type MessageBirdSMSProvider struct {
+	gw        *smsGateway
+	accessKey string
+}
+
+// NewMessageBirdSMSProvider creates a type = "messagebird" provider. Returns nil if incomplete.
+func NewMessageBirdSMSProvider(cfg config.SMSProviderConfig) SMSProvider {
+	if cfg.MessageBird.AccessKey == "" || cfg.Sender == "" {
+		log.Printf("[sms] %s: messagebird.access_key and sender are required", cfg.Name)
+		return nil
+	}
+	gw := newSMSGateway(cfg, messageBirdDefaultURL)
+	if gw == nil {
+		return nil
+	}
+	log.Printf("[sms] MessageBird SMS provider %s configured", cfg.Name)
+	return &MessageBirdSMSProvider{gw: gw, accessKey: cfg.MessageBird.AccessKey}
+}
+
+type messageBirdRequest struct {
+	Originator string   `json:"originator"`
+	Recipients []string `json:"recipients"`
+	Body       string   `json:"body"`
+	Datacoding string   `json:"datacoding"`
+	ReportURL  string   `json:"reportUrl,omitempty"`
+}
+
+type messageBirdResponse struct {
+	ID     string `json:"id"`
+	Errors []struct {
+		Code        int    `json:"code"`
+		Description string `json:"description"`
+		Parameter   string `json:"parameter"`
+	} `json:"errors"`
+}
+
+// SendSMS sends an SMS through MessageBird.
+func (p *MessageBirdSMSProvider) SendSMS(to, message string) error {
+	return sendTracked(p, to, message)
+}
+
+// SendTrackedSMS sends an SMS and returns the MessageBird message ID.
+func (p *MessageBirdSMSProvider) SendTrackedSMS(to, message string) (string, error) {
+	body, err := json.Marshal(messageBirdRequest{
+		Originator: p.gw.sender,
+		Recipients: []string{msisdn(to)},
+		Body:       message,
+		Datacoding: "auto",
+		ReportURL:  p.gw.report,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	header := http.Header{"Authorization": {"AccessKey " + p.accessKey}}
+	status, respBody, err := p.gw.post(p.gw.url, "application/json", body, header)
+	if err != nil {
+		return "", err
+	}
+	var resp messageBirdResponse
+	if jerr := json.Unmarshal(respBody, &resp); jerr != nil {
+		return "", fmt.Errorf("messagebird: HTTP %d: %s", status, truncate(respBody))
+	}
+	if len(resp.Errors) > 0 {
+		var msgs []string
+		for _, e := range resp.Errors {
+			m := fmt.Sprintf("%s (code %d)", e.Description, e.Code)
+			if e.Parameter != "" {
+				m = e.Parameter + ": " + m
+			}
+			msgs = append(msgs, m)
+		}
+		return "", fmt.Errorf("messagebird: HTTP %d: %s", status, strings.Join(msgs, "; "))
+	}
+	if status >= 400 || resp.ID == "" {
+		return "", fmt.Errorf("messagebird: HTTP %d: %s", status, truncate(respBody))
+	}
+
+	log.Printf("[sms] sent SMS to %s via %s (id %s)", to, p.gw.name, resp.ID)
+	return resp.ID, nil
+}
+
+// ParseDeliveryReports reads a MessageBird status report (id, status,
+// statusErrorCode), sent as GET to the reportUrl.
+func (p *MessageBirdSMSProvider) ParseDeliveryReports(r *http.Request) ([]DeliveryReport, error) {
+	v, err := reportValues(r)
+	if err != nil {
+		return nil, err
+	}
+	id, raw := v["id"], v["status"]
+	if id == "" || raw == "" {
+		return nil, fmt.Errorf("messagebird report: missing id or status")
+	}
+	var status string
+	switch raw {
+	case "delivered":
+		status = SMSStatusDelivered
+	case "delivery_failed", "expired":
+		status = SMSStatusFailed
+	default: // scheduled, sent, buffered
+		status = SMSStatusSent
+	}
+	detail := raw
+	if e := v["statuserrorcode"]; e != "" && e != "0" {
+		detail += " (error " + e + ")"
+	}
+	return []DeliveryReport{{ID: id, Status: status, Detail: detail}}, nil
+}
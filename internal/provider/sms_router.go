@@ -0,0 +1,349 @@
+package provider
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"tinyauth-sidecar/internal/config"
+)
+
+const (
+	defaultBreakerThreshold = 3
+	defaultBreakerCooldown  = 60 * time.Second
+)
+
+// Circuit breaker states reported by SMSRouter.Status.
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+// Errors returned by SendSMSVia and HandleDeliveryReport.
+var (
+	ErrUnknownSMSProvider = errors.New("unknown SMS provider")
+	ErrReportsDisabled    = errors.New("delivery reports are not enabled for this provider")
+	ErrReportToken        = errors.New("invalid delivery report token")
+)
+
+// maxEarlyReports bounds the reports kept for messages not yet recorded.
+const maxEarlyReports = 100
+
+// SMSRecorder stores sent messages and their delivery status (store.SMSLog).
+type SMSRecorder interface {
+	RecordSMS(provider, id, to, status string) error
+	UpdateSMSStatus(provider, id, status, detail string) (bool, error)
+}
+
+// SMSRouter sends through several SMS providers. It picks the providers for
+// the destination country, tries them by priority and fails over to the next
+// one on error. A provider that keeps failing is skipped for a cooldown
+// period (circuit breaker) so every message doesn't wait for its timeout.
+type SMSRouter struct {
+	routes   []*smsRoute
+	recorder SMSRecorder
+
+	// early holds delivery reports that arrived before the send was
+	// recorded (fast SMPP receipts), keyed by provider + "/" + id.
+	earlyMu sync.Mutex
+	early   map[string]DeliveryReport
+}
+
+type smsRoute struct {
+	name        string
+	priority    int
+	countries   []string
+	provider    SMSProvider
+	threshold   int
+	cooldown    time.Duration
+	reportToken string
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	lastError string
+}
+
+// SMSProviderStatus describes one provider for the admin status endpoint.
+type SMSProviderStatus struct {
+	Name      string   `json:"name"`
+	Priority  int      `json:"priority"`
+	Countries []string `json:"countries,omitempty"`
+	State     string   `json:"state"`
+	Failures  int      `json:"failures"`
+	LastError string   `json:"lastError,omitempty"`
+}
+
+// NewSMSRouter builds a router over the enabled [[sms_providers]]. fallback,
+// the provider from [sms] or the SMS_WEBHOOK_* env vars, is added last under
+// the name "sms". Sent messages and delivery reports go to recorder, if set.
+// Returns nil if no provider is configured.
+func NewSMSRouter(cfgs []config.SMSProviderConfig, fallback SMSProvider, recorder SMSRecorder) *SMSRouter {
+	r := &SMSRouter{recorder: recorder, early: make(map[string]DeliveryReport)}
+	for _, cfg := range cfgs {
+		p := NewSMSProvider(cfg)
+		if p == nil {
+			continue
+		}
+		r.add(cfg.Name, cfg.Priority, cfg.Countries, p, cfg.BreakerThreshold, time.Duration(cfg.BreakerCooldown)*time.Second)
+		rt := r.routes[len(r.routes)-1]
+		rt.reportToken = cfg.ReportToken
+		if src, ok := p.(DeliveryReportSource); ok {
+			src.OnDeliveryReport(func(rep DeliveryReport) { r.applyReport(rt.name, rep) })
+		}
+	}
+	if fallback != nil {
+		r.add("sms", 0, nil, fallback, 0, 0)
+	}
+	if len(r.routes) == 0 {
+		return nil
+	}
+	// Stable, so providers with equal priority keep their config order.
+	sort.SliceStable(r.routes, func(i, j int) bool { return r.routes[i].priority < r.routes[j].priority })
+	return r
+}
+
+func (r *SMSRouter) add(name string, priority int, countries []string, p SMSProvider, threshold int, cooldown time.Duration) {
+	if threshold <= 0 {
+		threshold = defaultBreakerThreshold
+	}
+	if cooldown <= 0 {
+		cooldown = defaultBreakerCooldown
+	}
+	cc := make([]string, len(countries))
+	for i, c := range countries {
+		cc[i] = strings.TrimPrefix(c, "+")
+	}
+	r.routes = append(r.routes, &smsRoute{
+		name:      name,
+		priority:  priority,
+		countries: cc,
+		provider:  p,
+		threshold: threshold,
+		cooldown:  cooldown,
+	})
+}
+
+// Status returns the circuit breaker state of every provider.
+func (r *SMSRouter) Status() []SMSProviderStatus {
+	now := time.Now()
+	out := make([]SMSProviderStatus, len(r.routes))
+	for i, rt := range r.routes {
+		rt.mu.Lock()
+		out[i] = SMSProviderStatus{
+			Name:      rt.name,
+			Priority:  rt.priority,
+			Countries: rt.countries,
+			State:     rt.stateLocked(now),
+			Failures:  rt.failures,
+			LastError: rt.lastError,
+		}
+		rt.mu.Unlock()
+	}
+	return out
+}
+
+// SendSMS sends through the first provider that accepts the message.
+// Providers with an open circuit are only tried when all others failed.
+func (r *SMSRouter) SendSMS(to, message string) error {
+	candidates := r.candidates(to)
+	if len(candidates) == 0 {
+		return fmt.Errorf("no SMS provider handles %s", to)
+	}
+
+	now := time.Now()
+	var ready, open []*smsRoute
+	for _, rt := range candidates {
+		if rt.state(now) == BreakerOpen {
+			open = append(open, rt)
+		} else {
+			ready = append(ready, rt)
+		}
+	}
+
+	var errs []error
+	for _, rt := range append(ready, open...) {
+		err := r.send(rt, to, message)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", rt.name, err))
+	}
+	return errors.Join(errs...)
+}
+
+// SendSMSVia sends through the named provider only, bypassing routing and the
+// circuit breaker. The result still counts towards the provider's breaker.
+func (r *SMSRouter) SendSMSVia(name, to, message string) error {
+	for _, rt := range r.routes {
+		if rt.name == name {
+			return r.send(rt, to, message)
+		}
+	}
+	return fmt.Errorf("%w %q", ErrUnknownSMSProvider, name)
+}
+
+func (r *SMSRouter) send(rt *smsRoute, to, message string) error {
+	var id string
+	var err error
+	if tp, ok := rt.provider.(TrackedSMSProvider); ok {
+		id, err = tp.SendTrackedSMS(to, message)
+	} else {
+		err = rt.provider.SendSMS(to, message)
+	}
+	if err != nil {
+		log.Printf("[sms] provider %s failed for %s: %v", rt.name, to, err)
+	}
+	rt.record(err)
+	if err == nil {
+		r.recordSent(rt.name, id, to)
+	}
+	return err
+}
+
+// recordSent logs a sent message. Messages from providers without IDs get a
+// local one, so admins still see them.
+func (r *SMSRouter) recordSent(name, id, to string) {
+	if r.recorder == nil {
+		return
+	}
+	if id == "" {
+		id = "local-" + newSMSReference()
+	}
+	if err := r.recorder.RecordSMS(name, id, to, SMSStatusSent); err != nil {
+		log.Printf("[sms] failed to log SMS to %s: %v", to, err)
+		return
+	}
+
+	key := name + "/" + id
+	r.earlyMu.Lock()
+	rep, ok := r.early[key]
+	delete(r.early, key)
+	r.earlyMu.Unlock()
+	if ok {
+		r.applyReport(name, rep)
+	}
+}
+
+// HandleDeliveryReport checks the report token and applies the delivery
+// reports in an HTTP callback for the named provider.
+func (r *SMSRouter) HandleDeliveryReport(name, token string, req *http.Request) error {
+	var rt *smsRoute
+	for _, candidate := range r.routes {
+		if candidate.name == name {
+			rt = candidate
+		}
+	}
+	if rt == nil {
+		return fmt.Errorf("%w %q", ErrUnknownSMSProvider, name)
+	}
+	parser, ok := rt.provider.(DeliveryReportParser)
+	if !ok || rt.reportToken == "" {
+		return ErrReportsDisabled
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(rt.reportToken)) != 1 {
+		return ErrReportToken
+	}
+
+	reports, err := parser.ParseDeliveryReports(req)
+	if err != nil {
+		return err
+	}
+	for _, rep := range reports {
+		r.applyReport(name, rep)
+	}
+	return nil
+}
+
+// applyReport updates the logged status of a message. Reports for messages
+// not recorded yet are kept until the send is recorded.
+func (r *SMSRouter) applyReport(name string, rep DeliveryReport) {
+	if r.recorder == nil {
+		return
+	}
+	found, err := r.recorder.UpdateSMSStatus(name, rep.ID, rep.Status, rep.Detail)
+	if err != nil {
+		log.Printf("[sms] failed to store delivery report for %s/%s: %v", name, rep.ID, err)
+		return
+	}
+	if found {
+		log.Printf("[sms] delivery report via %s for %s: %s (%s)", name, rep.ID, rep.Status, rep.Detail)
+		return
+	}
+
+	r.earlyMu.Lock()
+	defer r.earlyMu.Unlock()
+	if len(r.early) >= maxEarlyReports {
+		for k := range r.early {
+			delete(r.early, k)
+			break
+		}
+	}
+	r.early[name+"/"+rep.ID] = rep
+}
+
+// candidates returns the providers for a destination: those whose countries
+// match it first, then those without countries, each group by priority.
+func (r *SMSRouter) candidates(to string) []*smsRoute {
+	number := strings.TrimPrefix(to, "+")
+	var matched, general []*smsRoute
+	for _, rt := range r.routes {
+		if len(rt.countries) == 0 {
+			general = append(general, rt)
+			continue
+		}
+		for _, cc := range rt.countries {
+			if strings.HasPrefix(number, cc) {
+				matched = append(matched, rt)
+				break
+			}
+		}
+	}
+	return append(matched, general...)
+}
+
+func (rt *smsRoute) state(now time.Time) string {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return rt.stateLocked(now)
+}
+
+func (rt *smsRoute) stateLocked(now time.Time) string {
+	switch {
+	case rt.failures < rt.threshold:
+		return BreakerClosed
+	case now.Before(rt.openUntil):
+		return BreakerOpen
+	default:
+		// Cooldown is over: the next message is a trial.
+		return BreakerHalfOpen
+	}
+}
+
+func (rt *smsRoute) record(err error) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if err == nil {
+		if rt.failures >= rt.threshold {
+			log.Printf("[sms] provider %s recovered, circuit closed", rt.name)
+		}
+		rt.failures = 0
+		rt.lastError = ""
+		return
+	}
+	rt.failures++
+	rt.lastError = err.Error()
+	if rt.failures >= rt.threshold {
+		rt.openUntil = time.Now().Add(rt.cooldown)
+		if rt.failures == rt.threshold {
+			log.Printf("[sms] provider %s failed %d times in a row, circuit open for %s", rt.name, rt.failures, rt.cooldown)
+		}
+	}
+}
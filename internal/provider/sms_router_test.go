@@ -0,0 +1,100 @@
+package provider
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type fakeSMS struct {
+	fail bool
+	sent []string
+}
+
+func (f *fakeSMS) SendSMS(to, message string) error {
+	f.sent = append(f.sent, to)
+	if f.fail {
+		return errors.New("provider down")
+	}
+	return nil
+}
+
+func testRouter(routes ...*smsRoute) *SMSRouter {
+	r := &SMSRouter{}
+	for _, rt := range routes {
+		r.add(rt.name, rt.priority, rt.countries, rt.provider, rt.threshold, rt.cooldown)
+	}
+	return r
+}
+
+func TestSMSRouterRoutesByCountryAndFailsOver(t *testing.T) {
+	nl := &fakeSMS{fail: true}
+	us := &fakeSMS{}
+	general := &fakeSMS{}
+	r := testRouter(
+		&smsRoute{name: "nl", countries: []string{"+31"}, provider: nl},
+		&smsRoute{name: "us", countries: []string{"1"}, provider: us},
+		&smsRoute{name: "general", provider: general},
+	)
+
+	if err := r.SendSMS("+31612345678", "hi"); err != nil {
+		t.Fatalf("SendSMS: %v", err)
+	}
+	if len(nl.sent) != 1 || len(general.sent) != 1 || len(us.sent) != 0 {
+		t.Fatalf("sent nl=%d us=%d general=%d, want 1/0/1", len(nl.sent), len(us.sent), len(general.sent))
+	}
+
+	if err := r.SendSMS("+12025550123", "hi"); err != nil {
+		t.Fatalf("SendSMS: %v", err)
+	}
+	if len(us.sent) != 1 || len(general.sent) != 1 {
+		t.Fatalf("US number: sent us=%d general=%d, want 1/1", len(us.sent), len(general.sent))
+	}
+
+	general.fail = true
+	if err := r.SendSMS("+31612345678", "hi"); err == nil {
+		t.Fatal("expected an error when all providers fail")
+	}
+}
+
+func TestSMSRouterCircuitBreaker(t *testing.T) {
+	primary := &fakeSMS{fail: true}
+	backup := &fakeSMS{}
+	r := testRouter(
+		&smsRoute{name: "primary", provider: primary, threshold: 2, cooldown: time.Hour},
+		&smsRoute{name: "backup", priority: 1, provider: backup},
+	)
+
+	for i := 0; i < 4; i++ {
+		if err := r.SendSMS("+31612345678", "hi"); err != nil {
+			t.Fatalf("SendSMS %d: %v", i, err)
+		}
+	}
+	// Two failures open the circuit; later messages skip the primary.
+	if len(primary.sent) != 2 || len(backup.sent) != 4 {
+		t.Fatalf("sent primary=%d backup=%d, want 2/4", len(primary.sent), len(backup.sent))
+	}
+	if st := r.Status()[0]; st.Name != "primary" || st.State != BreakerOpen {
+		t.Fatalf("status = %+v, want primary open", st)
+	}
+
+	// An open provider is still the last resort when the others fail.
+	backup.fail = true
+	primary.fail = false
+	if err := r.SendSMS("+31612345678", "hi"); err != nil {
+		t.Fatalf("SendSMS: %v", err)
+	}
+	if st := r.Status()[0]; st.State != BreakerClosed {
+		t.Fatalf("state after success = %s, want closed", st.State)
+	}
+
+	// After the cooldown a single trial is let through.
+	r.routes[0].failures, r.routes[0].openUntil = 2, time.Now().Add(-time.Second)
+	if st := r.Status()[0]; st.State != BreakerHalfOpen {
+		t.Fatalf("state after cooldown = %s, want half-open", st.State)
+	}
+
+	if err := r.SendSMSVia("missing", "+31612345678", "hi"); !errors.Is(err, ErrUnknownSMSProvider) {
+		t.Fatalf("SendSMSVia unknown = %v", err)
+	}
+}
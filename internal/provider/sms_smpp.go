@@ -0,0 +1,356 @@
+package provider
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"tinyauth-sidecar/internal/config"
+)
+
+const defaultSMPPEnquireLink = 30 * time.Second
+
+var errSMPPClosed = errors.New("smpp: session closed")
+
+// SMPPSMSProvider sends SMS over an SMPP 3.4 transceiver session. The session
+// is opened on the first message and kept bound (with enquire_link
+// keepalives) so delivery receipts for earlier messages can arrive on it; it
+// is reopened on the next message after the SMSC drops it.
+type SMPPSMSProvider struct {
+	name    string
+	cfg     config.SMSSMPPConfig
+	sender  string
+	timeout time.Duration
+	tls     *tls.Config
+	enquire time.Duration
+
+	mu   sync.Mutex
+	sess *smppSession
+
+	reportMu sync.Mutex
+	onReport func(DeliveryReport)
+}
+
+// NewSMPPSMSProvider creates a type = "smpp" provider. Returns nil if incomplete.
+func NewSMPPSMSProvider(cfg config.SMSProviderConfig) SMSProvider {
+	if cfg.SMPP.Address == "" || cfg.SMPP.SystemID == "" {
+		log.Printf("[sms] %s: smpp.address and smpp.system_id are required", cfg.Name)
+		return nil
+	}
+	p := &SMPPSMSProvider{
+		name:    cfg.Name,
+		cfg:     cfg.SMPP,
+		sender:  cfg.Sender,
+		timeout: time.Duration(cfg.Timeout) * time.Second,
+		enquire: time.Duration(cfg.SMPP.EnquireLink) * time.Second,
+	}
+	if p.timeout <= 0 {
+		p.timeout = 15 * time.Second
+	}
+	if p.enquire <= 0 {
+		p.enquire = defaultSMPPEnquireLink
+	}
+	if cfg.SMPP.TLS {
+		tlsCfg, err := cfg.TLSOptions().Config()
+		if err != nil {
+			log.Printf("[sms] %s: tls: %v", cfg.Name, err)
+			return nil
+		}
+		host, _, _ := net.SplitHostPort(cfg.SMPP.Address)
+		tlsCfg.ServerName = host
+		p.tls = tlsCfg
+	}
+	log.Printf("[sms] SMPP SMS provider %s configured: %s", cfg.Name, cfg.SMPP.Address)
+	return p
+}
+
+// OnDeliveryReport registers the callback for delivery receipts.
+func (p *SMPPSMSProvider) OnDeliveryReport(fn func(DeliveryReport)) {
+	p.reportMu.Lock()
+	defer p.reportMu.Unlock()
+	p.onReport = fn
+}
+
+// SendSMS sends an SMS over SMPP.
+func (p *SMPPSMSProvider) SendSMS(to, message string) error {
+	return sendTracked(p, to, message)
+}
+
+// SendTrackedSMS submits an SMS and returns the SMSC message ID.
+func (p *SMPPSMSProvider) SendTrackedSMS(to, message string) (string, error) {
+	sess, err := p.session()
+	if err != nil {
+		return "", err
+	}
+
+	srcTON, srcNPI, src := smppAddress(p.sender)
+	_, _, dst := smppAddress(to)
+	dataCoding, text := smppText(message)
+	limit := smppMaxGSMLen
+	if dataCoding != 0 {
+		limit = smppMaxUCS2Len
+	}
+
+	var w smppWriter
+	w.cstring("") // service_type
+	w.WriteByte(srcTON)
+	w.WriteByte(srcNPI)
+	w.cstring(src)
+	w.WriteByte(1) // dest_addr_ton: international
+	w.WriteByte(1) // dest_addr_npi: E.164
+	w.cstring(dst)
+	w.WriteByte(0) // esm_class
+	w.WriteByte(0) // protocol_id
+	w.WriteByte(0) // priority_flag
+	w.cstring("")  // schedule_delivery_time
+	w.cstring("")  // validity_period
+	w.WriteByte(1) // registered_delivery: receipt on success and failure
+	w.WriteByte(0) // replace_if_present_flag
+	w.WriteByte(dataCoding)
+	w.WriteByte(0) // sm_default_msg_id
+	if len(text) <= limit {
+		w.WriteByte(byte(len(text)))
+		w.Write(text)
+	} else {
+		// Too long for one SMS: let the SMSC split it.
+		w.WriteByte(0)
+		w.tlv(smppTagMessagePayload, text)
+	}
+
+	resp, err := sess.request(smppSubmitSM, w.Bytes())
+	if err != nil {
+		return "", err
+	}
+	if resp.status != 0 {
+		return "", smppStatusError("submit_sm", resp.status)
+	}
+	id := (&smppReader{b: resp.body}).cstring()
+	log.Printf("[sms] sent SMS to %s via %s (message id %s)", to, p.name, id)
+	return id, nil
+}
+
+// Close unbinds the session, if any.
+func (p *SMPPSMSProvider) Close() {
+	p.mu.Lock()
+	sess := p.sess
+	p.sess = nil
+	p.mu.Unlock()
+	if sess != nil {
+		sess.request(smppUnbind, nil)
+		sess.close(nil)
+	}
+}
+
+// session returns the bound session, connecting and binding if needed.
+func (p *SMPPSMSProvider) session() (*smppSession, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.sess != nil && !p.sess.isClosed() {
+		return p.sess, nil
+	}
+
+	dialer := &net.Dialer{Timeout: p.timeout}
+	var conn net.Conn
+	var err error
+	if p.tls != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", p.cfg.Address, p.tls)
+	} else {
+		conn, err = dialer.Dial("tcp", p.cfg.Address)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("smpp dial %s: %w", p.cfg.Address, err)
+	}
+
+	sess := &smppSession{
+		conn:    conn,
+		timeout: p.timeout,
+		pending: make(map[uint32]chan smppPDU),
+		done:    make(chan struct{}),
+		deliver: p.handleDeliver,
+	}
+	go sess.readLoop()
+
+	var w smppWriter
+	w.cstring(p.cfg.SystemID)
+	w.cstring(p.cfg.Password)
+	w.cstring(p.cfg.SystemType)
+	w.WriteByte(0x34) // interface_version 3.4
+	w.WriteByte(0)    // addr_ton
+	w.WriteByte(0)    // addr_npi
+	w.cstring("")     // address_range
+	resp, err := sess.request(smppBindTransceiver, w.Bytes())
+	if err == nil && resp.status != 0 {
+		err = smppStatusError("bind", resp.status)
+	}
+	if err != nil {
+		sess.close(err)
+		return nil, err
+	}
+
+	go sess.keepalive(p.enquire)
+	p.sess = sess
+	log.Printf("[sms] %s: bound to %s as %s", p.name, p.cfg.Address, p.cfg.SystemID)
+	return sess, nil
+}
+
+func (p *SMPPSMSProvider) handleDeliver(pdu smppPDU) {
+	rep, ok, err := parseSMPPReceipt(pdu.body)
+	if err != nil {
+		log.Printf("[sms] %s: %v", p.name, err)
+		return
+	}
+	if !ok {
+		return
+	}
+	p.reportMu.Lock()
+	fn := p.onReport
+	p.reportMu.Unlock()
+	if fn != nil {
+		fn(rep)
+	}
+}
+
+// smppSession is one bound SMPP connection. Requests are matched to their
+// responses by sequence number; a reader goroutine answers the SMSC's own
+// requests (deliver_sm, enquire_link, unbind).
+type smppSession struct {
+	conn    net.Conn
+	timeout time.Duration
+	deliver func(smppPDU)
+	seq     atomic.Uint32
+
+	wmu sync.Mutex
+
+	mu      sync.Mutex
+	pending map[uint32]chan smppPDU
+	done    chan struct{}
+	err     error
+}
+
+func (s *smppSession) isClosed() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// close ends the session; pending requests fail with err (or errSMPPClosed).
+func (s *smppSession) close(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.isClosed() {
+		return
+	}
+	if err == nil {
+		err = errSMPPClosed
+	}
+	s.err = err
+	close(s.done)
+	s.conn.Close()
+}
+
+func (s *smppSession) write(pdu smppPDU) error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	s.conn.SetWriteDeadline(time.Now().Add(s.timeout))
+	_, err := s.conn.Write(pdu.marshal())
+	return err
+}
+
+// request sends a PDU and waits for its response.
+func (s *smppSession) request(command uint32, body []byte) (smppPDU, error) {
+	seq := s.seq.Add(1)
+	ch := make(chan smppPDU, 1)
+	s.mu.Lock()
+	if s.isClosed() {
+		s.mu.Unlock()
+		return smppPDU{}, s.err
+	}
+	s.pending[seq] = ch
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.pending, seq)
+		s.mu.Unlock()
+	}()
+
+	if err := s.write(smppPDU{command: command, seq: seq, body: body}); err != nil {
+		s.close(err)
+		return smppPDU{}, fmt.Errorf("smpp write: %w", err)
+	}
+
+	timer := time.NewTimer(s.timeout)
+	defer timer.Stop()
+	select {
+	case resp := <-ch:
+		if resp.command == smppGenericNack {
+			return resp, smppStatusError("generic_nack", resp.status)
+		}
+		return resp, nil
+	case <-s.done:
+		return smppPDU{}, s.err
+	case <-timer.C:
+		// The session is in an unknown state; start over on the next message.
+		s.close(errors.New("smpp: response timeout"))
+		return smppPDU{}, fmt.Errorf("smpp: no response within %s", s.timeout)
+	}
+}
+
+func (s *smppSession) readLoop() {
+	for {
+		pdu, err := readSMPPPDU(s.conn)
+		if err != nil {
+			s.close(fmt.Errorf("smpp read: %w", err))
+			return
+		}
+		if pdu.command&smppResp != 0 {
+			s.mu.Lock()
+			ch := s.pending[pdu.seq]
+			s.mu.Unlock()
+			if ch != nil {
+				select {
+				case ch <- pdu:
+				default: // duplicate response
+				}
+			}
+			continue
+		}
+		switch pdu.command {
+		case smppDeliverSM:
+			s.write(smppPDU{command: smppDeliverSM | smppResp, seq: pdu.seq, body: []byte{0}})
+			s.deliver(pdu)
+		case smppEnquireLink:
+			s.write(smppPDU{command: smppEnquireLink | smppResp, seq: pdu.seq})
+		case smppUnbind:
+			s.write(smppPDU{command: smppUnbind | smppResp, seq: pdu.seq})
+			s.close(errors.New("smpp: unbound by SMSC"))
+			return
+		default:
+			// ESME_RINVCMDID
+			s.write(smppPDU{command: smppGenericNack, status: 0x00000003, seq: pdu.seq})
+		}
+	}
+}
+
+func (s *smppSession) keepalive(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if _, err := s.request(smppEnquireLink, nil); err != nil {
+				s.close(err)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package provider
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"tinyauth-sidecar/internal/config"
+)
+
+const twilioDefaultURL = "https://api.twilio.com"
+
+// TwilioSMSProvider sends SMS through the Twilio Messages API or a compatible one.
+type TwilioSMSProvider struct {
+	gw                  *smsGateway
+	accountSID          string
+	authToken           string
+	messagingServiceSID string
+}
+
+// NewTwilioSMSProvider creates a type = "twilio" provider. Returns nil if incomplete.
+func NewTwilioSMSProvider(cfg config.SMSProviderConfig) SMSProvider {
+	t := cfg.Twilio
+	if t.AccountSID == "" || t.AuthToken == "" || (cfg.Sender == "" && t.MessagingServiceSID == "") {
+		log.Printf("[sms] %s: twilio.account_sid, twilio.auth_token and sender (or twilio.messaging_service_sid) are required", cfg.Name)
+		return nil
+	}
+	gw := newSMSGateway(cfg, twilioDefaultURL)
+	if gw == nil {
+		return nil
+	}
+	log.Printf("[sms] Twilio SMS provider %s configured: %s", cfg.Name, gw.url)
+	return &TwilioSMSProvider{gw: gw, accountSID: t.AccountSID, authToken: t.AuthToken, messagingServiceSID: t.MessagingServiceSID}
+}
+
+type twilioResponse struct {
+	SID          string `json:"sid"`
+	Code         int    `json:"code"`
+	Message      string `json:"message"`
+	ErrorCode    *int   `json:"error_code"`
+	ErrorMessage string `json:"error_message"`
+}
+
+// SendSMS sends an SMS through Twilio.
+func (p *TwilioSMSProvider) SendSMS(to, message string) error {
+	return sendTracked(p, to, message)
+}
+
+// SendTrackedSMS sends an SMS and returns the Twilio message SID.
+func (p *TwilioSMSProvider) SendTrackedSMS(to, message string) (string, error) {
+	form := url.Values{"To": {to}, "Body": {message}}
+	if p.messagingServiceSID != "" {
+		form.Set("MessagingServiceSid", p.messagingServiceSID)
+	} else {
+		form.Set("From", p.gw.sender)
+	}
+	if p.gw.report != "" {
+		form.Set("StatusCallback", p.gw.report)
+	}
+
+	endpoint := strings.TrimSuffix(p.gw.url, "/") + "/2010-04-01/Accounts/" + url.PathEscape(p.accountSID) + "/Messages.json"
+	auth := "Basic " + base64.StdEncoding.EncodeToString([]byte(p.accountSID+":"+p.authToken))
+	status, respBody, err := p.gw.post(endpoint, "application/x-www-form-urlencoded", []byte(form.Encode()), http.Header{"Authorization": {auth}})
+	if err != nil {
+		return "", err
+	}
+	var resp twilioResponse
+	if jerr := json.Unmarshal(respBody, &resp); jerr != nil {
+		return "", fmt.Errorf("twilio: HTTP %d: %s", status, truncate(respBody))
+	}
+	if status >= 400 {
+		return "", fmt.Errorf("twilio: HTTP %d: %s (code %d)", status, resp.Message, resp.Code)
+	}
+	if resp.ErrorCode != nil {
+		return "", fmt.Errorf("twilio: %s (code %d)", resp.ErrorMessage, *resp.ErrorCode)
+	}
+	if resp.SID == "" {
+		return "", fmt.Errorf("twilio: HTTP %d: no message sid: %s", status, truncate(respBody))
+	}
+
+	log.Printf("[sms] sent SMS to %s via %s (sid %s)", to, p.gw.name, resp.SID)
+	return resp.SID, nil
+}
+
+// ParseDeliveryReports reads a Twilio status callback (MessageSid,
+// MessageStatus, ErrorCode).
+func (p *TwilioSMSProvider) ParseDeliveryReports(r *http.Request) ([]DeliveryReport, error) {
+	v, err := reportValues(r)
+	if err != nil {
+		return nil, err
+	}
+	sid, raw := v["messagesid"], v["messagestatus"]
+	if sid == "" || raw == "" {
+		return nil, fmt.Errorf("twilio report: missing MessageSid or MessageStatus")
+	}
+	var status string
+	switch raw {
+	case "delivered":
+		status = SMSStatusDelivered
+	case "undelivered", "failed":
+		status = SMSStatusFailed
+	default: // accepted, queued, sending, sent
+		status = SMSStatusSent
+	}
+	detail := raw
+	if e := v["errorcode"]; e != "" {
+		detail += " (error " + e + ")"
+	}
+	return []DeliveryReport{{ID: sid, Status: status, Detail: detail}}, nil
+}
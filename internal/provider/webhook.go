@@ -0,0 +1,160 @@
+package provider
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"tinyauth-sidecar/internal/config"
+	"tinyauth-sidecar/internal/httpclient"
+	"tinyauth-sidecar/internal/tmpl"
+	"tinyauth-sidecar/pkg/webhooksig"
+)
+
+// RenderedRequest is a webhook request after templating, as returned by dry runs.
+type RenderedRequest struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+}
+
+// WebhookResponse is the downstream response to a webhook request.
+type WebhookResponse struct {
+	Status int    `json:"status"`
+	Body   string `json:"body"`
+}
+
+// newWebhookClient builds the HTTP client for a webhook. It is built once per
+// hook or provider so requests reuse connections and TLS sessions. A broken CA
+// or client certificate file is logged under logPrefix and returns nil; the
+// caller then leaves the webhook disabled.
+func newWebhookClient(logPrefix string, wc config.WebhookConfig) *http.Client {
+	client, err := httpclient.New(time.Duration(wc.Timeout)*time.Second, wc.TLSOptions())
+	if err != nil {
+		log.Printf("%s %s: tls: %v", logPrefix, wc.Name, err)
+		return nil
+	}
+	return client
+}
+
+// sendWebhook renders the webhook URL, body and headers with data and sends the request.
+// JSON bodies are rendered with automatic escaping (see tmpl.RenderJSON).
+// Returns the HTTP status code; statuses >= 400 are returned as errors.
+// If the webhook has a signing secret, the request carries Standard Webhooks
+// signature headers over the rendered body, with messageID as webhook-id (a
+// fresh one when empty).
+func sendWebhook(client *http.Client, wc config.WebhookConfig, data map[string]string, messageID string) (int, error) {
+	req, err := renderWebhook(wc, data, messageID)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := doWebhook(client, req)
+	if err != nil {
+		return 0, err
+	}
+	if resp.Status >= 400 {
+		return resp.Status, fmt.Errorf("HTTP %d: %s", resp.Status, resp.Body)
+	}
+	return resp.Status, nil
+}
+
+// renderWebhook renders the request for wc with data, including signature headers.
+func renderWebhook(wc config.WebhookConfig, data map[string]string, messageID string) (RenderedRequest, error) {
+	urlStr, err := tmpl.Render("url", wc.URL, data)
+	if err != nil {
+		return RenderedRequest{}, fmt.Errorf("template url: %w", err)
+	}
+
+	bodyStr, err := tmpl.RenderBody("body", wc.Body, wc.ContentType, data)
+	if err != nil {
+		return RenderedRequest{}, fmt.Errorf("template body: %w", err)
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", wc.ContentType)
+	for _, hdr := range wc.Headers {
+		headerVal, err := tmpl.Render("header-"+hdr.Key, hdr.Value, data)
+		if err != nil {
+			return RenderedRequest{}, fmt.Errorf("template header %s: %w", hdr.Key, err)
+		}
+		header.Set(hdr.Key, headerVal)
+	}
+
+	if wc.SigningSecret != "" {
+		signer, err := webhooksig.NewSigner(wc.SigningSecret)
+		if err != nil {
+			return RenderedRequest{}, fmt.Errorf("signing secret: %w", err)
+		}
+		if messageID == "" {
+			messageID = webhooksig.NewID()
+		}
+		signer.SignHeadersWithID(header, messageID, []byte(bodyStr))
+	}
+
+	headers := make(map[string]string, len(header))
+	for k := range header {
+		headers[k] = header.Get(k)
+	}
+	return RenderedRequest{Method: wc.Method, URL: urlStr, Headers: headers, Body: bodyStr}, nil
+}
+
+// doWebhook sends a rendered request and returns the status and the first
+// 1 KiB of the response body.
+func doWebhook(client *http.Client, r RenderedRequest) (WebhookResponse, error) {
+	req, err := http.NewRequest(r.Method, r.URL, bytes.NewBufferString(r.Body))
+	if err != nil {
+		return WebhookResponse{}, fmt.Errorf("create request: %w", err)
+	}
+	for k, v := range r.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return WebhookResponse{}, fmt.Errorf("http request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return WebhookResponse{Status: resp.StatusCode, Body: string(respBody)}, nil
+}
+
+// buildTemplateData merges env vars and explicit vars into a single map.
+// Explicit vars win over env entries with the same name.
+func buildTemplateData(env map[string]string, vars map[string]string) map[string]string {
+	data := make(map[string]string, len(env)+len(vars))
+	for k, v := range env {
+		data[k] = v
+	}
+	for k, v := range vars {
+		data[k] = v
+	}
+	return data
+}
+
+// resolveEnvMap resolves "$NAME", ${env:NAME} and ${file:/path} references in
+// the map values in place. Unresolvable values are logged and left empty.
+func resolveEnvMap(logPrefix string, m map[string]string) {
+	for k, v := range m {
+		r, err := config.ResolveEnvValue(v)
+		if err != nil {
+			log.Printf("%s %s: %v", logPrefix, k, err)
+		}
+		m[k] = r
+	}
+}
+
+// resolveRefMap resolves ${env:NAME} and ${file:/path} references in the map values in place.
+func resolveRefMap(logPrefix string, m map[string]string) {
+	for k, v := range m {
+		r, err := config.ResolveRefs(v)
+		if err != nil {
+			log.Printf("%s %s: %v", logPrefix, k, err)
+		}
+		m[k] = r
+	}
+}
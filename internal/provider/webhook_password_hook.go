@@ -1,124 +1,136 @@
 package provider
 
 import (
-	"bytes"
-	"crypto/tls"
-	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"sort"
 	"strings"
-	"text/template"
-	"time"
 
 	"tinyauth-sidecar/internal/config"
 )
 
 // WebhookPasswordHook sends password changes to a configurable webhook.
 type WebhookPasswordHook struct {
-	cfg config.WebhookConfig
+	cfg    config.WebhookConfig
+	client *http.Client
 }
 
 // NewWebhookPasswordHook creates a webhook-based password change hook from config.
-// Returns nil if not enabled or URL is empty.
+// Returns nil if not enabled, URL is empty or the TLS files can't be loaded.
 func NewWebhookPasswordHook(cfg config.WebhookConfig) PasswordChangeHook {
 	if !cfg.Enabled || cfg.URL == "" {
 		return nil
 	}
 	if cfg.Body == "" {
-		log.Printf("[password-hook] enabled but body is empty")
+		log.Printf("[password-hook] %s: enabled but body is empty", cfg.Name)
 		return nil
 	}
 	if strings.HasPrefix(cfg.URL, "http://") {
-		log.Printf("[password-hook] WARNING: hook URL uses plain HTTP (not HTTPS): %s — passwords will be sent unencrypted!", cfg.URL)
+		log.Printf("[password-hook] %s: WARNING: hook URL uses plain HTTP (not HTTPS): %s — passwords will be sent unencrypted!", cfg.Name, cfg.URL)
 	}
-	log.Printf("[password-hook] webhook configured: %s %s (filters: domains=%v roles=%v emails=%v)",
-		cfg.Method, cfg.URL, cfg.FilterDomains, cfg.FilterRoles, cfg.FilterUsers)
-	return &WebhookPasswordHook{cfg: cfg}
+	client := newWebhookClient("[password-hook]", cfg)
+	if client == nil {
+		return nil
+	}
+	log.Printf("[password-hook] %s: webhook configured: %s %s (filters: domains=%v roles=%v emails=%v)",
+		cfg.Name, cfg.Method, cfg.URL, cfg.FilterDomains, cfg.FilterRoles, cfg.FilterUsers)
+	return &WebhookPasswordHook{cfg: cfg, client: client}
 }
 
-// passwordHookData is the template data for password hook templates.
-type passwordHookData struct {
-	Email    string
-	User     string
-	Domain   string
-	Password string
-	Role     string
+// passwordHookData builds the template data for password hook templates:
+// {{.Email}}, {{.Username}}, {{.User}}, {{.Domain}}, {{.Password}},
+// {{.HashedPassword}}, {{.OldPassword}}, {{.OldHashedPassword}}, {{.Role}}
+// plus any entries from the hook's env map.
+func passwordHookData(ctx PasswordChangeContext, env map[string]string) map[string]string {
+	user, domain := splitEmail(ctx.Email)
+	return buildTemplateData(env, map[string]string{
+		"Email":             ctx.Email,
+		"Username":          ctx.Email,
+		"User":              user,
+		"Domain":            domain,
+		"Password":          ctx.Password,
+		"HashedPassword":    ctx.HashedPassword,
+		"OldPassword":       ctx.OldPassword,
+		"OldHashedPassword": ctx.OldHashedPassword,
+		"Role":              ctx.Role,
+	})
 }
 
-func (h *WebhookPasswordHook) OnPasswordChanged(ctx PasswordChangeContext) error {
-	email := ctx.Email
-	user, domain := email, ""
+// splitEmail splits an address into the parts before and after the @.
+// Usernames without @ are returned as-is with an empty domain.
+func splitEmail(email string) (user, domain string) {
 	if parts := strings.SplitN(email, "@", 2); len(parts) == 2 {
-		user, domain = parts[0], parts[1]
+		return parts[0], parts[1]
 	}
+	return email, ""
+}
+
+// MatchesHookFilters reports whether the hook's domain/role/user filters select this change.
+func MatchesHookFilters(cfg config.WebhookConfig, ctx PasswordChangeContext) bool {
+	return matchesFilters("[password-hook]", cfg, ctx.Email, ctx.Role)
+}
+
+// matchesFilters applies filter_domains, filter_roles and filter_users to a user.
+func matchesFilters(logPrefix string, cfg config.WebhookConfig, email, role string) bool {
+	_, domain := splitEmail(email)
 
 	// Check filters (empty = match all)
-	if !matchesFilter(domain, h.cfg.FilterDomains) {
-		log.Printf("[password-hook] skipping %s: domain %q not in filter %v", email, domain, h.cfg.FilterDomains)
-		return nil
+	if !matchesFilter(domain, cfg.FilterDomains) {
+		log.Printf("%s %s: skipping %s: domain %q not in filter %v", logPrefix, cfg.Name, email, domain, cfg.FilterDomains)
+		return false
 	}
-	if !matchesFilter(ctx.Role, h.cfg.FilterRoles) {
-		log.Printf("[password-hook] skipping %s: role %q not in filter %v", email, ctx.Role, h.cfg.FilterRoles)
-		return nil
+	if !matchesFilter(role, cfg.FilterRoles) {
+		log.Printf("%s %s: skipping %s: role %q not in filter %v", logPrefix, cfg.Name, email, role, cfg.FilterRoles)
+		return false
 	}
-	if !matchesFilter(email, h.cfg.FilterUsers) {
-		log.Printf("[password-hook] skipping %s: email not in filter %v", email, h.cfg.FilterUsers)
-		return nil
+	if !matchesFilter(email, cfg.FilterUsers) {
+		log.Printf("%s %s: skipping %s: email not in filter %v", logPrefix, cfg.Name, email, cfg.FilterUsers)
+		return false
 	}
+	return true
+}
 
-	data := passwordHookData{
-		Email:    email,
-		User:     user,
-		Domain:   domain,
-		Password: ctx.Password,
-		Role:     ctx.Role,
-	}
+// Config returns the hook configuration.
+func (h *WebhookPasswordHook) Config() config.WebhookConfig { return h.cfg }
 
-	urlStr, err := execTmpl("url", h.cfg.URL, data)
-	if err != nil {
-		return fmt.Errorf("template url: %w", err)
+func (h *WebhookPasswordHook) OnPasswordChanged(ctx PasswordChangeContext) error {
+	if !MatchesHookFilters(h.cfg, ctx) {
+		return nil
 	}
 
-	bodyStr, err := execTmpl("body", h.cfg.Body, data)
+	status, err := sendWebhook(h.client, h.cfg, passwordHookData(ctx, h.cfg.Env), ctx.MessageID)
 	if err != nil {
-		return fmt.Errorf("template body: %w", err)
+		return fmt.Errorf("%s: %w", h.cfg.Name, err)
 	}
 
-	req, err := http.NewRequest(h.cfg.Method, urlStr, bytes.NewBufferString(bodyStr))
-	if err != nil {
-		return fmt.Errorf("create request: %w", err)
-	}
-	req.Header.Set("Content-Type", h.cfg.ContentType)
+	log.Printf("[password-hook] %s: synced for %s (HTTP %d)", h.cfg.Name, ctx.Email, status)
+	return nil
+}
 
-	for _, hdr := range h.cfg.Headers {
-		headerVal, err := execTmpl("header-"+hdr.Key, hdr.Value, data)
-		if err != nil {
-			return fmt.Errorf("template header %s: %w", hdr.Key, err)
-		}
-		req.Header.Set(hdr.Key, headerVal)
+// RollbackPasswordChange sends the configured rollback request, which can use
+// {{.OldPassword}} / {{.OldHashedPassword}} to restore the previous password.
+func (h *WebhookPasswordHook) RollbackPasswordChange(ctx PasswordChangeContext) error {
+	if h.cfg.RollbackBody == "" {
+		return fmt.Errorf("%s: no rollback_body configured", h.cfg.Name)
 	}
 
-	client := &http.Client{Timeout: time.Duration(h.cfg.Timeout) * time.Second}
-	if h.cfg.SkipTLSVerify {
-		client.Transport = &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		}
+	rb := h.cfg
+	rb.Body = h.cfg.RollbackBody
+	if h.cfg.RollbackURL != "" {
+		rb.URL = h.cfg.RollbackURL
 	}
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("http request: %w", err)
+	if h.cfg.RollbackMethod != "" {
+		rb.Method = h.cfg.RollbackMethod
 	}
-	defer resp.Body.Close()
 
-	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
-	if resp.StatusCode >= 400 {
-		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(respBody))
+	status, err := sendWebhook(h.client, rb, passwordHookData(ctx, h.cfg.Env), "")
+	if err != nil {
+		return fmt.Errorf("%s: rollback: %w", h.cfg.Name, err)
 	}
 
-	log.Printf("[password-hook] synced for %s (HTTP %d)", email, resp.StatusCode)
+	log.Printf("[password-hook] %s: rolled back for %s (HTTP %d)", h.cfg.Name, ctx.Email, status)
 	return nil
 }
 
@@ -137,36 +149,104 @@ func matchesFilter(value string, filter []string) bool {
 	return false
 }
 
-// jsonEscape returns a JSON-safe string (without surrounding quotes).
-func jsonEscape(s string) string {
-	b, _ := json.Marshal(s)
-	// Remove surrounding quotes
-	return string(b[1 : len(b)-1])
+// redactedPassword replaces plaintext passwords in dry-run output. It only
+// uses letters so it survives urlquery, base64 and JSON escaping recognisably.
+const redactedPassword = "REDACTED"
+
+// HookDryRun is the result of a password hook dry run.
+type HookDryRun struct {
+	Hook     string           `json:"hook"`
+	Type     string           `json:"type"`
+	Matches  bool             `json:"matches"`
+	Request  RenderedRequest  `json:"request"`
+	Sent     bool             `json:"sent"`
+	Response *WebhookResponse `json:"response,omitempty"`
+	Error    string           `json:"error,omitempty"`
 }
 
-// digitsOnly strips everything except digits from a string.
-func digitsOnly(s string) string {
-	var buf bytes.Buffer
-	for _, r := range s {
-		if r >= '0' && r <= '9' {
-			buf.WriteRune(r)
-		}
+// DryRunPasswordHook renders the request a webhook password hook would send for
+// ctx, with the plaintext passwords and the hook's secrets redacted (see
+// redactSecrets). When send is true the request is
+// also sent with the real values from ctx and the downstream response is
+// returned. Errors while rendering or sending are reported in the result;
+// only non-webhook hooks are rejected with an error.
+func DryRunPasswordHook(hook PasswordChangeHook, ctx PasswordChangeContext, send bool) (HookDryRun, error) {
+	wc := hook.Config()
+	res := HookDryRun{Hook: wc.Name, Type: config.HookTypeWebhook, Matches: MatchesHookFilters(wc, ctx)}
+	wh, ok := hook.(*WebhookPasswordHook)
+	if !ok {
+		return res, fmt.Errorf("hook %q has type %q; dry runs are only supported for webhook hooks", wc.Name, wc.Type)
+	}
+
+	shown := ctx
+	shown.Password = redactedPassword
+	if ctx.OldPassword != "" {
+		shown.OldPassword = redactedPassword
+	}
+	req, err := renderWebhook(wc, passwordHookData(shown, wc.Env), "")
+	if err != nil {
+		res.Error = err.Error()
+		return res, nil
+	}
+	res.Request = redactSecrets(req, wc)
+	if !send {
+		return res, nil
 	}
-	return buf.String()
-}
 
-func execTmpl(name, tmplStr string, data interface{}) (string, error) {
-	tmpl, err := template.New(name).Funcs(template.FuncMap{
-		"jsonEscape":          jsonEscape,
-		"digitsOnly":          digitsOnly,
-		"replace": strings.ReplaceAll,
-	}).Parse(tmplStr)
+	actual, err := renderWebhook(wc, passwordHookData(ctx, wc.Env), "")
+	if err != nil {
+		res.Error = err.Error()
+		return res, nil
+	}
+	res.Sent = true
+	resp, err := doWebhook(wh.client, actual)
 	if err != nil {
-		return "", err
+		res.Error = err.Error()
+		return res, nil
 	}
-	var buf bytes.Buffer
-	if err := tmpl.Execute(&buf, data); err != nil {
-		return "", err
+	res.Response = &resp
+	log.Printf("[password-hook] %s: test request for %s returned HTTP %d", wc.Name, ctx.Email, resp.Status)
+	return res, nil
+}
+
+// redactSecrets hides the values a dry run must not show, like the outbox
+// keeps them out of the admin view: the values of configured headers (usually
+// credentials, possibly resolved from ${env:..} or ${file:..}), the hook's env
+// values wherever they were rendered, and a password in the URL.
+func redactSecrets(req RenderedRequest, wc config.WebhookConfig) RenderedRequest {
+	var secrets []string
+	for _, v := range wc.Env {
+		if v != "" {
+			secrets = append(secrets, v)
+		}
+	}
+	// Longest first, so a secret containing another is replaced whole.
+	sort.Slice(secrets, func(i, j int) bool { return len(secrets[i]) > len(secrets[j]) })
+	redact := func(s string) string {
+		for _, secret := range secrets {
+			s = strings.ReplaceAll(s, secret, redactedPassword)
+		}
+		return s
+	}
+
+	if u, err := url.Parse(req.URL); err == nil && u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), redactedPassword)
+			req.URL = u.String()
+		}
+	}
+	req.URL = redact(req.URL)
+	req.Body = redact(req.Body)
+
+	headers := make(map[string]string, len(req.Headers))
+	for k, v := range req.Headers {
+		headers[k] = redact(v)
+	}
+	for _, hdr := range wc.Headers {
+		if k := http.CanonicalHeaderKey(hdr.Key); headers[k] != "" {
+			headers[k] = redactedPassword
+		}
 	}
-	return buf.String(), nil
+	req.Headers = headers
+	return req
 }
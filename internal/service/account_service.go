@@ -8,10 +8,12 @@ import (
 	"log"
 	"math/big"
 	"regexp"
+	"slices"
 	"strings"
 	"time"
 
 	"tinyauth-sidecar/internal/config"
+	"tinyauth-sidecar/internal/phone"
 	"tinyauth-sidecar/internal/provider"
 	"tinyauth-sidecar/internal/store"
 
@@ -24,28 +26,25 @@ import (
 var emailRegex = regexp.MustCompile(`^[a-zA-Z0-9._%+\-]+@[a-zA-Z0-9.\-]+\.[a-zA-Z]{2,}$`)
 
 type AccountService struct {
-	cfg             *config.Config
-	store           *store.Store
-	users           *UserFileService
-	mail            *MailService
-	docker          *DockerService
-	passwordTargets *provider.PasswordTargetProvider
-	passwordHooks   []provider.PasswordChangeHook
-	sms             provider.SMSProvider
-	audit           *AuditService
-}
-
-func NewAccountService(cfg *config.Config, st *store.Store, users *UserFileService, mail *MailService, docker *DockerService, passwordTargets *provider.PasswordTargetProvider, sms provider.SMSProvider, audit *AuditService, passwordHooks ...provider.PasswordChangeHook) *AccountService {
-	var hooks []provider.PasswordChangeHook
-	for _, h := range passwordHooks {
-		if h != nil {
-			hooks = append(hooks, h)
-		}
-	}
-	return &AccountService{cfg: cfg, store: st, users: users, mail: mail, docker: docker, passwordTargets: passwordTargets, passwordHooks: hooks, sms: sms, audit: audit}
+	cfg    *config.Config
+	store  *store.Store
+	users  *UserFileService
+	mail   *MailService
+	docker *DockerService
+	hooks  *HookDeliveryService
+	sms    provider.SMSProvider
+	smsTpl *SMSTemplates
+	audit  *AuditService
+	events *EventBus
+}
+
+func NewAccountService(cfg *config.Config, st *store.Store, users *UserFileService, mail *MailService, docker *DockerService, sms provider.SMSProvider, smsTpl *SMSTemplates, audit *AuditService, hooks *HookDeliveryService, events *EventBus) *AccountService {
+	return &AccountService{cfg: cfg, store: st, users: users, mail: mail, docker: docker, hooks: hooks, sms: sms, smsTpl: smsTpl, audit: audit, events: events}
 }
 
-func (s *AccountService) RequestPasswordReset(username, clientIP string) error {
+// RequestPasswordReset mails a reset link. lang is the request's
+// Accept-Language header, used when the user has no language set.
+func (s *AccountService) RequestPasswordReset(username, clientIP, lang string) error {
 	u, ok, err := s.users.Find(username)
 	if err != nil {
 		return err
@@ -87,10 +86,11 @@ func (s *AccountService) RequestPasswordReset(username, clientIP string) error {
 	}
 
 	s.audit.Log("password_reset_request", username, clientIP, "sent")
-	return s.mail.SendResetEmail(toEmail, token)
+	s.events.Emit(provider.EventPasswordResetRequested, u.Username, clientIP, map[string]string{"Channel": "email"})
+	return s.mail.SendResetEmail(toEmail, s.store.LookupName(u.Username), token, s.mailLang(u.Username, lang))
 }
 
-func (s *AccountService) ResetPassword(token, newPassword, clientIP string) error {
+func (s *AccountService) ResetPassword(token, newPassword, clientIP, lang string) error {
 	username, expiresAt, used, err := s.store.GetResetToken(token)
 	if err != nil {
 		return err
@@ -114,17 +114,19 @@ func (s *AccountService) ResetPassword(token, newPassword, clientIP string) erro
 	if !ok {
 		return errors.New("user not found")
 	}
-	u.Password = hash
-	if err := s.users.Upsert(u); err != nil {
+	hookCtx, err := s.commitPassword(u, newPassword, hash, "")
+	if err != nil {
+		s.auditHookFailure("password_reset_confirm", username, clientIP, err)
 		return err
 	}
 	_ = s.store.MarkResetTokenUsed(token)
 	if err := s.docker.RestartTinyauth(); err != nil {
 		log.Printf("[restart] %v", err)
 	}
-	s.syncPasswordTargets(username, newPassword, hash)
-	s.notifyPasswordChanged(username)
+	s.runPasswordHooks(hookCtx)
+	s.notifyPasswordChanged(username, lang)
 	s.audit.Log("password_reset_confirm", username, clientIP, "success")
+	s.events.Emit(provider.EventPasswordChanged, username, clientIP, map[string]string{"Method": "reset"})
 	return nil
 }
 
@@ -149,28 +151,241 @@ func (s *AccountService) Profile(username string) (map[string]any, error) {
 	}
 	phone, _ := s.store.GetPhone(username)
 	email, _ := s.store.GetEmail(username)
-	role := ""
+	role, language, pendingEmail, pendingPhone, otpMethod := "", "", "", "", ""
 	if meta := s.store.GetUserMeta(username); meta != nil {
-		role = meta.Role
+		role, language, pendingEmail, pendingPhone, otpMethod = meta.Role, meta.Language, meta.PendingEmail, meta.PendingPhone, meta.OTPMethod
 	}
 	return map[string]any{
-		"username":    u.Username,
-		"totpEnabled": strings.TrimSpace(u.TotpSecret) != "",
-		"phone":       phone,
-		"email":       email,
-		"role":        role,
+		"username":     u.Username,
+		"totpEnabled":  strings.TrimSpace(u.TotpSecret) != "",
+		"phone":        phone,
+		"pendingPhone": pendingPhone,
+		"email":        email,
+		"pendingEmail": pendingEmail,
+		"role":         role,
+		"language":     language,
+		"otpMethod":    otpMethod,
+		"otpMethods":   s.OTPMethods(username),
 	}, nil
 }
 
-func (s *AccountService) SetPhone(username, phone string) error {
-	return s.store.SetPhone(username, phone)
+// SetPhone starts a phone number change. The number is normalized to E.164
+// and, with SMS configured, stored as pending until the user confirms the
+// code sent to it (ConfirmPhone); pending reports whether that is the case.
+// An empty number removes the phone right away. lang is the request's
+// Accept-Language header, used for the SMS when the user has no language set.
+func (s *AccountService) SetPhone(username, number, clientIP, lang string) (pending bool, err error) {
+	old, _ := s.store.GetPhone(username)
+	if strings.TrimSpace(number) == "" {
+		if err := s.store.SetPendingPhone(username, ""); err != nil {
+			return false, err
+		}
+		if old == "" {
+			return false, nil
+		}
+		return false, s.phoneChanged(username, old, "", clientIP)
+	}
+	e164, err := s.normalizePhone(number)
+	if err != nil {
+		return false, errors.New("invalid_phone")
+	}
+	if e164 == old {
+		// Re-entering the current number cancels a pending change.
+		return false, s.store.SetPendingPhone(username, "")
+	}
+	if other, _ := s.store.FindUserByPhone(e164); other != "" && other != username {
+		return false, errors.New("phone_in_use")
+	}
+	if s.sms == nil {
+		// Without SMS the number can't be verified, but it isn't used for resets either.
+		return false, s.phoneChanged(username, old, e164, clientIP)
+	}
+
+	if s.store.PhoneCodeSentWithin(username, time.Minute) {
+		return false, errors.New("code_recently_sent")
+	}
+	code, err := generateNumericCode(s.cfg.SMSCodeLength)
+	if err != nil {
+		return false, err
+	}
+	msg, err := s.smsCodeText(SMSPhoneVerification, username, lang, code)
+	if err != nil {
+		return false, err
+	}
+	if err := s.store.StorePhoneCode(username, e164, code, s.smsCodeExpiry()); err != nil {
+		return false, err
+	}
+	if err := s.store.SetPendingPhone(username, e164); err != nil {
+		return false, err
+	}
+	if err := s.sms.SendSMS(e164, msg); err != nil {
+		log.Printf("[sms] failed to send SMS to %s: %v", e164, err)
+		s.audit.Log("phone_change_request", username, clientIP, "send_failed")
+		return false, fmt.Errorf("failed to send SMS")
+	}
+	s.audit.Log("phone_change_request", username, clientIP, "sent")
+	return true, nil
 }
 
-func (s *AccountService) SetEmail(username, email string) error {
-	return s.store.SetEmail(username, email)
+// ConfirmPhone activates the pending phone number with the code sent by SetPhone.
+func (s *AccountService) ConfirmPhone(username, code, clientIP string) error {
+	e164, err := s.store.VerifyPhoneCode(username, strings.TrimSpace(code), s.cfg.SMSCodeMaxAttempts)
+	if err != nil {
+		s.audit.Log("phone_change_confirm", username, clientIP, "failed:"+err.Error())
+		return err
+	}
+	if other, _ := s.store.FindUserByPhone(e164); other != "" && other != username {
+		s.audit.Log("phone_change_confirm", username, clientIP, "phone_in_use")
+		return errors.New("phone_in_use")
+	}
+	old, err := s.store.ConfirmPendingPhone(username, e164)
+	if errors.Is(err, store.ErrPhoneSuperseded) {
+		s.audit.Log("phone_change_confirm", username, clientIP, "superseded")
+		return errors.New("invalid code")
+	}
+	if err != nil {
+		return err
+	}
+	s.audit.Log("phone_change_confirm", username, clientIP, "success")
+	s.events.Emit(provider.EventPhoneChanged, username, clientIP, map[string]string{"OldPhone": old, "NewPhone": e164})
+	return nil
 }
 
-func (s *AccountService) ChangePassword(username, oldPassword, newPassword, clientIP string) error {
+// phoneChanged stores phone as the user's number and emits the change event.
+func (s *AccountService) phoneChanged(username, old, phone, clientIP string) error {
+	if err := s.store.SetPhone(username, phone); err != nil {
+		return err
+	}
+	if err := s.store.SetPendingPhone(username, ""); err != nil {
+		return err
+	}
+	s.events.Emit(provider.EventPhoneChanged, username, clientIP, map[string]string{"OldPhone": old, "NewPhone": phone})
+	s.dropUnreachableOTP(username, clientIP)
+	return nil
+}
+
+// normalizePhone converts number to E.164 using the configured default country.
+func (s *AccountService) normalizePhone(number string) (string, error) {
+	return phone.NormalizeE164(number, s.cfg.PhoneDefaultCountry)
+}
+
+// SetEmail starts an email change: the address is stored as pending and a
+// verification link is mailed to it. The change takes effect in VerifyEmail.
+// An empty address removes the email right away.
+func (s *AccountService) SetEmail(username, email, clientIP, lang string) error {
+	email = strings.TrimSpace(email)
+	old, _ := s.store.GetEmail(username)
+	if email == "" {
+		if err := s.store.SetPendingEmail(username, ""); err != nil {
+			return err
+		}
+		if old == "" {
+			return nil
+		}
+		if err := s.store.SetEmail(username, ""); err != nil {
+			return err
+		}
+		s.emailChanged(username, old, "", clientIP, lang)
+		return nil
+	}
+	if !emailRegex.MatchString(email) {
+		return errors.New("invalid_email")
+	}
+	if strings.EqualFold(email, old) {
+		// Re-entering the current address cancels a pending change.
+		return s.store.SetPendingEmail(username, "")
+	}
+	if other, _ := s.store.FindUserByEmail(email); other != "" && other != username {
+		return errors.New("email_in_use")
+	}
+
+	token := uuid.NewString()
+	exp := time.Now().Add(time.Duration(s.cfg.EmailVerifyTTLSeconds) * time.Second).Unix()
+	if err := s.store.CreateEmailToken(token, username, email, exp); err != nil {
+		return err
+	}
+	if err := s.store.SetPendingEmail(username, email); err != nil {
+		return err
+	}
+	s.audit.Log("email_change_request", username, clientIP, "sent")
+	url := fmt.Sprintf("%s/verify-email?token=%s", s.cfg.MailBaseURL, token)
+	return s.mail.SendEmailVerificationEmail(email, username, s.store.LookupName(username), url, s.mailLang(username, lang))
+}
+
+// VerifyEmail confirms a pending email change from the link sent by SetEmail.
+func (s *AccountService) VerifyEmail(token, clientIP, lang string) error {
+	username, email, expiresAt, used, err := s.store.GetEmailToken(token)
+	if err != nil {
+		return err
+	}
+	if username == "" {
+		s.audit.Log("email_change_confirm", "unknown", clientIP, "invalid_token")
+		return errors.New("invalid token")
+	}
+	if used || time.Now().Unix() > expiresAt {
+		s.audit.Log("email_change_confirm", username, clientIP, "token_expired")
+		return errors.New("token expired")
+	}
+	if other, _ := s.store.FindUserByEmail(email); other != "" && other != username {
+		s.audit.Log("email_change_confirm", username, clientIP, "email_in_use")
+		return errors.New("email_in_use")
+	}
+	old, err := s.store.ConfirmPendingEmail(username, email)
+	if errors.Is(err, store.ErrEmailSuperseded) {
+		s.audit.Log("email_change_confirm", username, clientIP, "superseded")
+		return errors.New("token expired")
+	}
+	if err != nil {
+		return err
+	}
+	_ = s.store.MarkEmailTokenUsed(token)
+	s.audit.Log("email_change_confirm", username, clientIP, "success")
+	s.emailChanged(username, old, email, clientIP, lang)
+	return nil
+}
+
+// emailChanged notifies the old address and emits the email change event.
+func (s *AccountService) emailChanged(username, old, email, clientIP, lang string) {
+	if old != "" && emailRegex.MatchString(old) {
+		if err := s.mail.SendEmailChangedEmail(old, s.store.LookupName(username), email, s.mailLang(username, lang)); err != nil {
+			log.Printf("[mail] failed to queue email changed notification to %s: %v", old, err)
+		}
+	}
+	s.events.Emit(provider.EventEmailChanged, username, clientIP, map[string]string{"OldEmail": old, "NewEmail": email})
+	s.dropUnreachableOTP(username, clientIP)
+}
+
+// Reauthenticate confirms the user's identity before a sensitive change: the
+// current password, or a TOTP code when TOTP is enabled. Users with security
+// codes enabled also need a step-up code (SendStepUpCode).
+func (s *AccountService) Reauthenticate(username, password, totpCode, otp, clientIP string) error {
+	u, ok, err := s.users.Find(username)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("not found")
+	}
+	switch {
+	case password != "":
+		ok = bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password)) == nil
+	case totpCode != "":
+		ok = strings.TrimSpace(u.TotpSecret) != "" && totp.Validate(strings.TrimSpace(totpCode), u.TotpSecret)
+	default:
+		return errors.New("password or code required")
+	}
+	if !ok {
+		s.audit.Log("reauth", username, clientIP, "invalid_credentials")
+		return errors.New("invalid_credentials")
+	}
+	if err := s.requireStepUp(username, otp, clientIP); err != nil {
+		return err
+	}
+	s.audit.Log("reauth", username, clientIP, "success")
+	return nil
+}
+
+func (s *AccountService) ChangePassword(username, oldPassword, newPassword, clientIP, lang string) error {
 	u, ok, err := s.users.Find(username)
 	if err != nil {
 		return err
@@ -189,80 +404,106 @@ func (s *AccountService) ChangePassword(username, oldPassword, newPassword, clie
 	if err != nil {
 		return err
 	}
-	u.Password = hash
-	if err := s.users.Upsert(u); err != nil {
+	hookCtx, err := s.commitPassword(u, newPassword, hash, oldPassword)
+	if err != nil {
+		s.auditHookFailure("password_change", username, clientIP, err)
 		return err
 	}
 	if err := s.docker.RestartTinyauth(); err != nil {
 		log.Printf("[restart] %v", err)
 	}
-	s.syncPasswordTargets(username, newPassword, hash)
-	s.notifyPasswordChanged(username)
+	s.runPasswordHooks(hookCtx)
+	s.notifyPasswordChanged(username, lang)
 	s.audit.Log("password_change", username, clientIP, "success")
+	s.events.Emit(provider.EventPasswordChanged, username, clientIP, map[string]string{"Method": "change"})
 	return nil
 }
 
-// syncPasswordTargets sends password to all configured webhook targets (fire and forget).
-func (s *AccountService) syncPasswordTargets(username, plainPassword, hashedPassword string) {
-	if s.passwordTargets != nil {
-		go func() {
-			errs := s.passwordTargets.SyncPassword(username, plainPassword, hashedPassword)
-			for _, err := range errs {
-				log.Printf("[password-targets] sync error: %v", err)
-			}
-		}()
-	}
-
+// commitPassword runs the required password hooks, then writes the new hash to
+// users.txt. If a required hook fails the change is aborted; if the write fails
+// the required hooks are rolled back. The returned context is passed to
+// runPasswordHooks once the change is committed.
+func (s *AccountService) commitPassword(u UserRecord, newPassword, hash, oldPassword string) (provider.PasswordChangeContext, error) {
 	// Look up role for hook filters
 	role := ""
-	if meta := s.store.GetUserMeta(username); meta != nil {
+	if meta := s.store.GetUserMeta(u.Username); meta != nil {
 		role = meta.Role
 	}
 
-	for _, hook := range s.passwordHooks {
-		go func(h provider.PasswordChangeHook) {
-			if err := h.OnPasswordChanged(provider.PasswordChangeContext{
-				Email:    username,
-				Password: plainPassword,
-				Role:     role,
-			}); err != nil {
-				log.Printf("[password-hook] warning: %v", err)
-			}
-		}(hook)
+	ctx := provider.PasswordChangeContext{
+		Email:             u.Username,
+		Password:          newPassword,
+		HashedPassword:    hash,
+		OldPassword:       oldPassword,
+		OldHashedPassword: u.Password,
+		Role:              role,
+	}
+	if err := s.hooks.RunRequired(ctx); err != nil {
+		return ctx, err
+	}
+
+	u.Password = hash
+	if err := s.users.Upsert(u); err != nil {
+		s.hooks.RollbackRequired(ctx)
+		return ctx, err
 	}
+	return ctx, nil
+}
+
+// auditHookFailure records a password change aborted by a required password hook.
+func (s *AccountService) auditHookFailure(event, identity, clientIP string, err error) {
+	var hookErr *RequiredHookError
+	if errors.As(err, &hookErr) {
+		s.audit.Log(event, identity, clientIP, "required_hook_failed:"+hookErr.Hook)
+	}
+}
+
+// runPasswordHooks queues the new password for all non-required password hooks
+// ([[password_hooks]] and PASSWORD_TARGETS). Delivery happens in the background with retries.
+func (s *AccountService) runPasswordHooks(ctx provider.PasswordChangeContext) {
+	s.hooks.Enqueue(ctx)
 }
 
-// RequestSMSReset sends a reset code via SMS.
-func (s *AccountService) RequestSMSReset(phone, clientIP string) error {
+// RequestSMSReset sends a reset code via SMS. lang is the request's
+// Accept-Language header, used when the user has no language set.
+func (s *AccountService) RequestSMSReset(phone, clientIP, lang string) error {
 	if s.sms == nil {
 		return errors.New("SMS not configured")
 	}
+	e164, err := s.normalizePhone(phone)
+	if err != nil {
+		return nil // don't leak
+	}
+	phone = e164
 	username, err := s.store.FindUserByPhone(phone)
 	if err != nil {
-		return err
+		log.Printf("[sms] reset requested for %s: %v", phone, err)
+		return nil
 	}
 	if username == "" {
 		// Don't leak whether phone exists
 		return nil
 	}
 
-	// Cooldown: max 1 SMS per phone per 5 minutes
-	if s.store.HasRecentSMSCode(username, 5*time.Minute) {
+	// Cooldown: max 1 SMS per user per cooldown period
+	if s.store.HasRecentSMSCode(username, time.Duration(s.cfg.SMSCodeCooldown)*time.Second) {
 		return nil // silent, don't leak info
 	}
 
-	code, err := generateNumericCode(6)
+	code, err := generateNumericCode(s.cfg.SMSCodeLength)
+	if err != nil {
+		return err
+	}
+	msg, err := s.smsCodeText(SMSReset, username, lang, code)
 	if err != nil {
 		return err
 	}
 
 	id := uuid.NewString()
-	expiresAt := time.Now().Add(10 * time.Minute).Unix()
-	if err := s.store.StoreSMSResetCode(id, username, code, expiresAt); err != nil {
+	if err := s.store.StoreSMSResetCode(id, username, code, s.smsCodeExpiry()); err != nil {
 		return err
 	}
 
-	msg := fmt.Sprintf("Your password reset code is: %s (valid for 10 minutes)", code)
 	if err := s.sms.SendSMS(phone, msg); err != nil {
 		log.Printf("[sms] failed to send SMS to %s: %v", phone, err)
 		s.audit.Log("sms_reset_request", phone, clientIP, "send_failed")
@@ -270,14 +511,23 @@ func (s *AccountService) RequestSMSReset(phone, clientIP string) error {
 	}
 
 	s.audit.Log("sms_reset_request", phone, clientIP, "sent")
+	s.events.Emit(provider.EventPasswordResetRequested, username, clientIP, map[string]string{"Channel": "sms"})
 	return nil
 }
 
 // ResetPasswordSMS verifies a code and resets the password.
-func (s *AccountService) ResetPasswordSMS(phone, code, newPassword, clientIP string) error {
-	username, err := s.store.VerifySMSResetCode(phone, code)
+func (s *AccountService) ResetPasswordSMS(phone, code, newPassword, clientIP, lang string) error {
+	if e164, err := s.normalizePhone(phone); err == nil {
+		phone = e164
+	}
+	username, err := s.store.VerifySMSResetCode(phone, code, s.cfg.SMSCodeMaxAttempts)
 	if err != nil {
 		s.audit.Log("sms_reset_confirm", phone, clientIP, "failed:"+err.Error())
+		if errors.Is(err, store.ErrSMSCodeLocked) {
+			if locked, _ := s.store.FindUserByPhone(phone); locked != "" {
+				s.events.Emit(provider.EventUserLockedOut, locked, clientIP, map[string]string{"Reason": "sms_code_attempts"})
+			}
+		}
 		return err
 	}
 
@@ -293,20 +543,52 @@ func (s *AccountService) ResetPasswordSMS(phone, code, newPassword, clientIP str
 	if !ok {
 		return errors.New("user not found")
 	}
-	u.Password = hash
-	if err := s.users.Upsert(u); err != nil {
+	hookCtx, err := s.commitPassword(u, newPassword, hash, "")
+	if err != nil {
+		s.auditHookFailure("sms_reset_confirm", phone, clientIP, err)
 		return err
 	}
 
 	if err := s.docker.RestartTinyauth(); err != nil {
 		log.Printf("[restart] %v", err)
 	}
-	s.syncPasswordTargets(username, newPassword, hash)
-	s.notifyPasswordChanged(username)
+	s.runPasswordHooks(hookCtx)
+	s.notifyPasswordChanged(username, lang)
 	s.audit.Log("sms_reset_confirm", phone, clientIP, "success")
+	s.events.Emit(provider.EventPasswordChanged, username, clientIP, map[string]string{"Method": "sms_reset"})
 	return nil
 }
 
+// NormalizeStoredPhones rewrites phone numbers in users.toml to E.164 and
+// logs numbers that can't be normalized or that more than one user has.
+func (s *AccountService) NormalizeStoredPhones() error {
+	invalid, err := s.store.NormalizePhones(s.normalizePhone)
+	if err != nil {
+		return err
+	}
+	for username, number := range invalid {
+		log.Printf("[phone] %s has an invalid phone number %q; set PHONE_DEFAULT_COUNTRY or update it", username, number)
+	}
+	for number, usernames := range s.store.DuplicatePhones() {
+		log.Printf("[phone] %s share phone number %s; SMS reset is disabled for it", strings.Join(usernames, ", "), number)
+	}
+	return nil
+}
+
+// smsCodeExpiry returns the expiry time of a code sent now.
+func (s *AccountService) smsCodeExpiry() int64 {
+	return time.Now().Add(time.Duration(s.cfg.SMSCodeTTLSeconds) * time.Second).Unix()
+}
+
+// smsCodeText renders SMS template name for code in username's language.
+func (s *AccountService) smsCodeText(name, username, lang, code string) (string, error) {
+	return s.smsTpl.render(name, s.mailLang(username, lang), smsData{
+		Code:    code,
+		Minutes: (s.cfg.SMSCodeTTLSeconds + 59) / 60,
+		Title:   s.cfg.Title,
+	})
+}
+
 // SMSEnabled returns true if SMS provider is configured.
 func (s *AccountService) SMSEnabled() bool {
 	return s.sms != nil
@@ -333,7 +615,7 @@ type bytesBuffer struct{ b []byte }
 func (w *bytesBuffer) Write(p []byte) (int, error) { w.b = append(w.b, p...); return len(p), nil }
 func (w *bytesBuffer) Bytes() []byte               { return w.b }
 
-func (s *AccountService) TotpEnable(username, secret, code string) error {
+func (s *AccountService) TotpEnable(username, secret, code, clientIP, lang string) error {
 	if !totp.Validate(code, secret) {
 		return errors.New("invalid code")
 	}
@@ -351,10 +633,13 @@ func (s *AccountService) TotpEnable(username, secret, code string) error {
 	if err := s.docker.RestartTinyauth(); err != nil {
 		log.Printf("[restart] %v", err)
 	}
+	s.notifyTOTPChanged(username, true, lang)
+	s.events.Emit(provider.EventTOTPEnabled, username, clientIP, nil)
 	return nil
 }
 
-func (s *AccountService) TotpDisable(username, password string) error {
+// TotpDisable removes the TOTP secret after checking the password.
+func (s *AccountService) TotpDisable(username, password, clientIP, lang string) error {
 	u, ok, err := s.users.Find(username)
 	if err != nil {
 		return err
@@ -372,18 +657,40 @@ func (s *AccountService) TotpDisable(username, password string) error {
 	if err := s.docker.RestartTinyauth(); err != nil {
 		log.Printf("[restart] %v", err)
 	}
+	s.notifyTOTPChanged(username, false, lang)
+	s.events.Emit(provider.EventTOTPDisabled, username, clientIP, nil)
 	return nil
 }
 
-func (s *AccountService) TotpRecover(username, recoveryKey, newSecret, code string) error {
+func (s *AccountService) TotpRecover(username, recoveryKey, newSecret, code, clientIP, lang string) error {
 	if recoveryKey != fmt.Sprintf("RECOVERY-%s", username) {
 		return errors.New("invalid recovery key")
 	}
-	return s.TotpEnable(username, newSecret, code)
+	return s.TotpEnable(username, newSecret, code, clientIP, lang)
 }
 
-// notifyPasswordChanged sends an email notification about the password change.
-func (s *AccountService) notifyPasswordChanged(username string) {
+// SetLanguage sets the user's preferred mail language. An empty language
+// clears it, so the browser's Accept-Language is used again.
+func (s *AccountService) SetLanguage(username, language string) error {
+	language = strings.ToLower(strings.TrimSpace(language))
+	if language != "" && !slices.Contains(s.mail.Locales(), language) {
+		return errors.New("unsupported language")
+	}
+	return s.store.SetLanguage(username, language)
+}
+
+// mailLang returns the language for mail to username: the stored preference,
+// or else the request's Accept-Language header.
+func (s *AccountService) mailLang(username, acceptLanguage string) string {
+	if meta := s.store.GetUserMeta(username); meta != nil && meta.Language != "" {
+		return meta.Language
+	}
+	return acceptLanguage
+}
+
+// notifyAddress returns the address for notifications to username, or "" if
+// the user has no valid email address.
+func (s *AccountService) notifyAddress(username string) string {
 	toEmail := username
 	if !s.cfg.UsernameIsEmail {
 		email, _ := s.store.GetEmail(username)
@@ -392,13 +699,31 @@ func (s *AccountService) notifyPasswordChanged(username string) {
 		}
 	}
 	if toEmail == "" || !emailRegex.MatchString(toEmail) {
+		return ""
+	}
+	return toEmail
+}
+
+// notifyPasswordChanged sends an email notification about the password change.
+func (s *AccountService) notifyPasswordChanged(username, lang string) {
+	toEmail := s.notifyAddress(username)
+	if toEmail == "" {
 		return
 	}
-	go func() {
-		if err := s.mail.SendPasswordChangedEmail(toEmail); err != nil {
-			log.Printf("[mail] failed to send password changed notification to %s: %v", toEmail, err)
-		}
-	}()
+	if err := s.mail.SendPasswordChangedEmail(toEmail, s.store.LookupName(username), s.mailLang(username, lang)); err != nil {
+		log.Printf("[mail] failed to queue password changed notification to %s: %v", toEmail, err)
+	}
+}
+
+// notifyTOTPChanged sends an email notification about TOTP being enabled or disabled.
+func (s *AccountService) notifyTOTPChanged(username string, enabled bool, lang string) {
+	toEmail := s.notifyAddress(username)
+	if toEmail == "" {
+		return
+	}
+	if err := s.mail.SendTOTPChangedEmail(toEmail, s.store.LookupName(username), enabled, s.mailLang(username, lang)); err != nil {
+		log.Printf("[mail] failed to queue TOTP changed notification to %s: %v", toEmail, err)
+	}
 }
 
 // generateNumericCode generates a cryptographically random numeric code of the given length.
@@ -4,11 +4,11 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"net/http"
 	"strings"
 	"time"
 
 	"tinyauth-sidecar/internal/config"
+	"tinyauth-sidecar/internal/httpclient"
 
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/client"
@@ -120,7 +120,10 @@ func (s *DockerService) waitForHealthy(timeout time.Duration) error {
 
 // pollHealthEndpoint polls a URL until it responds with 2xx or the timeout expires.
 func (s *DockerService) pollHealthEndpoint(url string, timeout time.Duration) error {
-	httpClient := &http.Client{Timeout: 2 * time.Second}
+	httpClient, err := httpclient.New(2*time.Second, s.cfg.TinyauthTLSOptions())
+	if err != nil {
+		return fmt.Errorf("tinyauth TLS settings: %w", err)
+	}
 
 	deadline := time.Now().Add(timeout)
 	for time.Now().Before(deadline) {
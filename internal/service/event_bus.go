@@ -0,0 +1,120 @@
+package service
+
+import (
+	"log"
+	"time"
+
+	"tinyauth-sidecar/internal/provider"
+	"tinyauth-sidecar/internal/store"
+)
+
+const (
+	defaultEventMaxAttempts = 3
+	eventQueueSize          = 256
+)
+
+// eventDelivery is one event queued for one hook.
+type eventDelivery struct {
+	hook    provider.EventHook
+	event   provider.Event
+	attempt int
+}
+
+// EventBus publishes user lifecycle events to the [[event_hooks]] subscribed to
+// them. Delivery is asynchronous and in-memory; failed deliveries are retried
+// with exponential backoff and dropped (with an audit entry) after max_attempts.
+type EventBus struct {
+	hooks []provider.EventHook
+	store *store.Store
+	audit *AuditService
+	queue chan eventDelivery
+}
+
+// NewEventBus creates an event bus for the given hooks. The store is used to
+// look up the user's role for filters and templates.
+func NewEventBus(st *store.Store, audit *AuditService, hooks ...provider.EventHook) *EventBus {
+	return &EventBus{
+		hooks: hooks,
+		store: st,
+		audit: audit,
+		queue: make(chan eventDelivery, eventQueueSize),
+	}
+}
+
+// Start runs the delivery worker in the background.
+func (b *EventBus) Start() {
+	if b == nil || len(b.hooks) == 0 {
+		return
+	}
+	go func() {
+		for d := range b.queue {
+			b.deliver(d)
+		}
+	}()
+}
+
+// Emit publishes an event for username. data holds event-specific template variables.
+func (b *EventBus) Emit(eventType, username, ip string, data map[string]string) {
+	if b == nil {
+		return
+	}
+	role := ""
+	if meta := b.store.GetUserMeta(username); meta != nil {
+		role = meta.Role
+	}
+	b.emitEvent(provider.Event{Type: eventType, Username: username, Role: role, IP: ip, Data: data})
+}
+
+// emitEvent publishes ev as given, for callers that know the role better than
+// the store (e.g. a user whose metadata was removed along with the user).
+func (b *EventBus) emitEvent(ev provider.Event) {
+	if b == nil || len(b.hooks) == 0 {
+		return
+	}
+
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	if ev.IP == "" {
+		ev.IP = "-"
+	}
+
+	for _, h := range b.hooks {
+		if !h.Subscribed(ev.Type) || !provider.MatchesEventFilters(h.Config(), ev) {
+			continue
+		}
+		b.enqueue(eventDelivery{hook: h, event: ev, attempt: 1})
+	}
+}
+
+func (b *EventBus) enqueue(d eventDelivery) {
+	select {
+	case b.queue <- d:
+	default:
+		log.Printf("[event-hook] %s: queue full, dropping %s for %s", d.hook.Config().Name, d.event.Type, d.event.Username)
+		b.audit.Log("event_hook_delivery", d.event.Username, "-", "dropped:"+d.hook.Config().Name+":"+d.event.Type)
+	}
+}
+
+func (b *EventBus) deliver(d eventDelivery) {
+	cfg := d.hook.Config()
+	err := d.hook.OnEvent(d.event)
+	if err == nil {
+		return
+	}
+
+	maxAttempts := cfg.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultEventMaxAttempts
+	}
+	if d.attempt >= maxAttempts {
+		log.Printf("[event-hook] %s: giving up on %s for %s after %d attempt(s): %v", cfg.Name, d.event.Type, d.event.Username, d.attempt, err)
+		b.audit.Log("event_hook_delivery", d.event.Username, "-", "failed:"+cfg.Name+":"+d.event.Type)
+		return
+	}
+
+	delay := retryDelay(cfg.RetryBackoff, d.attempt)
+	log.Printf("[event-hook] %s: attempt %d/%d for %s failed, retrying in %s: %v", cfg.Name, d.attempt, maxAttempts, d.event.Type, delay, err)
+	d.attempt++
+	time.AfterFunc(delay, func() { b.enqueue(d) })
+}
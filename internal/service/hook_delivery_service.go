@@ -0,0 +1,481 @@
+package service
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"tinyauth-sidecar/internal/config"
+	"tinyauth-sidecar/internal/provider"
+	"tinyauth-sidecar/internal/store"
+	"tinyauth-sidecar/pkg/webhooksig"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultHookMaxAttempts  = 5
+	defaultHookRetryBackoff = 30 * time.Second
+	maxHookRetryBackoff     = time.Hour
+)
+
+// HookDelivery is the admin view of an outbox entry (without secrets).
+type HookDelivery struct {
+	ID          string `json:"id"`
+	Hook        string `json:"hook"`
+	Username    string `json:"username"`
+	Attempts    int    `json:"attempts"`
+	NextAttempt int64  `json:"nextAttempt"`
+	LastError   string `json:"lastError,omitempty"`
+	Failed      bool   `json:"failed"`
+	CreatedAt   int64  `json:"createdAt"`
+}
+
+// hookPayload is the secret part of a delivery, encrypted at rest.
+type hookPayload struct {
+	Password       string `json:"password"`
+	HashedPassword string `json:"hashed_password"`
+}
+
+// HookDeliveryService delivers password changes to password hooks through a
+// persistent outbox, retrying failed deliveries with exponential backoff.
+// Deliveries that exhaust their attempts stay in the outbox as failed until
+// an admin retries or discards them.
+type HookDeliveryService struct {
+	hooks  map[string]provider.PasswordChangeHook // key = hook name
+	order  []string                               // hook names in config order
+	outbox *store.Outbox
+	aead   cipher.AEAD
+	audit  *AuditService
+
+	wake chan struct{}
+}
+
+// NewHookDeliveryService opens the outbox at cfg.HookOutboxPath. The encryption
+// key comes from OUTBOX_KEY (base64, 32 bytes) or is generated once and stored
+// next to the outbox as outbox.key.
+func NewHookDeliveryService(cfg *config.Config, audit *AuditService, hooks ...provider.PasswordChangeHook) (*HookDeliveryService, error) {
+	outbox, err := store.NewOutbox(cfg.HookOutboxPath)
+	if err != nil {
+		return nil, err
+	}
+
+	keyPath := filepath.Join(filepath.Dir(cfg.HookOutboxPath), "outbox.key")
+	key, created, err := loadKey("OUTBOX_KEY", cfg.OutboxKey, keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("outbox key: %w", err)
+	}
+	if created {
+		log.Printf("[hook-delivery] generated outbox encryption key at %s", keyPath)
+	}
+	if cfg.OutboxKey == "" {
+		log.Printf("[hook-delivery] WARNING: OUTBOX_KEY is not set; the key in %s sits next to the passwords it encrypts. Supply OUTBOX_KEY from a separate secret.", keyPath)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("outbox cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("outbox cipher: %w", err)
+	}
+
+	d := &HookDeliveryService{
+		hooks:  make(map[string]provider.PasswordChangeHook),
+		outbox: outbox,
+		aead:   aead,
+		audit:  audit,
+		wake:   make(chan struct{}, 1),
+	}
+	for _, h := range hooks {
+		if h == nil {
+			continue
+		}
+		name := h.Config().Name
+		if _, dup := d.hooks[name]; dup {
+			log.Printf("[hook-delivery] duplicate hook name %q; later hook ignored", name)
+			continue
+		}
+		d.hooks[name] = h
+		d.order = append(d.order, name)
+	}
+	return d, nil
+}
+
+// Start runs the delivery worker in the background.
+func (d *HookDeliveryService) Start() {
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			d.processDue()
+			select {
+			case <-ticker.C:
+			case <-d.wake:
+			}
+		}
+	}()
+}
+
+func (d *HookDeliveryService) kick() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// RequiredHookError reports that a required password hook rejected a change.
+type RequiredHookError struct {
+	Hook string
+	Err  error
+}
+
+func (e *RequiredHookError) Error() string {
+	return fmt.Sprintf("password change aborted: required password sync %q failed", e.Hook)
+}
+
+func (e *RequiredHookError) Unwrap() error { return e.Err }
+
+// RunRequired synchronously calls all required hooks whose filters match, in
+// config order. If one fails, the hooks that already succeeded are rolled back
+// and a *RequiredHookError is returned; the caller must not commit the change.
+func (d *HookDeliveryService) RunRequired(ctx provider.PasswordChangeContext) error {
+	if d == nil {
+		return nil
+	}
+
+	var done []string
+	for _, name := range d.order {
+		h := d.hooks[name]
+		if !h.Config().Required || !provider.MatchesHookFilters(h.Config(), ctx) {
+			continue
+		}
+		if err := h.OnPasswordChanged(ctx); err != nil {
+			log.Printf("[hook-delivery] %s: required hook failed for %s: %v", name, ctx.Email, err)
+			d.audit.Log("password_hook_required", ctx.Email, "-", "failed:"+name)
+			d.rollback(ctx, done)
+			return &RequiredHookError{Hook: name, Err: err}
+		}
+		done = append(done, name)
+	}
+	return nil
+}
+
+// RollbackRequired compensates all required hooks that matched ctx, e.g. when
+// writing users.txt failed after RunRequired succeeded.
+func (d *HookDeliveryService) RollbackRequired(ctx provider.PasswordChangeContext) {
+	if d == nil {
+		return
+	}
+	var names []string
+	for _, name := range d.order {
+		h := d.hooks[name]
+		if h.Config().Required && provider.MatchesHookFilters(h.Config(), ctx) {
+			names = append(names, name)
+		}
+	}
+	d.rollback(ctx, names)
+}
+
+// rollback undoes the named hooks in reverse order.
+func (d *HookDeliveryService) rollback(ctx provider.PasswordChangeContext, names []string) {
+	for i := len(names) - 1; i >= 0; i-- {
+		name := names[i]
+		rh, ok := d.hooks[name].(provider.RollbackHook)
+		if !ok {
+			log.Printf("[hook-delivery] %s: cannot roll back for %s: hook type has no rollback", name, ctx.Email)
+			d.audit.Log("password_hook_rollback", ctx.Email, "-", "unsupported:"+name)
+			continue
+		}
+		if err := rh.RollbackPasswordChange(ctx); err != nil {
+			log.Printf("[hook-delivery] %s: rollback failed for %s: %v", name, ctx.Email, err)
+			d.audit.Log("password_hook_rollback", ctx.Email, "-", "failed:"+name)
+			continue
+		}
+		d.audit.Log("password_hook_rollback", ctx.Email, "-", "success:"+name)
+	}
+}
+
+// Enqueue stores a delivery for every non-required hook whose filters match the change.
+// Required hooks are handled synchronously by RunRequired.
+func (d *HookDeliveryService) Enqueue(ctx provider.PasswordChangeContext) {
+	if d == nil || len(d.hooks) == 0 {
+		return
+	}
+
+	payload, err := d.seal(hookPayload{Password: ctx.Password, HashedPassword: ctx.HashedPassword})
+	if err != nil {
+		log.Printf("[hook-delivery] failed to encrypt payload for %s: %v", ctx.Email, err)
+		return
+	}
+
+	now := time.Now().Unix()
+	for _, name := range d.order {
+		h := d.hooks[name]
+		if h.Config().Required || !provider.MatchesHookFilters(h.Config(), ctx) {
+			continue
+		}
+		entry := store.OutboxEntry{
+			ID:          uuid.NewString(),
+			Hook:        name,
+			Username:    ctx.Email,
+			Role:        ctx.Role,
+			Payload:     payload,
+			MessageID:   webhooksig.NewID(),
+			NextAttempt: now,
+			CreatedAt:   now,
+		}
+		if err := d.outbox.Add(entry); err != nil {
+			log.Printf("[hook-delivery] %s: failed to queue delivery for %s: %v", name, ctx.Email, err)
+		}
+	}
+	d.kick()
+}
+
+// PruneUsers removes users that are no longer in existing (users.txt) from
+// hooks that keep their own user list (file sync hooks).
+func (d *HookDeliveryService) PruneUsers(existing []string) {
+	if d == nil {
+		return
+	}
+	for _, name := range d.order {
+		p, ok := d.hooks[name].(provider.UserPruner)
+		if !ok {
+			continue
+		}
+		if err := p.PruneUsers(existing); err != nil {
+			log.Printf("[hook-delivery] prune failed: %v", err)
+		}
+	}
+}
+
+// processDue attempts all due deliveries concurrently and waits for them.
+func (d *HookDeliveryService) processDue() {
+	var wg sync.WaitGroup
+	for _, entry := range d.outbox.Due(time.Now().Unix()) {
+		wg.Add(1)
+		go func(e store.OutboxEntry) {
+			defer wg.Done()
+			d.attempt(e)
+		}(entry)
+	}
+	wg.Wait()
+}
+
+// attempt delivers one outbox entry and records the outcome.
+func (d *HookDeliveryService) attempt(e store.OutboxEntry) {
+	h, ok := d.hooks[e.Hook]
+	if !ok {
+		d.fail(e, "hook is no longer configured")
+		return
+	}
+
+	var payload hookPayload
+	if err := d.open(e.Payload, &payload); err != nil {
+		d.fail(e, "cannot decrypt payload: "+err.Error())
+		return
+	}
+
+	if e.MessageID == "" {
+		// Queued before message IDs were stored; fixed from now on.
+		e.MessageID = webhooksig.NewID()
+	}
+	e.Attempts++
+	err := h.OnPasswordChanged(provider.PasswordChangeContext{
+		Email:          e.Username,
+		Password:       payload.Password,
+		HashedPassword: payload.HashedPassword,
+		Role:           e.Role,
+		MessageID:      e.MessageID,
+	})
+	if err == nil {
+		if rmErr := d.outbox.Remove(e.ID); rmErr != nil {
+			log.Printf("[hook-delivery] %s: failed to remove delivered entry: %v", e.Hook, rmErr)
+		}
+		if e.Attempts > 1 {
+			log.Printf("[hook-delivery] %s: delivered for %s after %d attempt(s)", e.Hook, e.Username, e.Attempts)
+		}
+		d.audit.Log("password_hook_delivery", e.Username, "-", "delivered:"+e.Hook)
+		return
+	}
+
+	cfg := h.Config()
+	maxAttempts := cfg.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultHookMaxAttempts
+	}
+	if e.Attempts >= maxAttempts {
+		d.fail(e, err.Error())
+		return
+	}
+
+	delay := retryDelay(cfg.RetryBackoff, e.Attempts)
+	e.LastError = err.Error()
+	e.NextAttempt = time.Now().Add(delay).Unix()
+	log.Printf("[hook-delivery] %s: attempt %d/%d for %s failed, retrying in %s: %v", e.Hook, e.Attempts, maxAttempts, e.Username, delay, err)
+	if err := d.outbox.Update(e); err != nil {
+		log.Printf("[hook-delivery] %s: failed to update outbox: %v", e.Hook, err)
+	}
+}
+
+// fail moves a delivery to the dead-letter state.
+func (d *HookDeliveryService) fail(e store.OutboxEntry, reason string) {
+	e.Failed = true
+	e.LastError = reason
+	log.Printf("[hook-delivery] %s: giving up on %s after %d attempt(s): %s", e.Hook, e.Username, e.Attempts, reason)
+	if err := d.outbox.Update(e); err != nil {
+		log.Printf("[hook-delivery] %s: failed to update outbox: %v", e.Hook, err)
+	}
+	d.audit.Log("password_hook_delivery", e.Username, "-", "failed:"+e.Hook)
+}
+
+// retryDelay returns backoff * 2^(attempts-1), capped at one hour.
+func retryDelay(backoffSeconds, attempts int) time.Duration {
+	delay := defaultHookRetryBackoff
+	if backoffSeconds > 0 {
+		delay = time.Duration(backoffSeconds) * time.Second
+	}
+	for i := 1; i < attempts && delay < maxHookRetryBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxHookRetryBackoff {
+		delay = maxHookRetryBackoff
+	}
+	return delay
+}
+
+// Deliveries lists pending and failed deliveries.
+func (d *HookDeliveryService) Deliveries() []HookDelivery {
+	entries := d.outbox.List()
+	list := make([]HookDelivery, 0, len(entries))
+	for _, e := range entries {
+		list = append(list, HookDelivery{
+			ID:          e.ID,
+			Hook:        e.Hook,
+			Username:    e.Username,
+			Attempts:    e.Attempts,
+			NextAttempt: e.NextAttempt,
+			LastError:   e.LastError,
+			Failed:      e.Failed,
+			CreatedAt:   e.CreatedAt,
+		})
+	}
+	return list
+}
+
+// Counts returns the number of pending and failed deliveries.
+func (d *HookDeliveryService) Counts() (pending, failed int) {
+	for _, e := range d.outbox.List() {
+		if e.Failed {
+			failed++
+		} else {
+			pending++
+		}
+	}
+	return pending, failed
+}
+
+// ErrHookNotFound is returned when no configured hook matches a name or index.
+var ErrHookNotFound = errors.New("password hook not found")
+
+// Hook returns the enabled password hook with the given name, or at the given
+// zero-based index among enabled hooks.
+func (d *HookDeliveryService) Hook(selector string) (provider.PasswordChangeHook, error) {
+	if h, ok := d.hooks[selector]; ok {
+		return h, nil
+	}
+	if i, err := strconv.Atoi(selector); err == nil && i >= 0 && i < len(d.order) {
+		return d.hooks[d.order[i]], nil
+	}
+	return nil, ErrHookNotFound
+}
+
+// DryRun renders (and with send, performs) the request of a webhook password
+// hook for a sample change, without touching users.txt or the outbox.
+func (d *HookDeliveryService) DryRun(selector string, ctx provider.PasswordChangeContext, send bool, actor string) (provider.HookDryRun, error) {
+	h, err := d.Hook(selector)
+	if err != nil {
+		return provider.HookDryRun{}, err
+	}
+	res, err := provider.DryRunPasswordHook(h, ctx, send)
+	if err != nil {
+		return res, err
+	}
+	if send {
+		result := "sent:" + res.Hook
+		if res.Error != "" {
+			result = "error:" + res.Hook
+		}
+		d.audit.Log("password_hook_test", actor, "-", result)
+	}
+	return res, nil
+}
+
+// Retry schedules a delivery for immediate redelivery with a fresh attempt budget.
+func (d *HookDeliveryService) Retry(id, actor string) error {
+	e, ok := d.outbox.Get(id)
+	if !ok {
+		return errors.New("delivery not found")
+	}
+	e.Failed = false
+	e.Attempts = 0
+	e.NextAttempt = time.Now().Unix()
+	if err := d.outbox.Update(e); err != nil {
+		return err
+	}
+	log.Printf("[hook-delivery] %s: manual retry for %s requested by %s", e.Hook, e.Username, actor)
+	d.kick()
+	return nil
+}
+
+// Discard removes a delivery without delivering it.
+func (d *HookDeliveryService) Discard(id, actor string) error {
+	e, ok := d.outbox.Get(id)
+	if !ok {
+		return errors.New("delivery not found")
+	}
+	if err := d.outbox.Remove(id); err != nil {
+		return err
+	}
+	log.Printf("[hook-delivery] %s: delivery for %s discarded by %s", e.Hook, e.Username, actor)
+	d.audit.Log("password_hook_delivery", e.Username, "-", "discarded:"+e.Hook+" by "+actor)
+	return nil
+}
+
+func (d *HookDeliveryService) seal(p hookPayload) (string, error) {
+	plain, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, d.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := d.aead.Seal(nonce, nonce, plain, nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (d *HookDeliveryService) open(s string, p *hookPayload) error {
+	raw, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	n := d.aead.NonceSize()
+	if len(raw) < n {
+		return errors.New("payload too short")
+	}
+	plain, err := d.aead.Open(nil, raw[:n], raw[n:], nil)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(plain, p)
+}
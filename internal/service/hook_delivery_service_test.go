@@ -0,0 +1,76 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"tinyauth-sidecar/internal/config"
+	"tinyauth-sidecar/internal/provider"
+	"tinyauth-sidecar/pkg/webhooksig"
+)
+
+func TestHookDeliveryRetriesKeepWebhookID(t *testing.T) {
+	const secret = "whsec_MfKQ9r8GKYqrTwjUPD8ILPZIo2LaLaSw"
+	verifier, err := webhooksig.NewVerifier(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		mu  sync.Mutex
+		ids []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := verifier.VerifyRequest(r); err != nil {
+			t.Errorf("verify: %v", err)
+		}
+		mu.Lock()
+		ids = append(ids, r.Header.Get(webhooksig.HeaderID))
+		first := len(ids) == 1
+		mu.Unlock()
+		if first {
+			// Handling failed: the ID is not committed, so the retry is accepted.
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		verifier.Commit(r.Header)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	hook := provider.NewPasswordHook(config.WebhookConfig{
+		Name:          "app",
+		Enabled:       true,
+		URL:           srv.URL,
+		Method:        http.MethodPost,
+		ContentType:   "application/json",
+		Body:          `{"user":"{{.Username}}"}`,
+		Timeout:       5,
+		SigningSecret: secret,
+	})
+	d, err := NewHookDeliveryService(&config.Config{HookOutboxPath: filepath.Join(dir, "outbox.json")}, NewAuditService(filepath.Join(dir, "audit.log")), hook)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d.Enqueue(provider.PasswordChangeContext{Email: "alice@example.com", Password: "n3w-Secret"})
+	d.processDue()
+	entries := d.outbox.List()
+	if len(entries) != 1 || entries[0].Attempts != 1 || entries[0].MessageID == "" {
+		t.Fatalf("expected one pending delivery with a message ID, got %+v", entries)
+	}
+	d.attempt(entries[0])
+	if n := len(d.outbox.List()); n != 0 {
+		t.Fatalf("expected the retry to be delivered, %d entries left", n)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(ids) != 2 || ids[0] != entries[0].MessageID || ids[1] != ids[0] {
+		t.Fatalf("webhook-id changed between attempts: %v (stored %q)", ids, entries[0].MessageID)
+	}
+}
@@ -0,0 +1,62 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"tinyauth-sidecar/internal/config"
+)
+
+// loadKey decodes the 32-byte key configured in env, or reads the key file
+// at keyPath, creating it with a random key if it doesn't exist. created
+// reports whether the file was written.
+func loadKey(env, configured, keyPath string) (key []byte, created bool, err error) {
+	if configured != "" {
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(configured))
+		if err != nil || len(key) != 32 {
+			return nil, false, fmt.Errorf("%s must be 32 bytes, base64-encoded", env)
+		}
+		return key, false, nil
+	}
+
+	if data, err := os.ReadFile(keyPath); err == nil {
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(key) != 32 {
+			return nil, false, fmt.Errorf("invalid key in %s", keyPath)
+		}
+		return key, false, nil
+	} else if !os.IsNotExist(err) {
+		return nil, false, fmt.Errorf("read key: %w", err)
+	}
+
+	key = make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, false, err
+	}
+	if err := os.WriteFile(keyPath, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0o600); err != nil {
+		return nil, false, fmt.Errorf("write key: %w", err)
+	}
+	return key, true, nil
+}
+
+// LoadStepUpKey returns the key that signs step-up cookies: STEP_UP_KEY, or
+// step-up.key next to the users file, generated on first start.
+func LoadStepUpKey(cfg *config.Config) ([]byte, error) {
+	keyPath := filepath.Join(filepath.Dir(cfg.UsersFilePath), "step-up.key")
+	key, created, err := loadKey("STEP_UP_KEY", cfg.StepUpKey, keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("step-up key: %w", err)
+	}
+	if created {
+		log.Printf("[step-up] generated cookie signing key at %s", keyPath)
+	}
+	if cfg.StepUpKey == "" {
+		log.Printf("[step-up] WARNING: STEP_UP_KEY is not set; the key in %s sits next to the users file. Supply STEP_UP_KEY from a separate secret.", keyPath)
+	}
+	return key, nil
+}
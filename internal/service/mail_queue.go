@@ -0,0 +1,172 @@
+package service
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"tinyauth-sidecar/internal/store"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultMailWorkers     = 2
+	defaultMailMaxAttempts = 8
+	defaultMailMaxAge      = 24 * time.Hour
+	// failedMailRetention is how long given-up messages stay in the spool for the admin status.
+	failedMailRetention = 7 * 24 * time.Hour
+)
+
+// mailQueue delivers spooled mail in the background. Failed sends are retried
+// with exponential backoff until they succeed, run out of attempts or pass
+// their expiry.
+type mailQueue struct {
+	spool       *store.MailSpool
+	send        func(store.MailSpoolEntry) error
+	audit       *AuditService
+	workers     int
+	maxAttempts int
+	backoff     int // seconds; 0 = default
+	maxAge      time.Duration
+
+	mu       sync.Mutex
+	inflight map[string]bool
+	wake     chan struct{}
+}
+
+func (q *mailQueue) enqueue(kind, to, subject, text, html string, maxAge time.Duration) error {
+	if maxAge <= 0 || maxAge > q.maxAge {
+		maxAge = q.maxAge
+	}
+	now := time.Now()
+	entry := store.MailSpoolEntry{
+		ID:          uuid.NewString(),
+		Kind:        kind,
+		To:          to,
+		Subject:     subject,
+		Text:        text,
+		HTML:        html,
+		NextAttempt: now.Unix(),
+		ExpiresAt:   now.Add(maxAge).Unix(),
+		CreatedAt:   now.Unix(),
+	}
+	if err := q.spool.Add(entry); err != nil {
+		return err
+	}
+	q.kick()
+	return nil
+}
+
+// start runs the dispatcher and the worker goroutines.
+func (q *mailQueue) start() {
+	jobs := make(chan store.MailSpoolEntry)
+	for i := 0; i < q.workers; i++ {
+		go func() {
+			for e := range jobs {
+				q.attempt(e)
+				q.mu.Lock()
+				delete(q.inflight, e.ID)
+				q.mu.Unlock()
+			}
+		}()
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			now := time.Now()
+			q.prune(now)
+			for _, e := range q.spool.Due(now.Unix()) {
+				if q.claim(e.ID) {
+					jobs <- e
+				}
+			}
+			select {
+			case <-ticker.C:
+			case <-q.wake:
+			}
+		}
+	}()
+}
+
+// prune drops failed messages that have been kept for failedMailRetention.
+func (q *mailQueue) prune(now time.Time) {
+	if err := q.spool.PruneFailed(now.Add(-failedMailRetention).Unix()); err != nil {
+		log.Printf("[mail-queue] failed to prune spool: %v", err)
+	}
+}
+
+func (q *mailQueue) kick() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// claim marks a message as in flight; it returns false if a worker already has it.
+func (q *mailQueue) claim(id string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.inflight[id] {
+		return false
+	}
+	q.inflight[id] = true
+	return true
+}
+
+func (q *mailQueue) attempt(e store.MailSpoolEntry) {
+	if time.Now().Unix() >= e.ExpiresAt {
+		q.fail(e, "expired before delivery")
+		return
+	}
+
+	e.Attempts++
+	err := q.send(e)
+	if err == nil {
+		if rmErr := q.spool.Remove(e.ID); rmErr != nil {
+			log.Printf("[mail-queue] failed to remove sent %s mail: %v", e.Kind, rmErr)
+		}
+		if e.Attempts > 1 {
+			log.Printf("[mail-queue] %s mail to %s sent after %d attempt(s)", e.Kind, e.To, e.Attempts)
+		}
+		return
+	}
+
+	if e.Attempts >= q.maxAttempts {
+		q.fail(e, err.Error())
+		return
+	}
+	delay := retryDelay(q.backoff, e.Attempts)
+	e.LastError = err.Error()
+	e.NextAttempt = time.Now().Add(delay).Unix()
+	log.Printf("[mail-queue] attempt %d/%d for %s mail to %s failed, retrying in %s: %v", e.Attempts, q.maxAttempts, e.Kind, e.To, delay, err)
+	if err := q.spool.Update(e); err != nil {
+		log.Printf("[mail-queue] failed to update spool: %v", err)
+	}
+}
+
+// fail gives up on a message; it stays in the spool (without its content) as failed.
+func (q *mailQueue) fail(e store.MailSpoolEntry, reason string) {
+	e.Failed = true
+	e.LastError = reason
+	e.Subject, e.Text, e.HTML = "", "", ""
+	log.Printf("[mail-queue] giving up on %s mail to %s after %d attempt(s): %s", e.Kind, e.To, e.Attempts, reason)
+	if err := q.spool.Update(e); err != nil {
+		log.Printf("[mail-queue] failed to update spool: %v", err)
+	}
+	q.audit.Log("mail_delivery", e.To, "-", "failed:"+e.Kind)
+}
+
+// counts returns the number of queued and failed messages.
+func (q *mailQueue) counts() (pending, failed int) {
+	for _, e := range q.spool.List() {
+		if e.Failed {
+			failed++
+		} else {
+			pending++
+		}
+	}
+	return pending, failed
+}
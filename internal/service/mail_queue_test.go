@@ -0,0 +1,160 @@
+package service
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"tinyauth-sidecar/internal/store"
+)
+
+// newTestMailQueue returns a queue on a temp spool whose sends fail with sendErr.
+func newTestMailQueue(t *testing.T, sendErr error) (*mailQueue, *int) {
+	t.Helper()
+	dir := t.TempDir()
+	spool, err := store.NewMailSpool(filepath.Join(dir, "mail-spool.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sends := new(int)
+	q := &mailQueue{
+		spool:       spool,
+		send:        func(store.MailSpoolEntry) error { *sends++; return sendErr },
+		audit:       NewAuditService(filepath.Join(dir, "audit.log")),
+		workers:     1,
+		maxAttempts: 3,
+		backoff:     10,
+		maxAge:      time.Hour,
+		inflight:    make(map[string]bool),
+		wake:        make(chan struct{}, 1),
+	}
+	return q, sends
+}
+
+// spooled returns the only message in the spool.
+func spooled(t *testing.T, q *mailQueue) store.MailSpoolEntry {
+	t.Helper()
+	list := q.spool.List()
+	if len(list) != 1 {
+		t.Fatalf("expected one spooled message, got %d", len(list))
+	}
+	return list[0]
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		backoff, attempts int
+		want              time.Duration
+	}{
+		{backoff: 0, attempts: 1, want: 30 * time.Second},
+		{backoff: 0, attempts: 3, want: 2 * time.Minute},
+		{backoff: 10, attempts: 1, want: 10 * time.Second},
+		{backoff: 10, attempts: 4, want: 80 * time.Second},
+		{backoff: 10, attempts: 30, want: time.Hour},
+	}
+	for _, tt := range tests {
+		if got := retryDelay(tt.backoff, tt.attempts); got != tt.want {
+			t.Errorf("retryDelay(%d, %d) = %s, want %s", tt.backoff, tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestMailQueueRetriesWithBackoffThenFails(t *testing.T) {
+	q, sends := newTestMailQueue(t, errors.New("connection refused"))
+	if err := q.enqueue("reset", "alice@example.com", "Reset", "text", "<p>html</p>", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	for attempt := 1; attempt < q.maxAttempts; attempt++ {
+		before := time.Now()
+		q.attempt(spooled(t, q))
+		e := spooled(t, q)
+		if e.Failed || e.Attempts != attempt || e.LastError != "connection refused" {
+			t.Fatalf("after attempt %d: %+v", attempt, e)
+		}
+		want := before.Add(retryDelay(q.backoff, attempt)).Unix()
+		if e.NextAttempt < want || e.NextAttempt > want+1 {
+			t.Errorf("attempt %d: next attempt in %ds, want %s", attempt, e.NextAttempt-before.Unix(), retryDelay(q.backoff, attempt))
+		}
+		if len(q.spool.Due(time.Now().Unix())) != 0 {
+			t.Errorf("attempt %d: message is due again before its backoff", attempt)
+		}
+	}
+
+	q.attempt(spooled(t, q))
+	e := spooled(t, q)
+	if !e.Failed || e.Attempts != q.maxAttempts || *sends != q.maxAttempts {
+		t.Fatalf("expected failure after %d attempts, got %+v (%d sends)", q.maxAttempts, e, *sends)
+	}
+	if e.Subject != "" || e.Text != "" || e.HTML != "" {
+		t.Errorf("failed message keeps its content: %+v", e)
+	}
+	if pending, failed := q.counts(); pending != 0 || failed != 1 {
+		t.Errorf("counts = %d pending, %d failed", pending, failed)
+	}
+	if len(q.spool.Due(time.Now().Add(2*time.Hour).Unix())) != 0 {
+		t.Errorf("failed message is still due")
+	}
+}
+
+func TestMailQueueSendsAndRemoves(t *testing.T) {
+	q, sends := newTestMailQueue(t, nil)
+	if err := q.enqueue("test", "alice@example.com", "Test", "text", "", 0); err != nil {
+		t.Fatal(err)
+	}
+	q.attempt(spooled(t, q))
+	if *sends != 1 || len(q.spool.List()) != 0 {
+		t.Fatalf("expected one send and an empty spool, got %d sends and %v", *sends, q.spool.List())
+	}
+}
+
+func TestMailQueueExpiry(t *testing.T) {
+	q, sends := newTestMailQueue(t, nil)
+
+	// The max age caps the per-message expiry.
+	before := time.Now()
+	if err := q.enqueue("reset", "alice@example.com", "Reset", "text", "", 48*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	e := spooled(t, q)
+	if want := before.Add(q.maxAge).Unix(); e.ExpiresAt < want || e.ExpiresAt > want+1 {
+		t.Errorf("expires in %ds, want %s", e.ExpiresAt-before.Unix(), q.maxAge)
+	}
+
+	e.ExpiresAt = time.Now().Add(-time.Second).Unix()
+	if err := q.spool.Update(e); err != nil {
+		t.Fatal(err)
+	}
+	q.attempt(e)
+	e = spooled(t, q)
+	if !e.Failed || !strings.Contains(e.LastError, "expired") || *sends != 0 {
+		t.Fatalf("expected expired message to fail without sending, got %+v (%d sends)", e, *sends)
+	}
+}
+
+func TestMailQueuePrunesOldFailedMessages(t *testing.T) {
+	q, _ := newTestMailQueue(t, nil)
+	now := time.Now()
+	old := now.Add(-failedMailRetention - time.Hour).Unix()
+	recent := now.Add(-time.Hour).Unix()
+	for _, e := range []store.MailSpoolEntry{
+		{ID: "old-failed", Kind: "reset", To: "a@example.com", Failed: true, CreatedAt: old},
+		{ID: "recent-failed", Kind: "reset", To: "b@example.com", Failed: true, CreatedAt: recent},
+		{ID: "old-pending", Kind: "reset", To: "c@example.com", NextAttempt: now.Add(time.Hour).Unix(), ExpiresAt: now.Add(time.Hour).Unix(), CreatedAt: old},
+	} {
+		if err := q.spool.Add(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	q.prune(now)
+	var ids []string
+	for _, e := range q.spool.List() {
+		ids = append(ids, e.ID)
+	}
+	if strings.Join(ids, ",") != "old-pending,recent-failed" {
+		t.Fatalf("spool after pruning = %v", ids)
+	}
+}
@@ -1,141 +1,306 @@
 package service
 
 import (
-	"bytes"
-	"crypto/tls"
 	"fmt"
 	"log"
-	"net/smtp"
+	"net/mail"
+	"strings"
 	"time"
-	"text/template"
 
 	"tinyauth-sidecar/internal/config"
+	"tinyauth-sidecar/internal/mailer"
+	"tinyauth-sidecar/internal/store"
+	"tinyauth-sidecar/internal/tmpl"
 
 	"github.com/jordan-wright/email"
 )
 
-type MailService struct{ cfg *config.Config }
-
-func NewMailService(cfg *config.Config) *MailService { return &MailService{cfg: cfg} }
-
-const defaultEmailBody = `Hello,
+type MailService struct {
+	cfg       *config.Config
+	templates *mailTemplates
+	queue     *mailQueue
+	transport mailer.Transport
+	dkim      *mailer.DKIMSigner // nil without DKIM
+}
 
-A password reset was requested for your account.
+// NewMailService loads the embedded mail templates and those in
+// cfg.MailTemplatesDir, which override them per locale and name, and opens
+// the mail spool at cfg.MailSpoolPath. Mail is delivered through transport
+// (see NewMailTransport). Call Start to deliver queued mail.
+func NewMailService(cfg *config.Config, transport mailer.Transport, audit *AuditService) (*MailService, error) {
+	templates, err := loadMailTemplates(cfg.MailTemplatesDir, cfg.MailDefaultLocale)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[mail] templates loaded for locales %v (default %s)", templates.Locales(), templates.defaultLocale)
 
-Click this link to reset your password:
-{{.URL}}
+	spool, err := store.NewMailSpool(cfg.MailSpoolPath)
+	if err != nil {
+		return nil, err
+	}
+	s := &MailService{cfg: cfg, templates: templates, transport: transport}
+	if cfg.DKIMDomain != "" || cfg.DKIMSelector != "" || cfg.DKIMKeyFile != "" {
+		s.dkim, err = mailer.NewDKIMSigner(mailer.DKIMOptions{
+			Domain:           cfg.DKIMDomain,
+			Selector:         cfg.DKIMSelector,
+			KeyFile:          cfg.DKIMKeyFile,
+			Headers:          cfg.DKIMHeaders,
+			Canonicalization: cfg.DKIMCanonicalization,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if from, err := mail.ParseAddress(cfg.SMTPFrom); err == nil && !dkimAligned(from.Address, cfg.DKIMDomain) {
+			log.Printf("[mail] warning: DKIM domain %s does not match the sender %s; DMARC will not pass", cfg.DKIMDomain, from.Address)
+		}
+		log.Printf("[mail] DKIM signing enabled for d=%s s=%s", cfg.DKIMDomain, cfg.DKIMSelector)
+	}
+	s.queue = &mailQueue{
+		spool:       spool,
+		send:        s.sendSpooled,
+		audit:       audit,
+		workers:     cfg.MailWorkers,
+		maxAttempts: cfg.MailMaxAttempts,
+		backoff:     cfg.MailRetryBackoff,
+		maxAge:      time.Duration(cfg.MailMaxAgeSeconds) * time.Second,
+		inflight:    make(map[string]bool),
+		wake:        make(chan struct{}, 1),
+	}
+	if s.queue.workers <= 0 {
+		s.queue.workers = defaultMailWorkers
+	}
+	if s.queue.maxAttempts <= 0 {
+		s.queue.maxAttempts = defaultMailMaxAttempts
+	}
+	if s.queue.maxAge <= 0 {
+		s.queue.maxAge = defaultMailMaxAge
+	}
+	return s, nil
+}
 
-Or use this token: {{.Token}}
+// Start runs the mail queue workers in the background.
+func (s *MailService) Start() {
+	s.queue.start()
+}
 
-If you did not request this, you can safely ignore this email.
-`
+// QueueCounts returns the number of queued and failed (given up) messages.
+func (s *MailService) QueueCounts() (pending, failed int) {
+	return s.queue.counts()
+}
 
+// emailData is the data available to mail templates.
 type emailData struct {
 	URL      string
 	Token    string
 	Username string
+	Name     string
+	Title    string
+	Time     string
+	Enabled  bool
+	Email    string // new address for email verification and change notices
+	Code     string // second-factor code
+	Minutes  int64  // validity of Code, rounded up
+}
+
+// Locales returns the locales mail templates are available in.
+func (s *MailService) Locales() []string {
+	return s.templates.Locales()
 }
 
-func (s *MailService) SendResetEmail(toEmail, token string) error {
+// SendResetEmail queues the password reset link; it expires with the token. lang is a locale or an
+// Accept-Language header. EMAIL_SUBJECT / EMAIL_BODY, when set, replace the
+// subject and the text part of the reset template.
+func (s *MailService) SendResetEmail(toEmail, name, token, lang string) error {
 	resetURL := fmt.Sprintf("%s/reset-password?token=%s", s.cfg.MailBaseURL, token)
-	if s.cfg.SMTPHost == "" {
+	if !s.cfg.MailEnabled() {
 		log.Printf("[mail disabled] reset token for %s: %s (%s)", toEmail, token, resetURL)
 		return nil
 	}
 
-	data := emailData{
-		URL:      resetURL,
-		Token:    token,
-		Username: toEmail,
+	data := s.data(toEmail, name)
+	data.URL = resetURL
+	data.Token = token
+
+	subject, text, html, err := s.templates.render(MailReset, lang, data)
+	if err != nil {
+		return err
+	}
+	if s.cfg.EmailSubject != "" {
+		if subject, err = renderTemplate("subject", s.cfg.EmailSubject, data); err != nil {
+			return fmt.Errorf("email subject template: %w", err)
+		}
+	}
+	if s.cfg.EmailBody != "" {
+		if text, err = renderTemplate("body", s.cfg.EmailBody, data); err != nil {
+			return fmt.Errorf("email body template: %w", err)
+		}
+		html = ""
 	}
+	return s.queue.enqueue(MailReset, toEmail, subject, text, html, time.Duration(s.cfg.ResetTokenTTLSeconds)*time.Second)
+}
 
-	subject := s.cfg.EmailSubject
-	if subject == "" {
-		subject = "Password reset"
+// SendTestEmail sends a simple test email right away (bypassing the queue) to
+// verify the mail configuration.
+func (s *MailService) SendTestEmail(toEmail, lang string) error {
+	if !s.cfg.MailEnabled() {
+		return fmt.Errorf("mail not configured")
 	}
-	renderedSubject, err := renderTemplate("subject", subject, data)
+	subject, text, html, err := s.templates.render(MailTest, lang, s.data(toEmail, ""))
 	if err != nil {
-		return fmt.Errorf("email subject template: %w", err)
+		return err
 	}
+	return s.sendEmail(s.newEmail(toEmail, subject, text, html))
+}
 
-	bodyTmpl := s.cfg.EmailBody
-	if bodyTmpl == "" {
-		bodyTmpl = defaultEmailBody
+// SendPasswordChangedEmail notifies a user that their password was changed.
+func (s *MailService) SendPasswordChangedEmail(toEmail, name, lang string) error {
+	if !s.cfg.MailEnabled() {
+		log.Printf("[mail disabled] password changed notification for %s (not sent)", toEmail)
+		return nil
 	}
-	renderedBody, err := renderTemplate("body", bodyTmpl, data)
-	if err != nil {
-		return fmt.Errorf("email body template: %w", err)
+	return s.sendTemplate(MailPasswordChanged, toEmail, lang, s.data(toEmail, name))
+}
+
+// SendTOTPChangedEmail notifies a user that TOTP was enabled or disabled.
+func (s *MailService) SendTOTPChangedEmail(toEmail, name string, enabled bool, lang string) error {
+	if !s.cfg.MailEnabled() {
+		log.Printf("[mail disabled] TOTP changed notification for %s (not sent)", toEmail)
+		return nil
 	}
+	data := s.data(toEmail, name)
+	data.Enabled = enabled
+	return s.sendTemplate(MailTOTPChanged, toEmail, lang, data)
+}
 
-	e := email.NewEmail()
-	e.From = s.cfg.SMTPFrom
-	e.To = []string{toEmail}
-	e.Subject = renderedSubject
-	e.Text = []byte(renderedBody)
+// SendInviteEmail invites a new user to set their password at url.
+func (s *MailService) SendInviteEmail(toEmail, name, url, lang string) error {
+	if !s.cfg.MailEnabled() {
+		log.Printf("[mail disabled] invite for %s: %s", toEmail, url)
+		return nil
+	}
+	data := s.data(toEmail, name)
+	data.URL = url
+	return s.sendTemplate(MailInvite, toEmail, lang, data)
+}
 
-	return s.sendEmail(e)
+// SendSignupConfirmationEmail asks a new user to confirm their address at url.
+func (s *MailService) SendSignupConfirmationEmail(toEmail, name, url, lang string) error {
+	if !s.cfg.MailEnabled() {
+		log.Printf("[mail disabled] signup confirmation for %s: %s", toEmail, url)
+		return nil
+	}
+	data := s.data(toEmail, name)
+	data.URL = url
+	return s.sendTemplate(MailSignupConfirmation, toEmail, lang, data)
 }
 
-// SendTestEmail sends a simple test email to verify SMTP configuration.
-func (s *MailService) SendTestEmail(toEmail string) error {
-	if s.cfg.SMTPHost == "" {
-		return fmt.Errorf("SMTP not configured")
+// SendEmailVerificationEmail queues the link that confirms a new address; it
+// expires with the verification token.
+func (s *MailService) SendEmailVerificationEmail(toEmail, username, name, url, lang string) error {
+	if !s.cfg.MailEnabled() {
+		log.Printf("[mail disabled] email verification for %s: %s", toEmail, url)
+		return nil
 	}
-	e := email.NewEmail()
-	e.From = s.cfg.SMTPFrom
-	e.To = []string{toEmail}
-	e.Subject = "TinyAuth — Test email"
-	e.Text = []byte("This is a test email from TinyAuth Usermanagement.\n\nIf you received this, your email configuration is working correctly.")
+	data := s.data(username, name)
+	data.URL = url
+	data.Email = toEmail
+	subject, text, html, err := s.templates.render(MailEmailVerification, lang, data)
+	if err != nil {
+		return err
+	}
+	return s.queue.enqueue(MailEmailVerification, toEmail, subject, text, html, time.Duration(s.cfg.EmailVerifyTTLSeconds)*time.Second)
+}
 
-	return s.sendEmail(e)
+// SendEmailChangedEmail tells the old address that the account's email changed to newEmail.
+func (s *MailService) SendEmailChangedEmail(toEmail, name, newEmail, lang string) error {
+	if !s.cfg.MailEnabled() {
+		log.Printf("[mail disabled] email changed notification for %s (not sent)", toEmail)
+		return nil
+	}
+	data := s.data(toEmail, name)
+	data.Email = newEmail
+	return s.sendTemplate(MailEmailChanged, toEmail, lang, data)
 }
 
-// sendEmail sends an email using the appropriate TLS method based on SMTP port.
-func (s *MailService) sendEmail(e *email.Email) error {
-	addr := fmt.Sprintf("%s:%d", s.cfg.SMTPHost, s.cfg.SMTPPort)
-	auth := smtp.PlainAuth("", s.cfg.SMTPUsername, s.cfg.SMTPPassword, s.cfg.SMTPHost)
-	tlsCfg := &tls.Config{ServerName: s.cfg.SMTPHost}
+// SendOTPCodeEmail queues a second-factor code; it expires after ttl.
+func (s *MailService) SendOTPCodeEmail(toEmail, name, code string, ttl time.Duration, lang string) error {
+	if !s.cfg.MailEnabled() {
+		return fmt.Errorf("mail not configured")
+	}
+	data := s.data(toEmail, name)
+	data.Code = code
+	data.Minutes = int64((ttl + time.Minute - 1) / time.Minute)
+	subject, text, html, err := s.templates.render(MailOTPCode, lang, data)
+	if err != nil {
+		return err
+	}
+	return s.queue.enqueue(MailOTPCode, toEmail, subject, text, html, ttl)
+}
 
-	switch s.cfg.SMTPPort {
-	case 465:
-		// Implicit TLS (SMTPS)
-		return e.SendWithTLS(addr, auth, tlsCfg)
-	case 587:
-		// STARTTLS
-		return e.SendWithStartTLS(addr, auth, tlsCfg)
-	default:
-		// Plain
-		return e.Send(addr, auth)
+func (s *MailService) data(username, name string) emailData {
+	return emailData{
+		Username: username,
+		Name:     name,
+		Title:    s.cfg.Title,
+		Time:     time.Now().Format("2006-01-02 15:04:05 MST"),
 	}
 }
 
-// SendPasswordChangedEmail notifies a user that their password was changed.
-func (s *MailService) SendPasswordChangedEmail(toEmail string) error {
-	if s.cfg.SMTPHost == "" {
-		log.Printf("[mail disabled] password changed notification for %s (not sent)", toEmail)
-		return nil
+// sendTemplate renders a template and queues the message.
+func (s *MailService) sendTemplate(name, toEmail, lang string, data emailData) error {
+	subject, text, html, err := s.templates.render(name, lang, data)
+	if err != nil {
+		return err
 	}
+	return s.queue.enqueue(name, toEmail, subject, text, html, 0)
+}
 
-	body := fmt.Sprintf("Hello,\n\nYour password was changed at %s.\n\nIf this wasn't you, contact your administrator immediately.\n",
-		time.Now().Format("2006-01-02 15:04:05 MST"))
+// sendSpooled delivers a queued message.
+func (s *MailService) sendSpooled(e store.MailSpoolEntry) error {
+	return s.sendEmail(s.newEmail(e.To, e.Subject, e.Text, e.HTML))
+}
 
+// newEmail builds a message; with an HTML part it is sent as multipart/alternative.
+func (s *MailService) newEmail(to, subject, text, html string) *email.Email {
 	e := email.NewEmail()
 	e.From = s.cfg.SMTPFrom
-	e.To = []string{toEmail}
-	e.Subject = "Your password was changed"
-	e.Text = []byte(body)
-
-	return s.sendEmail(e)
+	e.To = []string{to}
+	e.Subject = subject
+	e.Text = []byte(text)
+	if html != "" {
+		e.HTML = []byte(html)
+	}
+	return e
 }
 
-func renderTemplate(name, tmplStr string, data emailData) (string, error) {
-	tmpl, err := template.New(name).Parse(tmplStr)
+// sendEmail signs a message (with DKIM enabled) and hands it to the transport.
+func (s *MailService) sendEmail(e *email.Email) error {
+	raw, err := e.Bytes()
 	if err != nil {
-		return "", err
+		return fmt.Errorf("build message: %w", err)
 	}
-	var buf bytes.Buffer
-	if err := tmpl.Execute(&buf, data); err != nil {
-		return "", err
+	if s.dkim != nil {
+		if raw, err = s.dkim.Sign(raw); err != nil {
+			return err
+		}
 	}
-	return buf.String(), nil
+	return s.transport.Send(mailer.Message{
+		From:    e.From,
+		To:      e.To,
+		Subject: e.Subject,
+		Text:    string(e.Text),
+		HTML:    string(e.HTML),
+		Raw:     raw,
+	})
+}
+
+// dkimAligned reports whether addr's domain is the DKIM domain or a subdomain of it.
+func dkimAligned(addr, domain string) bool {
+	_, host, _ := strings.Cut(addr, "@")
+	host, domain = strings.ToLower(host), strings.ToLower(domain)
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+func renderTemplate(name, tmplStr string, data emailData) (string, error) {
+	return tmpl.Render(name, tmplStr, data)
 }
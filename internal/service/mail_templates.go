@@ -0,0 +1,215 @@
+package service
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+
+	"tinyauth-sidecar/internal/tmpl"
+)
+
+// Mail template names. Each template is a file <locale>/<name>.tmpl that
+// defines "subject", "text" and optionally "html".
+const (
+	MailReset              = "reset"
+	MailPasswordChanged    = "password_changed"
+	MailTOTPChanged        = "totp_changed"
+	MailInvite             = "invite"
+	MailSignupConfirmation = "signup_confirmation"
+	MailEmailVerification  = "email_verification"
+	MailEmailChanged       = "email_changed"
+	MailOTPCode            = "otp_code"
+	MailTest               = "test"
+)
+
+//go:embed mailtemplates
+var defaultMailTemplates embed.FS
+
+// mailTemplate is one parsed template file.
+type mailTemplate struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template // nil without an "html" block
+}
+
+// mailTemplates holds the templates per locale and name. Files in the
+// templates directory replace the embedded defaults per locale and name, and
+// may add new locales.
+type mailTemplates struct {
+	byLocale      map[string]map[string]*mailTemplate
+	defaultLocale string
+}
+
+// loadMailTemplates parses the embedded templates and then those in dir (if set).
+func loadMailTemplates(dir, defaultLocale string) (*mailTemplates, error) {
+	m := &mailTemplates{byLocale: make(map[string]map[string]*mailTemplate), defaultLocale: strings.ToLower(defaultLocale)}
+	embedded, _ := fs.Sub(defaultMailTemplates, "mailtemplates")
+	if err := m.load(embedded); err != nil {
+		return nil, fmt.Errorf("embedded mail templates: %w", err)
+	}
+	if dir != "" {
+		if _, err := os.Stat(dir); err != nil {
+			return nil, fmt.Errorf("mail templates dir: %w", err)
+		}
+		if err := m.load(os.DirFS(dir)); err != nil {
+			return nil, fmt.Errorf("mail templates in %s: %w", dir, err)
+		}
+	}
+	if _, ok := m.byLocale[m.defaultLocale]; !ok {
+		log.Printf("[mail] no templates for default locale %q, using \"en\"", m.defaultLocale)
+		m.defaultLocale = "en"
+	}
+	return m, nil
+}
+
+func (m *mailTemplates) load(fsys fs.FS) error {
+	files, err := fs.Glob(fsys, "*/*.tmpl")
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		src, err := fs.ReadFile(fsys, f)
+		if err != nil {
+			return err
+		}
+		locale := strings.ToLower(path.Dir(f))
+		name := strings.TrimSuffix(path.Base(f), ".tmpl")
+		t, err := parseMailTemplate(f, string(src))
+		if err != nil {
+			return err
+		}
+		if m.byLocale[locale] == nil {
+			m.byLocale[locale] = make(map[string]*mailTemplate)
+		}
+		m.byLocale[locale][name] = t
+	}
+	return nil
+}
+
+func parseMailTemplate(name, src string) (*mailTemplate, error) {
+	text, err := texttemplate.New(name).Funcs(tmpl.Funcs()).Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	if text.Lookup("subject") == nil || text.Lookup("text") == nil {
+		return nil, fmt.Errorf("%s: must define \"subject\" and \"text\"", name)
+	}
+	t := &mailTemplate{text: text}
+	if text.Lookup("html") != nil {
+		t.html, err = htmltemplate.New(name).Funcs(htmltemplate.FuncMap(tmpl.Funcs())).Parse(src)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// Locales returns the locales that have templates, sorted.
+func (m *mailTemplates) Locales() []string {
+	return localeNames(m.byLocale)
+}
+
+func localeNames[T any](byLocale map[string]map[string]T) []string {
+	locales := make([]string, 0, len(byLocale))
+	for l := range byLocale {
+		locales = append(locales, l)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// pick returns template name in the best available locale for lang in lang, which is a
+// locale ("nl") or an Accept-Language header ("nl-BE,nl;q=0.9,en;q=0.8").
+// It falls back to the default locale and then to English.
+func (m *mailTemplates) pick(name, lang string) (*mailTemplate, string, error) {
+	if t, locale, ok := pickLocale(m.byLocale, name, lang, m.defaultLocale); ok {
+		return t, locale, nil
+	}
+	return nil, "", fmt.Errorf("no mail template %q", name)
+}
+
+// pickLocale looks up name in the best locale for lang, trying each tag and
+// its base language ("nl" for "nl-be"), then defaultLocale and English.
+func pickLocale[T any](byLocale map[string]map[string]T, name, lang, defaultLocale string) (T, string, bool) {
+	for _, l := range append(parseAcceptLanguage(lang), defaultLocale, "en") {
+		if t, ok := byLocale[l][name]; ok {
+			return t, l, true
+		}
+		if base, _, found := strings.Cut(l, "-"); found {
+			if t, ok := byLocale[base][name]; ok {
+				return t, base, true
+			}
+		}
+	}
+	var zero T
+	return zero, "", false
+}
+
+// render executes template name for lang and returns the subject, text and
+// HTML parts. html is empty if the template has no "html" block.
+func (m *mailTemplates) render(name, lang string, data any) (subject, text, html string, err error) {
+	t, locale, err := m.pick(name, lang)
+	if err != nil {
+		return "", "", "", err
+	}
+	var buf bytes.Buffer
+	if err := t.text.ExecuteTemplate(&buf, "subject", data); err != nil {
+		return "", "", "", fmt.Errorf("%s/%s subject: %w", locale, name, err)
+	}
+	subject = strings.TrimSpace(buf.String())
+
+	buf.Reset()
+	if err := t.text.ExecuteTemplate(&buf, "text", data); err != nil {
+		return "", "", "", fmt.Errorf("%s/%s text: %w", locale, name, err)
+	}
+	text = buf.String()
+
+	if t.html != nil {
+		buf.Reset()
+		if err := t.html.ExecuteTemplate(&buf, "html", data); err != nil {
+			return "", "", "", fmt.Errorf("%s/%s html: %w", locale, name, err)
+		}
+		html = buf.String()
+	}
+	return subject, text, html, nil
+}
+
+// parseAcceptLanguage returns the language tags in lang, lower-cased and
+// ordered by quality. Tags with q=0 and "*" are dropped.
+func parseAcceptLanguage(lang string) []string {
+	type tag struct {
+		name string
+		q    float64
+	}
+	var tags []tag
+	for _, part := range strings.Split(lang, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || name == "*" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		if q > 0 {
+			tags = append(tags, tag{name, q})
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+	out := make([]string, len(tags))
+	for i, t := range tags {
+		out[i] = t.name
+	}
+	return out
+}
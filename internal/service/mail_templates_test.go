@@ -0,0 +1,116 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestParseAcceptLanguage(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{in: "", want: []string{}},
+		{in: "nl", want: []string{"nl"}},
+		{in: "nl-BE,nl;q=0.9,en;q=0.8", want: []string{"nl-be", "nl", "en"}},
+		{in: "en;q=0.5, de;q=0.9, fr", want: []string{"fr", "de", "en"}},
+		{in: "de;q=0.7,nl;q=0.7", want: []string{"de", "nl"}},
+		{in: "*, nl;q=0, en;q=0.1", want: []string{"en"}},
+		{in: "NL;q=bogus", want: []string{"nl"}},
+	}
+	for _, tt := range tests {
+		if got := parseAcceptLanguage(tt.in); !slices.Equal(got, tt.want) {
+			t.Errorf("parseAcceptLanguage(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMailTemplatesPick(t *testing.T) {
+	dir := t.TempDir()
+	writeTemplate := func(locale, name, src string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Join(dir, locale), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, locale, name+".tmpl"), []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTemplate("nl", MailTest, `{{define "subject"}}Eigen test{{end}}{{define "text"}}eigen{{end}}`)
+	writeTemplate("de", MailTest, `{{define "subject"}}Test{{end}}{{define "text"}}test{{end}}`)
+
+	tests := []struct {
+		defaultLocale string
+		name, lang    string
+		want          string
+	}{
+		{defaultLocale: "en", name: MailReset, lang: "nl", want: "nl"},
+		{defaultLocale: "en", name: MailReset, lang: "nl-BE", want: "nl"},
+		{defaultLocale: "en", name: MailReset, lang: "fr;q=0.9,nl;q=0.8,en;q=0.7", want: "nl"},
+		{defaultLocale: "en", name: MailReset, lang: "nl;q=0,en", want: "en"},
+		{defaultLocale: "en", name: MailReset, lang: "*", want: "en"},
+		{defaultLocale: "nl", name: MailReset, lang: "fr", want: "nl"},
+		{defaultLocale: "nl", name: MailReset, lang: "", want: "nl"},
+		// A locale that only exists in the templates dir, for some templates.
+		{defaultLocale: "nl", name: MailTest, lang: "de-AT", want: "de"},
+		{defaultLocale: "nl", name: MailReset, lang: "de", want: "nl"},
+		// An unknown default locale falls back to English.
+		{defaultLocale: "fr", name: MailReset, lang: "es", want: "en"},
+	}
+	for _, tt := range tests {
+		m, err := loadMailTemplates(dir, tt.defaultLocale)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, locale, err := m.pick(tt.name, tt.lang)
+		if err != nil || locale != tt.want {
+			t.Errorf("pick(%q, %q) with default %q = %q, %v; want %q", tt.name, tt.lang, tt.defaultLocale, locale, err, tt.want)
+		}
+	}
+}
+
+func TestMailTemplatesDirOverridesEmbedded(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "nl"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	src := `{{define "subject"}}Eigen test voor {{.Name}}{{end}}{{define "text"}}eigen tekst{{end}}`
+	if err := os.WriteFile(filepath.Join(dir, "nl", MailTest+".tmpl"), []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := loadMailTemplates(dir, "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subject, text, html, err := m.render(MailTest, "nl", map[string]string{"Name": "Alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject != "Eigen test voor Alice" || text != "eigen tekst" || html != "" {
+		t.Errorf("override not used: subject=%q text=%q html=%q", subject, text, html)
+	}
+
+	// Other locales and templates keep the embedded defaults.
+	if subject, _, html, err := m.render(MailTest, "en", nil); err != nil || subject != "TinyAuth — Test email" || html == "" {
+		t.Errorf("embedded en/test changed: subject=%q html=%d bytes, err=%v", subject, len(html), err)
+	}
+	if _, locale, err := m.pick(MailReset, "nl"); err != nil || locale != "nl" {
+		t.Errorf("embedded nl/reset not kept: %q, %v", locale, err)
+	}
+}
+
+func TestMailTemplatesDirRejectsInvalidTemplate(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "en"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "en", MailReset+".tmpl"), []byte(`{{define "text"}}no subject{{end}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadMailTemplates(dir, "en"); err == nil {
+		t.Fatal("expected an error for a template without a subject")
+	}
+}
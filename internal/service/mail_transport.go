@@ -0,0 +1,38 @@
+package service
+
+import (
+	"fmt"
+
+	"tinyauth-sidecar/internal/config"
+	"tinyauth-sidecar/internal/mailer"
+	"tinyauth-sidecar/internal/provider"
+)
+
+// NewMailTransport creates the transport selected by cfg.MailTransport.
+// httpCfg is the [email.http] webhook used by the "http" transport.
+func NewMailTransport(cfg *config.Config, httpCfg config.WebhookConfig) (mailer.Transport, error) {
+	switch cfg.MailTransport {
+	case config.MailTransportSMTP:
+		return mailer.NewSMTPSender(mailer.SMTPOptions{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			Security: cfg.SMTPSecurity,
+			Auth:     cfg.SMTPAuth,
+			HeloName: cfg.SMTPHeloName,
+			TLS:      cfg.SMTPTLSOptions(),
+		}), nil
+	case config.MailTransportSendmail:
+		return mailer.NewSendmailTransport(cfg.MailSendmailPath), nil
+	case config.MailTransportFile:
+		return mailer.NewFileTransport(cfg.MailFileDir), nil
+	case config.MailTransportHTTP:
+		if httpCfg.URL == "" || httpCfg.Body == "" {
+			return nil, fmt.Errorf("mail transport \"http\" needs [email.http] url and body")
+		}
+		return provider.NewWebhookMailTransport(httpCfg)
+	default:
+		return nil, fmt.Errorf("unknown mail transport %q", cfg.MailTransport)
+	}
+}
@@ -0,0 +1,169 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"slices"
+	"time"
+
+	"tinyauth-sidecar/internal/provider"
+	"tinyauth-sidecar/internal/store"
+)
+
+// Sidecar second factors. With one enabled, re-authenticating for sensitive
+// account changes also needs a code sent by SMS or email. They only protect
+// the account page: tinyauth's own login still uses TOTP.
+const (
+	OTPMethodSMS   = "sms"
+	OTPMethodEmail = "email"
+)
+
+const (
+	otpPurposeStepUp = "step_up"
+	otpResendDelay   = time.Minute
+)
+
+// ErrOTPRequired is returned when re-authentication needs a code from the
+// user's second factor and none was given.
+var ErrOTPRequired = errors.New("otp_required")
+
+// OTPMethods returns the second factors username can enroll: SMS with a
+// confirmed phone number and SMS configured, email with a usable address
+// and mail configured.
+func (s *AccountService) OTPMethods(username string) []string {
+	var methods []string
+	if phone, _ := s.store.GetPhone(username); phone != "" && s.sms != nil {
+		methods = append(methods, OTPMethodSMS)
+	}
+	if s.cfg.MailEnabled() && s.notifyAddress(username) != "" {
+		methods = append(methods, OTPMethodEmail)
+	}
+	return methods
+}
+
+// OTPSetup sends an enrollment code over method; OTPEnable confirms it.
+func (s *AccountService) OTPSetup(username, method, clientIP, lang string) error {
+	return s.sendOTP(username, method, "enroll:"+method, clientIP, lang)
+}
+
+// OTPEnable makes method the user's second factor once the code sent by
+// OTPSetup is confirmed.
+func (s *AccountService) OTPEnable(username, method, code, clientIP string) error {
+	if err := s.checkOTP(username, "enroll:"+method, code, clientIP); err != nil {
+		s.audit.Log("otp_enable", username, clientIP, "invalid_code")
+		return err
+	}
+	if err := s.store.SetOTPMethod(username, method); err != nil {
+		return err
+	}
+	s.audit.Log("otp_enable", username, clientIP, method)
+	return nil
+}
+
+// OTPDisable removes the user's second factor. The handler requires a
+// recent re-authentication, which included a step-up code.
+func (s *AccountService) OTPDisable(username, clientIP string) error {
+	if err := s.store.SetOTPMethod(username, ""); err != nil {
+		return err
+	}
+	s.audit.Log("otp_disable", username, clientIP, "success")
+	return nil
+}
+
+// SendStepUpCode sends a code over the user's second factor for Reauthenticate.
+func (s *AccountService) SendStepUpCode(username, clientIP, lang string) error {
+	method := s.store.GetOTPMethod(username)
+	if method == "" {
+		return errors.New("otp_not_enabled")
+	}
+	return s.sendOTP(username, method, otpPurposeStepUp, clientIP, lang)
+}
+
+// requireStepUp checks otp against the step-up code for users with a second
+// factor. Users without one pass, as do users whose factor can't be used
+// because SMS or mail is no longer configured.
+func (s *AccountService) requireStepUp(username, otp, clientIP string) error {
+	method := s.store.GetOTPMethod(username)
+	if method == "" {
+		return nil
+	}
+	if !slices.Contains(s.OTPMethods(username), method) {
+		log.Printf("[otp] %s second factor of %s is unavailable; not required", method, username)
+		return nil
+	}
+	if otp == "" {
+		return ErrOTPRequired
+	}
+	if err := s.checkOTP(username, otpPurposeStepUp, otp, clientIP); err != nil {
+		s.audit.Log("otp_step_up", username, clientIP, "invalid_code")
+		return err
+	}
+	return nil
+}
+
+// checkOTP verifies a code sent by sendOTP for purpose.
+func (s *AccountService) checkOTP(username, purpose, code, clientIP string) error {
+	err := s.store.VerifyOTPCode(username, purpose, code, s.cfg.SMSCodeMaxAttempts)
+	if errors.Is(err, store.ErrSMSCodeLocked) {
+		s.events.Emit(provider.EventUserLockedOut, username, clientIP, map[string]string{"Reason": "otp_code_attempts"})
+	}
+	if err != nil {
+		return errors.New("invalid_otp")
+	}
+	return nil
+}
+
+// sendOTP generates a code for purpose and delivers it over method. Codes
+// follow the [sms] code length, TTL and attempt limits for both channels.
+func (s *AccountService) sendOTP(username, method, purpose, clientIP, lang string) error {
+	if !slices.Contains(s.OTPMethods(username), method) {
+		return errors.New("otp_method_unavailable")
+	}
+	if s.store.OTPCodeSentWithin(username, otpResendDelay) {
+		return errors.New("code_recently_sent")
+	}
+	code, err := generateNumericCode(s.cfg.SMSCodeLength)
+	if err != nil {
+		return err
+	}
+	ttl := time.Duration(s.cfg.SMSCodeTTLSeconds) * time.Second
+	if err := s.store.StoreOTPCode(username, purpose, code, time.Now().Add(ttl).Unix()); err != nil {
+		return err
+	}
+
+	if method == OTPMethodSMS {
+		phone, _ := s.store.GetPhone(username)
+		msg, err := s.smsCodeText(SMSOTPCode, username, lang, code)
+		if err != nil {
+			return err
+		}
+		if err := s.sms.SendSMS(phone, msg); err != nil {
+			log.Printf("[sms] failed to send SMS to %s: %v", phone, err)
+			s.audit.Log("otp_send", username, clientIP, "send_failed")
+			return errors.New("failed to send SMS")
+		}
+	} else {
+		to := s.notifyAddress(username)
+		if err := s.mail.SendOTPCodeEmail(to, s.store.LookupName(username), code, ttl, s.mailLang(username, lang)); err != nil {
+			log.Printf("[mail] failed to queue security code to %s: %v", to, err)
+			s.audit.Log("otp_send", username, clientIP, "send_failed")
+			return errors.New("failed to send email")
+		}
+	}
+	s.audit.Log("otp_send", username, clientIP, method+":"+purpose)
+	return nil
+}
+
+// dropUnreachableOTP removes the user's second factor when its phone number
+// or email address is gone, so the user isn't locked out of their changes.
+func (s *AccountService) dropUnreachableOTP(username, clientIP string) {
+	method := s.store.GetOTPMethod(username)
+	if method == "" || slices.Contains(s.OTPMethods(username), method) {
+		return
+	}
+	if err := s.store.SetOTPMethod(username, ""); err != nil {
+		log.Printf("[otp] failed to remove second factor of %s: %v", username, err)
+		return
+	}
+	s.audit.Log("otp_disable", username, clientIP, method+"_removed")
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"strings"
+	texttemplate "text/template"
+
+	"tinyauth-sidecar/internal/tmpl"
+)
+
+// SMS template names. Each template is a file <locale>/<name>.tmpl holding
+// the message text; surrounding whitespace is trimmed.
+const (
+	SMSReset             = "reset"
+	SMSPhoneVerification = "phone_verification"
+	SMSOTPCode           = "otp_code"
+)
+
+//go:embed smstemplates
+var defaultSMSTemplates embed.FS
+
+// smsData is the data available to SMS templates.
+type smsData struct {
+	Code    string
+	Minutes int64 // validity of the code, rounded up
+	Title   string
+}
+
+// SMSTemplates holds the SMS texts per locale and name. Like mail templates,
+// files in the templates directory replace the embedded defaults per locale
+// and name, and may add new locales.
+type SMSTemplates struct {
+	byLocale      map[string]map[string]*texttemplate.Template
+	defaultLocale string
+}
+
+// NewSMSTemplates parses the embedded SMS templates and then those in dir (if set).
+func NewSMSTemplates(dir, defaultLocale string) (*SMSTemplates, error) {
+	t := &SMSTemplates{byLocale: make(map[string]map[string]*texttemplate.Template), defaultLocale: strings.ToLower(defaultLocale)}
+	embedded, _ := fs.Sub(defaultSMSTemplates, "smstemplates")
+	if err := t.load(embedded); err != nil {
+		return nil, fmt.Errorf("embedded SMS templates: %w", err)
+	}
+	if dir != "" {
+		if _, err := os.Stat(dir); err != nil {
+			return nil, fmt.Errorf("SMS templates: %w", err)
+		}
+		if err := t.load(os.DirFS(dir)); err != nil {
+			return nil, fmt.Errorf("SMS templates in %s: %w", dir, err)
+		}
+	}
+	if _, ok := t.byLocale[t.defaultLocale]; !ok {
+		log.Printf("[sms] no templates for default locale %q, using \"en\"", t.defaultLocale)
+		t.defaultLocale = "en"
+	}
+	log.Printf("[sms] templates loaded for locales %v (default %s)", t.Locales(), t.defaultLocale)
+	return t, nil
+}
+
+func (t *SMSTemplates) load(fsys fs.FS) error {
+	files, err := fs.Glob(fsys, "*/*.tmpl")
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		src, err := fs.ReadFile(fsys, f)
+		if err != nil {
+			return err
+		}
+		locale := strings.ToLower(path.Dir(f))
+		name := strings.TrimSuffix(path.Base(f), ".tmpl")
+		parsed, err := texttemplate.New(f).Funcs(tmpl.Funcs()).Parse(string(src))
+		if err != nil {
+			return err
+		}
+		if t.byLocale[locale] == nil {
+			t.byLocale[locale] = make(map[string]*texttemplate.Template)
+		}
+		t.byLocale[locale][name] = parsed
+	}
+	return nil
+}
+
+// Locales returns the locales SMS templates are available in, sorted.
+func (t *SMSTemplates) Locales() []string {
+	return localeNames(t.byLocale)
+}
+
+// render executes template name for lang, a locale or an Accept-Language header.
+func (t *SMSTemplates) render(name, lang string, data smsData) (string, error) {
+	parsed, locale, ok := pickLocale(t.byLocale, name, lang, t.defaultLocale)
+	if !ok {
+		return "", fmt.Errorf("no SMS template %q", name)
+	}
+	var buf bytes.Buffer
+	if err := parsed.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("%s/%s: %w", locale, name, err)
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"tinyauth-sidecar/internal/provider"
+	"tinyauth-sidecar/internal/store"
+)
+
+// userWatchInterval is how often users.txt and users.toml are checked for changes.
+const userWatchInterval = 5 * time.Second
+
+// UserWatcher notices users and roles changed outside the sidecar: users are
+// added to and removed from users.txt, and roles set in users.toml, by hand or
+// by other tooling. It polls both files and publishes user.created,
+// user.deleted and user.role_changed for what changed since the last check.
+// Removed users are also dropped from the file sync hooks, at startup for
+// users removed while the sidecar was stopped.
+type UserWatcher struct {
+	users  *UserFileService
+	store  *store.Store
+	events *EventBus
+	hooks  *HookDeliveryService
+
+	usersStamp store.FileStamp
+	known      map[string]string // username -> role; nil before the first scan
+}
+
+// NewUserWatcher creates a watcher for the users file of users and the
+// metadata in st. events and hooks may be nil.
+func NewUserWatcher(users *UserFileService, st *store.Store, events *EventBus, hooks *HookDeliveryService) *UserWatcher {
+	return &UserWatcher{users: users, store: st, events: events, hooks: hooks}
+}
+
+// Start records the current users and then checks for changes in the background.
+func (w *UserWatcher) Start() {
+	w.scan()
+	go func() {
+		ticker := time.NewTicker(userWatchInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			w.scan()
+		}
+	}()
+}
+
+// scan compares the users and roles on disk with the previous scan and emits
+// an event per difference. The first scan only records the current state and
+// prunes the file sync hooks.
+func (w *UserWatcher) scan() {
+	reloaded, err := w.store.Reload()
+	if err != nil {
+		log.Printf("[users] cannot reload users.toml: %v", err)
+	}
+	stamp := store.StatStamp(w.users.cfg.UsersFilePath)
+	if w.known != nil && !reloaded && stamp == w.usersStamp {
+		return
+	}
+
+	records, err := w.users.ReadAll()
+	if err != nil {
+		// Possibly caught mid-edit; try again on the next tick.
+		log.Printf("[users] cannot read users file: %v", err)
+		return
+	}
+	current := make(map[string]string, len(records))
+	for _, u := range records {
+		role := ""
+		if meta := w.store.GetUserMeta(u.Username); meta != nil {
+			role = meta.Role
+		}
+		current[u.Username] = role
+	}
+
+	previous := w.known
+	w.known = current
+	w.usersStamp = stamp
+	if previous == nil {
+		w.hooks.PruneUsers(sortedKeys(current))
+		return
+	}
+
+	for _, name := range sortedKeys(current) {
+		role := current[name]
+		oldRole, existed := previous[name]
+		switch {
+		case !existed:
+			log.Printf("[users] %s was added", name)
+			w.events.emitEvent(provider.Event{Type: provider.EventUserCreated, Username: name, Role: role})
+		case oldRole != role:
+			log.Printf("[users] role of %s changed from %q to %q", name, oldRole, role)
+			w.events.emitEvent(provider.Event{Type: provider.EventUserRoleChanged, Username: name, Role: role,
+				Data: map[string]string{"OldRole": oldRole, "NewRole": role}})
+		}
+	}
+	removed := false
+	for _, name := range sortedKeys(previous) {
+		if _, ok := current[name]; !ok {
+			log.Printf("[users] %s was removed", name)
+			w.events.emitEvent(provider.Event{Type: provider.EventUserDeleted, Username: name, Role: previous[name]})
+			removed = true
+		}
+	}
+	if removed {
+		w.hooks.PruneUsers(sortedKeys(current))
+	}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
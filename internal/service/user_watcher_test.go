@@ -0,0 +1,158 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"tinyauth-sidecar/internal/config"
+	"tinyauth-sidecar/internal/provider"
+	"tinyauth-sidecar/internal/store"
+)
+
+// recordingEventHook records the events it receives.
+type recordingEventHook struct {
+	mu     sync.Mutex
+	events []provider.Event
+}
+
+func (h *recordingEventHook) Config() config.EventHookConfig {
+	return config.EventHookConfig{WebhookConfig: config.WebhookConfig{Name: "recorder"}}
+}
+
+func (h *recordingEventHook) Subscribed(string) bool { return true }
+
+func (h *recordingEventHook) OnEvent(ev provider.Event) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.events = append(h.events, ev)
+	return nil
+}
+
+// wait returns the first n events, failing if they don't arrive in time.
+func (h *recordingEventHook) wait(t *testing.T, n int) []provider.Event {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		h.mu.Lock()
+		got := append([]provider.Event(nil), h.events...)
+		h.mu.Unlock()
+		if len(got) >= n {
+			return got
+		}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	t.Fatalf("expected %d events, got %+v", n, h.events)
+	return nil
+}
+
+func TestUserWatcherDetectsOutsideChanges(t *testing.T) {
+	dir := t.TempDir()
+	usersPath := filepath.Join(dir, "users.txt")
+	tomlPath := filepath.Join(dir, "users.toml")
+	write := func(path, content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(usersPath, "alice@example.com:$2a$10$hash\nbob@example.com:$2a$10$hash\n")
+	write(tomlPath, "[\"alice@example.com\"]\nrole = \"user\"\n")
+
+	st, err := store.NewStore(tomlPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hook := &recordingEventHook{}
+	events := NewEventBus(st, NewAuditService(filepath.Join(dir, "audit.log")), hook)
+	events.Start()
+	w := NewUserWatcher(NewUserFileService(&config.Config{UsersFilePath: usersPath}), st, events, nil)
+
+	w.scan() // the initial state is not reported
+	w.scan()
+	time.Sleep(50 * time.Millisecond)
+	hook.mu.Lock()
+	initial := len(hook.events)
+	hook.mu.Unlock()
+	if initial != 0 {
+		t.Fatalf("unexpected events for the initial state: %d", initial)
+	}
+
+	write(usersPath, "alice@example.com:$2a$10$hash\ncarol@example.com:$2a$10$hash\n")
+	write(tomlPath, "[\"alice@example.com\"]\nrole = \"admin\"\n\n[\"carol@example.com\"]\nrole = \"staff\"\n")
+	w.scan()
+
+	got := hook.wait(t, 3)
+	want := []struct{ typ, user, role string }{
+		{provider.EventUserRoleChanged, "alice@example.com", "admin"},
+		{provider.EventUserCreated, "carol@example.com", "staff"},
+		{provider.EventUserDeleted, "bob@example.com", ""},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("events = %+v", got)
+	}
+	for i, ev := range got {
+		if ev.Type != want[i].typ || ev.Username != want[i].user || ev.Role != want[i].role {
+			t.Errorf("event %d = %s %s (role %q), want %s %s (role %q)", i, ev.Type, ev.Username, ev.Role, want[i].typ, want[i].user, want[i].role)
+		}
+	}
+	if d := got[0].Data; d["OldRole"] != "user" || d["NewRole"] != "admin" {
+		t.Errorf("role change data = %v", d)
+	}
+}
+
+func TestUserWatcherPrunesFileHooks(t *testing.T) {
+	dir := t.TempDir()
+	usersPath := filepath.Join(dir, "users.txt")
+	passwdPath := filepath.Join(dir, "htpasswd")
+	if err := os.WriteFile(usersPath, []byte("alice@example.com:$2a$10$a\nbob@example.com:$2a$10$b\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(passwdPath, []byte("other:$2a$10$other\n"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	hook := provider.NewPasswordHook(config.WebhookConfig{
+		Name: "htpasswd", Type: config.HookTypeFile, Enabled: true,
+		File: config.FileHookConfig{Path: passwdPath, Format: "htpasswd"},
+	})
+	for _, u := range []string{"alice@example.com", "bob@example.com", "gone@example.com"} {
+		if err := hook.OnPasswordChanged(provider.PasswordChangeContext{Email: u, HashedPassword: "$2a$10$x"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := &config.Config{UsersFilePath: usersPath, HookOutboxPath: filepath.Join(dir, "outbox.json")}
+	hooks, err := NewHookDeliveryService(cfg, NewAuditService(filepath.Join(dir, "audit.log")), hook)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := store.NewStore(filepath.Join(dir, "users.toml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewUserWatcher(NewUserFileService(cfg), st, nil, hooks)
+
+	entries := func() string {
+		t.Helper()
+		data, err := os.ReadFile(passwdPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	// A user removed while the sidecar was stopped is dropped at startup.
+	w.scan()
+	if got, want := entries(), "other:$2a$10$other\nalice@example.com:$2a$10$x\nbob@example.com:$2a$10$x\n"; got != want {
+		t.Fatalf("after startup:\n%s\nwant:\n%s", got, want)
+	}
+
+	if err := os.WriteFile(usersPath, []byte("alice@example.com:$2a$10$a\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	w.scan()
+	if got, want := entries(), "other:$2a$10$other\nalice@example.com:$2a$10$x\n"; got != want {
+		t.Fatalf("after removing bob:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,164 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// MailSpoolEntry is a rendered mail waiting for (or given up on) delivery.
+// Messages can contain reset links, so the spool file is only readable by the sidecar.
+type MailSpoolEntry struct {
+	ID          string `json:"id"`
+	Kind        string `json:"kind"`
+	To          string `json:"to"`
+	Subject     string `json:"subject"`
+	Text        string `json:"text"`
+	HTML        string `json:"html,omitempty"`
+	Attempts    int    `json:"attempts"`
+	NextAttempt int64  `json:"next_attempt"`
+	ExpiresAt   int64  `json:"expires_at"`
+	LastError   string `json:"last_error,omitempty"`
+	Failed      bool   `json:"failed"`
+	CreatedAt   int64  `json:"created_at"`
+}
+
+// MailSpool persists queued mail in a JSON file so it survives restarts.
+type MailSpool struct {
+	path string
+
+	mu      sync.Mutex
+	entries map[string]*MailSpoolEntry // key = id
+}
+
+// NewMailSpool opens (or creates) the spool file at path.
+func NewMailSpool(path string) (*MailSpool, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("mkdir mail spool dir: %w", err)
+	}
+
+	s := &MailSpool{path: path, entries: make(map[string]*MailSpoolEntry)}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("read mail spool: %w", err)
+	}
+	if len(data) > 0 {
+		var list []*MailSpoolEntry
+		if err := json.Unmarshal(data, &list); err != nil {
+			return nil, fmt.Errorf("decode mail spool: %w", err)
+		}
+		for _, e := range list {
+			s.entries[e.ID] = e
+		}
+	}
+	return s, nil
+}
+
+func (s *MailSpool) saveNoLock() error {
+	data, err := json.MarshalIndent(s.sortedNoLock(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode mail spool: %w", err)
+	}
+
+	// Atomic write: temp file + rename
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("write temp mail spool: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("rename mail spool: %w", err)
+	}
+	return nil
+}
+
+// sortedNoLock returns copies of all entries, oldest first.
+func (s *MailSpool) sortedNoLock() []MailSpoolEntry {
+	list := make([]MailSpoolEntry, 0, len(s.entries))
+	for _, e := range s.entries {
+		list = append(list, *e)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].CreatedAt != list[j].CreatedAt {
+			return list[i].CreatedAt < list[j].CreatedAt
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// Add stores a new message.
+func (s *MailSpool) Add(entry MailSpoolEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[entry.ID] = &entry
+	return s.saveNoLock()
+}
+
+// Update replaces an existing message. Unknown IDs are ignored.
+func (s *MailSpool) Update(entry MailSpoolEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.entries[entry.ID]; !ok {
+		return nil
+	}
+	s.entries[entry.ID] = &entry
+	return s.saveNoLock()
+}
+
+// Remove deletes a message.
+func (s *MailSpool) Remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.entries[id]; !ok {
+		return nil
+	}
+	delete(s.entries, id)
+	return s.saveNoLock()
+}
+
+// Due returns pending (not failed) messages whose next attempt is at or before now.
+func (s *MailSpool) Due(now int64) []MailSpoolEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []MailSpoolEntry
+	for _, e := range s.sortedNoLock() {
+		if !e.Failed && e.NextAttempt <= now {
+			due = append(due, e)
+		}
+	}
+	return due
+}
+
+// PruneFailed removes failed messages created before the given Unix time.
+func (s *MailSpool) PruneFailed(before int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pruned := false
+	for id, e := range s.entries {
+		if e.Failed && e.CreatedAt < before {
+			delete(s.entries, id)
+			pruned = true
+		}
+	}
+	if !pruned {
+		return nil
+	}
+	return s.saveNoLock()
+}
+
+// List returns all messages, oldest first.
+func (s *MailSpool) List() []MailSpoolEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sortedNoLock()
+}
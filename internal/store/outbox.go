@@ -0,0 +1,164 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// OutboxEntry is a pending or failed password hook delivery.
+// Payload holds the encrypted password data and is never decrypted by the store.
+type OutboxEntry struct {
+	ID          string `json:"id"`
+	Hook        string `json:"hook"`
+	Username    string `json:"username"`
+	Role        string `json:"role,omitempty"`
+	Payload     string `json:"payload"`
+	MessageID   string `json:"message_id,omitempty"` // webhook-id, the same on every attempt
+	Attempts    int    `json:"attempts"`
+	NextAttempt int64  `json:"next_attempt"`
+	LastError   string `json:"last_error,omitempty"`
+	Failed      bool   `json:"failed"`
+	CreatedAt   int64  `json:"created_at"`
+}
+
+// Outbox persists hook deliveries in a JSON file so they survive restarts.
+type Outbox struct {
+	path string
+
+	mu      sync.Mutex
+	entries map[string]*OutboxEntry // key = id
+}
+
+// NewOutbox opens (or creates) the outbox file at path.
+func NewOutbox(path string) (*Outbox, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("mkdir outbox dir: %w", err)
+	}
+
+	o := &Outbox{path: path, entries: make(map[string]*OutboxEntry)}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("read outbox: %w", err)
+	}
+	if len(data) > 0 {
+		var list []*OutboxEntry
+		if err := json.Unmarshal(data, &list); err != nil {
+			return nil, fmt.Errorf("decode outbox: %w", err)
+		}
+		for _, e := range list {
+			o.entries[e.ID] = e
+		}
+	}
+	return o, nil
+}
+
+func (o *Outbox) saveNoLock() error {
+	data, err := json.MarshalIndent(o.sortedNoLock(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode outbox: %w", err)
+	}
+
+	// Atomic write: temp file + rename
+	tmp := o.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("write temp outbox: %w", err)
+	}
+	if err := os.Rename(tmp, o.path); err != nil {
+		return fmt.Errorf("rename outbox: %w", err)
+	}
+	return nil
+}
+
+// sortedNoLock returns copies of all entries, oldest first.
+func (o *Outbox) sortedNoLock() []OutboxEntry {
+	list := make([]OutboxEntry, 0, len(o.entries))
+	for _, e := range o.entries {
+		list = append(list, *e)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].CreatedAt != list[j].CreatedAt {
+			return list[i].CreatedAt < list[j].CreatedAt
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// Add stores a new delivery. Pending deliveries for the same hook and user are
+// dropped, since they carry an outdated password.
+func (o *Outbox) Add(entry OutboxEntry) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for id, e := range o.entries {
+		if e.Hook == entry.Hook && e.Username == entry.Username {
+			delete(o.entries, id)
+		}
+	}
+	o.entries[entry.ID] = &entry
+	return o.saveNoLock()
+}
+
+// Update replaces an existing delivery. Unknown IDs are ignored, so a delivery
+// discarded or superseded while in flight is not resurrected.
+func (o *Outbox) Update(entry OutboxEntry) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if _, ok := o.entries[entry.ID]; !ok {
+		return nil
+	}
+	o.entries[entry.ID] = &entry
+	return o.saveNoLock()
+}
+
+// Remove deletes a delivery.
+func (o *Outbox) Remove(id string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if _, ok := o.entries[id]; !ok {
+		return nil
+	}
+	delete(o.entries, id)
+	return o.saveNoLock()
+}
+
+// Get returns a delivery by ID.
+func (o *Outbox) Get(id string) (OutboxEntry, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	e, ok := o.entries[id]
+	if !ok {
+		return OutboxEntry{}, false
+	}
+	return *e, true
+}
+
+// Due returns pending (not failed) deliveries whose next attempt is at or before now.
+func (o *Outbox) Due(now int64) []OutboxEntry {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var due []OutboxEntry
+	for _, e := range o.sortedNoLock() {
+		if !e.Failed && e.NextAttempt <= now {
+			due = append(due, e)
+		}
+	}
+	return due
+}
+
+// List returns all deliveries, oldest first.
+func (o *Outbox) List() []OutboxEntry {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return o.sortedNoLock()
+}
@@ -0,0 +1,149 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	smsLogMaxEntries = 500
+	smsLogMaxAge     = 7 * 24 * time.Hour
+)
+
+// SMSLogEntry is a sent SMS and its latest delivery status. The message text
+// is not kept, since it usually contains a code.
+type SMSLogEntry struct {
+	ID        string `json:"id"`
+	Provider  string `json:"provider"`
+	To        string `json:"to"`
+	Status    string `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	CreatedAt int64  `json:"created_at"`
+	UpdatedAt int64  `json:"updated_at"`
+}
+
+// SMSLog persists recently sent SMS in a JSON file so delivery reports can be
+// matched and shown to admins. It keeps the last 500 messages of the last 7 days.
+type SMSLog struct {
+	path string
+
+	mu      sync.Mutex
+	entries map[string]*SMSLogEntry // key = provider + "/" + id
+}
+
+// NewSMSLog opens (or creates) the SMS log file at path.
+func NewSMSLog(path string) (*SMSLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("mkdir sms log dir: %w", err)
+	}
+
+	l := &SMSLog{path: path, entries: make(map[string]*SMSLogEntry)}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("read sms log: %w", err)
+	}
+	if len(data) > 0 {
+		var list []*SMSLogEntry
+		if err := json.Unmarshal(data, &list); err != nil {
+			return nil, fmt.Errorf("decode sms log: %w", err)
+		}
+		for _, e := range list {
+			l.entries[smsLogKey(e.Provider, e.ID)] = e
+		}
+	}
+	return l, nil
+}
+
+func smsLogKey(provider, id string) string {
+	return provider + "/" + id
+}
+
+func (l *SMSLog) saveNoLock() error {
+	data, err := json.MarshalIndent(l.sortedNoLock(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode sms log: %w", err)
+	}
+
+	// Atomic write: temp file + rename
+	tmp := l.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("write temp sms log: %w", err)
+	}
+	if err := os.Rename(tmp, l.path); err != nil {
+		return fmt.Errorf("rename sms log: %w", err)
+	}
+	return nil
+}
+
+// sortedNoLock returns copies of all entries, newest first.
+func (l *SMSLog) sortedNoLock() []SMSLogEntry {
+	list := make([]SMSLogEntry, 0, len(l.entries))
+	for _, e := range l.entries {
+		list = append(list, *e)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].CreatedAt != list[j].CreatedAt {
+			return list[i].CreatedAt > list[j].CreatedAt
+		}
+		return list[i].ID > list[j].ID
+	})
+	return list
+}
+
+// pruneNoLock drops entries beyond the size and age limits.
+func (l *SMSLog) pruneNoLock(now time.Time) {
+	cutoff := now.Add(-smsLogMaxAge).Unix()
+	for i, e := range l.sortedNoLock() {
+		if i >= smsLogMaxEntries || e.CreatedAt < cutoff {
+			delete(l.entries, smsLogKey(e.Provider, e.ID))
+		}
+	}
+}
+
+// RecordSMS stores a message accepted by provider under its message ID.
+func (l *SMSLog) RecordSMS(provider, id, to, status string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.entries[smsLogKey(provider, id)] = &SMSLogEntry{
+		ID:        id,
+		Provider:  provider,
+		To:        to,
+		Status:    status,
+		CreatedAt: now.Unix(),
+		UpdatedAt: now.Unix(),
+	}
+	l.pruneNoLock(now)
+	return l.saveNoLock()
+}
+
+// UpdateSMSStatus sets the delivery status of a logged message. It returns
+// false for unknown messages, e.g. ones already pruned.
+func (l *SMSLog) UpdateSMSStatus(provider, id, status, detail string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e, ok := l.entries[smsLogKey(provider, id)]
+	if !ok {
+		return false, nil
+	}
+	e.Status = status
+	e.Detail = detail
+	e.UpdatedAt = time.Now().Unix()
+	return true, l.saveNoLock()
+}
+
+// List returns the logged messages, newest first.
+func (l *SMSLog) List() []SMSLogEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.sortedNoLock()
+}
@@ -2,9 +2,11 @@ package store
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -19,6 +21,15 @@ type UserMeta struct {
 	Phone    string `toml:"phone,omitempty"`
 	Email    string `toml:"email,omitempty"`
 	Approved bool   `toml:"approved,omitempty"`
+	Language string `toml:"language,omitempty"`
+	// PendingEmail is a new address waiting for the user to click the verification link.
+	PendingEmail string `toml:"pending_email,omitempty"`
+	// PendingPhone is a new E.164 number waiting for its SMS code to be confirmed.
+	PendingPhone string `toml:"pending_phone,omitempty"`
+	// OTPMethod is the sidecar second factor ("sms" or "email"); codes sent
+	// this way confirm sensitive account changes. It does not affect the
+	// tinyauth login.
+	OTPMethod string `toml:"otp_method,omitempty"`
 }
 
 // resetTokenEntry is an in-memory reset token record.
@@ -28,11 +39,53 @@ type resetTokenEntry struct {
 	Used      bool
 }
 
+// emailTokenEntry is an in-memory email verification token record.
+type emailTokenEntry struct {
+	Username  string
+	Email     string
+	ExpiresAt int64
+	Used      bool
+}
+
+// ErrEmailSuperseded is returned when a verification link is for an address
+// that is no longer the user's pending email.
+var ErrEmailSuperseded = errors.New("email change superseded")
+
+// phoneCode is an in-memory SMS code confirming a new phone number.
+type phoneCode struct {
+	Phone     string
+	Code      string
+	ExpiresAt int64
+	CreatedAt int64
+	Attempts  int
+}
+
+// ErrPhoneSuperseded is returned when a code is for a number that is no
+// longer the user's pending phone.
+var ErrPhoneSuperseded = errors.New("phone change superseded")
+
+// ErrPhoneAmbiguous is returned when more than one user has the same phone number.
+var ErrPhoneAmbiguous = errors.New("phone number belongs to more than one user")
+
+// ErrSMSCodeLocked is returned when a reset code is invalidated after too many wrong guesses.
+var ErrSMSCodeLocked = errors.New("too many attempts")
+
+// otpCode is an in-memory one-time code for the sidecar second factor.
+// Purpose tells enrollment codes ("enroll:sms") from step-up codes.
+type otpCode struct {
+	Purpose   string
+	Code      string
+	ExpiresAt int64
+	CreatedAt int64
+	Attempts  int
+}
+
 // smsResetCode is an in-memory SMS reset code record.
 type smsResetCode struct {
 	Username  string
 	Code      string
 	ExpiresAt int64
+	CreatedAt int64
 	Used      bool
 	Attempts  int
 }
@@ -48,8 +101,32 @@ type Store struct {
 	resetMu     sync.Mutex
 	resetTokens map[string]*resetTokenEntry // key = token
 
-	smsMu    sync.Mutex
-	smsCodes map[string]*smsResetCode // key = id
+	emailMu     sync.Mutex
+	emailTokens map[string]*emailTokenEntry // key = token
+
+	smsMu      sync.Mutex
+	smsCodes   map[string]*smsResetCode // key = id
+	phoneCodes map[string]*phoneCode    // key = username
+	otpCodes   map[string]*otpCode      // key = username
+
+	// tomlStamp is the state of users.toml when it was last read or written,
+	// so Reload can tell edits made outside the sidecar.
+	tomlStamp FileStamp
+}
+
+// FileStamp identifies a version of a file by its modification time and size.
+type FileStamp struct {
+	ModTime time.Time
+	Size    int64
+}
+
+// StatStamp returns the stamp of path; the zero stamp if it doesn't exist.
+func StatStamp(path string) FileStamp {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return FileStamp{}
+	}
+	return FileStamp{ModTime: fi.ModTime(), Size: fi.Size()}
 }
 
 // NewStore creates a new TOML-backed store. It reads the TOML file
@@ -70,7 +147,10 @@ func NewStore(tomlPath string) (*Store, error) {
 		tomlPath:    tomlPath,
 		users:       make(map[string]*UserMeta),
 		resetTokens: make(map[string]*resetTokenEntry),
+		emailTokens: make(map[string]*emailTokenEntry),
 		smsCodes:    make(map[string]*smsResetCode),
+		phoneCodes:  make(map[string]*phoneCode),
+		otpCodes:    make(map[string]*otpCode),
 	}
 
 	// Load existing TOML file if present
@@ -79,10 +159,33 @@ func NewStore(tomlPath string) (*Store, error) {
 			return nil, fmt.Errorf("decode users.toml: %w", err)
 		}
 	}
+	s.tomlStamp = StatStamp(tomlPath)
 
 	return s, nil
 }
 
+// Reload re-reads users.toml if it was changed outside the sidecar since it
+// was last read or written, and reports whether it did. On a decode error the
+// current metadata is kept.
+func (s *Store) Reload() (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stamp := StatStamp(s.tomlPath)
+	if stamp == s.tomlStamp {
+		return false, nil
+	}
+	users := make(map[string]*UserMeta)
+	if stamp != (FileStamp{}) {
+		if _, err := toml.DecodeFile(s.tomlPath, &users); err != nil {
+			return false, fmt.Errorf("decode users.toml: %w", err)
+		}
+	}
+	s.users = users
+	s.tomlStamp = stamp
+	return true, nil
+}
+
 // Close is a no-op for the TOML store (satisfies the old interface).
 func (s *Store) Close() error { return nil }
 
@@ -103,6 +206,7 @@ func (s *Store) saveTOML() error {
 	if err := os.Rename(tmp, s.tomlPath); err != nil {
 		return fmt.Errorf("rename toml: %w", err)
 	}
+	s.tomlStamp = StatStamp(s.tomlPath)
 	return nil
 }
 
@@ -134,17 +238,102 @@ func (s *Store) GetPhone(username string) (string, error) {
 	return meta.Phone, nil
 }
 
-// FindUserByPhone returns the username for a given phone number.
+// FindUserByPhone returns the username for a given (E.164) phone number, or
+// ErrPhoneAmbiguous if several users share it.
 func (s *Store) FindUserByPhone(phone string) (string, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
+	found := ""
 	for username, meta := range s.users {
 		if meta.Phone == phone {
-			return username, nil
+			if found != "" {
+				return "", ErrPhoneAmbiguous
+			}
+			found = username
 		}
 	}
-	return "", nil
+	return found, nil
+}
+
+// NormalizePhones rewrites stored phone numbers with normalize (e.g. to
+// E.164). Numbers it rejects are kept as they are and returned by username.
+func (s *Store) NormalizePhones(normalize func(string) (string, error)) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	invalid := make(map[string]string)
+	changed := false
+	for username, meta := range s.users {
+		if meta.Phone == "" {
+			continue
+		}
+		n, err := normalize(meta.Phone)
+		if err != nil {
+			invalid[username] = meta.Phone
+			continue
+		}
+		if n != meta.Phone {
+			meta.Phone = n
+			changed = true
+		}
+	}
+	if !changed {
+		return invalid, nil
+	}
+	return invalid, s.saveTOML()
+}
+
+// DuplicatePhones returns the phone numbers that more than one user has, with
+// the (sorted) usernames sharing each.
+func (s *Store) DuplicatePhones() map[string][]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	byPhone := make(map[string][]string)
+	for username, meta := range s.users {
+		if meta.Phone != "" {
+			byPhone[meta.Phone] = append(byPhone[meta.Phone], username)
+		}
+	}
+	dups := make(map[string][]string)
+	for number, usernames := range byPhone {
+		if len(usernames) > 1 {
+			sort.Strings(usernames)
+			dups[number] = usernames
+		}
+	}
+	return dups
+}
+
+// SetPendingPhone records a number awaiting SMS confirmation ("" cancels it).
+func (s *Store) SetPendingPhone(username, phone string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	meta, ok := s.users[username]
+	if !ok {
+		meta = &UserMeta{}
+		s.users[username] = meta
+	}
+	meta.PendingPhone = phone
+	return s.saveTOML()
+}
+
+// ConfirmPendingPhone makes the pending number the user's phone. It returns
+// the previous number, or ErrPhoneSuperseded if phone is not the pending one.
+func (s *Store) ConfirmPendingPhone(username, phone string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	meta, ok := s.users[username]
+	if !ok || meta.PendingPhone == "" || meta.PendingPhone != phone {
+		return "", ErrPhoneSuperseded
+	}
+	old := meta.Phone
+	meta.Phone = meta.PendingPhone
+	meta.PendingPhone = ""
+	return old, s.saveTOML()
 }
 
 // GetUserMeta returns the metadata for a user (or nil if not found).
@@ -182,6 +371,75 @@ func (s *Store) SetEmail(username, email string) error {
 	return s.saveTOML()
 }
 
+// SetPendingEmail records an address awaiting verification ("" cancels it).
+func (s *Store) SetPendingEmail(username, email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	meta, ok := s.users[username]
+	if !ok {
+		meta = &UserMeta{}
+		s.users[username] = meta
+	}
+	meta.PendingEmail = email
+	return s.saveTOML()
+}
+
+// ConfirmPendingEmail makes the pending address the user's email. It returns
+// the previous address, or ErrEmailSuperseded if email is not the pending one.
+func (s *Store) ConfirmPendingEmail(username, email string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	meta, ok := s.users[username]
+	if !ok || meta.PendingEmail == "" || !strings.EqualFold(meta.PendingEmail, email) {
+		return "", ErrEmailSuperseded
+	}
+	old := meta.Email
+	meta.Email = meta.PendingEmail
+	meta.PendingEmail = ""
+	return old, s.saveTOML()
+}
+
+// SetLanguage sets the preferred language (e.g. "nl") for mail sent to a user.
+func (s *Store) SetLanguage(username, language string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	meta, ok := s.users[username]
+	if !ok {
+		meta = &UserMeta{}
+		s.users[username] = meta
+	}
+	meta.Language = language
+	return s.saveTOML()
+}
+
+// SetOTPMethod sets the user's sidecar second factor ("" removes it).
+func (s *Store) SetOTPMethod(username, method string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	meta, ok := s.users[username]
+	if !ok {
+		meta = &UserMeta{}
+		s.users[username] = meta
+	}
+	meta.OTPMethod = method
+	return s.saveTOML()
+}
+
+// GetOTPMethod returns the user's sidecar second factor, or "".
+func (s *Store) GetOTPMethod(username string) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if meta, ok := s.users[username]; ok {
+		return meta.OTPMethod
+	}
+	return ""
+}
+
 // LookupName returns the display name for a user.
 // Returns empty string if not found.
 func (s *Store) LookupName(username string) string {
@@ -274,6 +532,45 @@ func (s *Store) MarkResetTokenUsed(token string) error {
 	return nil
 }
 
+// ---------- Email verification tokens (in-memory) ----------
+
+// CreateEmailToken stores a token that verifies email for username.
+func (s *Store) CreateEmailToken(token, username, email string, expiresAt int64) error {
+	s.emailMu.Lock()
+	defer s.emailMu.Unlock()
+
+	s.emailTokens[token] = &emailTokenEntry{
+		Username:  username,
+		Email:     email,
+		ExpiresAt: expiresAt,
+	}
+	return nil
+}
+
+// GetEmailToken retrieves an email verification token. Returns username,
+// email, expiresAt, used; username is empty if not found.
+func (s *Store) GetEmailToken(token string) (username, email string, expiresAt int64, used bool, err error) {
+	s.emailMu.Lock()
+	defer s.emailMu.Unlock()
+
+	et, ok := s.emailTokens[token]
+	if !ok {
+		return "", "", 0, false, nil
+	}
+	return et.Username, et.Email, et.ExpiresAt, et.Used, nil
+}
+
+// MarkEmailTokenUsed marks an email verification token as used.
+func (s *Store) MarkEmailTokenUsed(token string) error {
+	s.emailMu.Lock()
+	defer s.emailMu.Unlock()
+
+	if et, ok := s.emailTokens[token]; ok {
+		et.Used = true
+	}
+	return nil
+}
+
 // ---------- SMS reset codes (in-memory) ----------
 
 // StoreSMSResetCode stores a reset code for SMS-based password reset.
@@ -285,7 +582,7 @@ func (s *Store) StoreSMSResetCode(id, username, code string, expiresAt int64) er
 		Username:  username,
 		Code:      code,
 		ExpiresAt: expiresAt,
-		Used:      false,
+		CreatedAt: time.Now().Unix(),
 	}
 	return nil
 }
@@ -297,19 +594,62 @@ func (s *Store) HasRecentSMSCode(username string, cooldown time.Duration) bool {
 
 	cutoff := time.Now().Add(-cooldown).Unix()
 	for _, sc := range s.smsCodes {
-		if sc.Username == username {
-			// ExpiresAt is createdAt + 10min, so createdAt = ExpiresAt - 600
-			createdAt := sc.ExpiresAt - 600
-			if createdAt > cutoff {
-				return true
-			}
+		if sc.Username == username && sc.CreatedAt > cutoff {
+			return true
 		}
 	}
 	return false
 }
 
+// StorePhoneCode stores the code confirming phone for username, replacing any earlier one.
+func (s *Store) StorePhoneCode(username, phone, code string, expiresAt int64) error {
+	s.smsMu.Lock()
+	defer s.smsMu.Unlock()
+
+	s.phoneCodes[username] = &phoneCode{
+		Phone:     phone,
+		Code:      code,
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now().Unix(),
+	}
+	return nil
+}
+
+// PhoneCodeSentWithin reports whether a phone confirmation code was sent to
+// username within the cooldown period.
+func (s *Store) PhoneCodeSentWithin(username string, cooldown time.Duration) bool {
+	s.smsMu.Lock()
+	defer s.smsMu.Unlock()
+
+	pc, ok := s.phoneCodes[username]
+	return ok && pc.CreatedAt > time.Now().Add(-cooldown).Unix()
+}
+
+// VerifyPhoneCode checks a phone confirmation code and returns the number it
+// confirms. The code is invalidated after use or after maxAttempts wrong guesses.
+func (s *Store) VerifyPhoneCode(username, code string, maxAttempts int) (string, error) {
+	s.smsMu.Lock()
+	defer s.smsMu.Unlock()
+
+	pc, ok := s.phoneCodes[username]
+	if !ok || pc.ExpiresAt <= time.Now().Unix() {
+		return "", fmt.Errorf("invalid code")
+	}
+	if pc.Code != code {
+		pc.Attempts++
+		if pc.Attempts >= maxAttempts {
+			delete(s.phoneCodes, username)
+			return "", ErrSMSCodeLocked
+		}
+		return "", fmt.Errorf("invalid code")
+	}
+	delete(s.phoneCodes, username)
+	return pc.Phone, nil
+}
+
 // VerifySMSResetCode checks if a code is valid for the given phone's user.
-func (s *Store) VerifySMSResetCode(phone, code string) (string, error) {
+// The code is invalidated after maxAttempts wrong guesses.
+func (s *Store) VerifySMSResetCode(phone, code string, maxAttempts int) (string, error) {
 	username, err := s.FindUserByPhone(phone)
 	if err != nil {
 		return "", err
@@ -323,11 +663,11 @@ func (s *Store) VerifySMSResetCode(phone, code string) (string, error) {
 
 	// Find the most recent valid (unused, unexpired) code for this user
 	var bestID string
-	var bestExpires int64
+	var bestCreated int64
 	for id, sc := range s.smsCodes {
-		if sc.Username == username && !sc.Used && sc.ExpiresAt > time.Now().Unix() && sc.ExpiresAt > bestExpires {
+		if sc.Username == username && !sc.Used && sc.ExpiresAt > time.Now().Unix() && sc.CreatedAt >= bestCreated {
 			bestID = id
-			bestExpires = sc.ExpiresAt
+			bestCreated = sc.CreatedAt
 		}
 	}
 
@@ -340,8 +680,9 @@ func (s *Store) VerifySMSResetCode(phone, code string) (string, error) {
 	// Check if code matches
 	if sc.Code != code {
 		sc.Attempts++
-		if sc.Attempts >= 3 {
-			sc.Used = true // invalidate after 3 failed attempts
+		if sc.Attempts >= maxAttempts {
+			sc.Used = true
+			return "", ErrSMSCodeLocked
 		}
 		return "", fmt.Errorf("invalid code")
 	}
@@ -353,3 +694,51 @@ func (s *Store) VerifySMSResetCode(phone, code string) (string, error) {
 	sc.Used = true
 	return username, nil
 }
+
+// ---------- Second-factor codes (in-memory) ----------
+
+// StoreOTPCode stores a one-time code for username, replacing any earlier one.
+func (s *Store) StoreOTPCode(username, purpose, code string, expiresAt int64) error {
+	s.smsMu.Lock()
+	defer s.smsMu.Unlock()
+
+	s.otpCodes[username] = &otpCode{
+		Purpose:   purpose,
+		Code:      code,
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now().Unix(),
+	}
+	return nil
+}
+
+// OTPCodeSentWithin reports whether a one-time code was sent to username
+// within the cooldown period.
+func (s *Store) OTPCodeSentWithin(username string, cooldown time.Duration) bool {
+	s.smsMu.Lock()
+	defer s.smsMu.Unlock()
+
+	oc, ok := s.otpCodes[username]
+	return ok && oc.CreatedAt > time.Now().Add(-cooldown).Unix()
+}
+
+// VerifyOTPCode checks a one-time code sent for purpose. The code is
+// invalidated after use or after maxAttempts wrong guesses (ErrSMSCodeLocked).
+func (s *Store) VerifyOTPCode(username, purpose, code string, maxAttempts int) error {
+	s.smsMu.Lock()
+	defer s.smsMu.Unlock()
+
+	oc, ok := s.otpCodes[username]
+	if !ok || oc.Purpose != purpose || oc.ExpiresAt <= time.Now().Unix() {
+		return fmt.Errorf("invalid code")
+	}
+	if oc.Code != code {
+		oc.Attempts++
+		if oc.Attempts >= maxAttempts {
+			delete(s.otpCodes, username)
+			return ErrSMSCodeLocked
+		}
+		return fmt.Errorf("invalid code")
+	}
+	delete(s.otpCodes, username)
+	return nil
+}
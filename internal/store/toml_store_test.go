@@ -0,0 +1,50 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReloadPicksUpOutsideEdits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.toml")
+	if err := os.WriteFile(path, []byte("[alice]\nrole = \"user\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The store's own writes are not reloaded.
+	if err := s.SetLanguage("alice", "nl"); err != nil {
+		t.Fatal(err)
+	}
+	if reloaded, err := s.Reload(); reloaded || err != nil {
+		t.Fatalf("Reload after own write = %v, %v", reloaded, err)
+	}
+
+	if err := os.WriteFile(path, []byte("[alice]\nrole = \"admin\"\n\n[bob]\nrole = \"staff\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if reloaded, err := s.Reload(); !reloaded || err != nil {
+		t.Fatalf("Reload after outside edit = %v, %v", reloaded, err)
+	}
+	if meta := s.GetUserMeta("alice"); meta == nil || meta.Role != "admin" || meta.Language != "" {
+		t.Errorf("alice = %+v, want the edited entry", meta)
+	}
+	if meta := s.GetUserMeta("bob"); meta == nil || meta.Role != "staff" {
+		t.Errorf("bob = %+v", meta)
+	}
+
+	// A broken edit keeps the current metadata.
+	if err := os.WriteFile(path, []byte("[alice\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Reload(); err == nil {
+		t.Fatal("expected a decode error")
+	}
+	if meta := s.GetUserMeta("alice"); meta == nil || meta.Role != "admin" {
+		t.Errorf("alice after broken edit = %+v", meta)
+	}
+}
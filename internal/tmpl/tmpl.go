@@ -0,0 +1,241 @@
+// Package tmpl is the template engine shared by webhook, SMS, password hook and
+// mail templates. All of them get the same function set; JSON bodies can be
+// rendered with automatic escaping of every action's output.
+package tmpl
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	"tinyauth-sidecar/internal/phone"
+)
+
+// now is replaced in tests.
+var now = time.Now
+
+// Funcs returns the functions available to all templates. urlquery, printf and
+// the other text/template builtins are available as well.
+func Funcs() template.FuncMap {
+	return template.FuncMap{
+		"jsonEscape": jsonEscape,
+		"json":       toJSON,
+		"raw":        func(v any) string { return fmt.Sprint(v) },
+		"dict":       dict,
+		"list":       func(v ...any) []any { return v },
+		"digitsOnly": digitsOnly,
+		"replace":    strings.ReplaceAll,
+		"lower":      strings.ToLower,
+		"upper":      strings.ToUpper,
+		"trim":       strings.TrimSpace,
+		"base64":     func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+		"base64url":  func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) },
+		"sha256":     sha256Hex,
+		"hmac":       hmacSHA256,
+		"e164":       e164,
+		"now":        func() string { return now().UTC().Format(time.RFC3339) },
+		"timestamp":  func() string { return strconv.FormatInt(now().Unix(), 10) },
+		"rfc3339":    rfc3339,
+	}
+}
+
+// escapers are the functions whose output is already JSON-safe; actions ending
+// in one of them are not escaped again in JSON mode.
+var escapers = map[string]bool{"jsonEscape": true, "json": true, "raw": true}
+
+// Check parses text without executing it, for config validation.
+func Check(text string) error {
+	_, err := template.New("check").Funcs(Funcs()).Parse(text)
+	return err
+}
+
+// Render parses and executes text with data.
+func Render(name, text string, data any) (string, error) {
+	t, err := template.New(name).Funcs(Funcs()).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	return execute(t, data)
+}
+
+// RenderJSON is Render for JSON documents: the output of every action is
+// JSON-escaped (without quotes), unless the action already ends in jsonEscape,
+// json or raw. "{{.Name}}" inside a JSON string is therefore always safe, and
+// {{json .}} / {{json (dict ...)}} produce complete JSON values.
+func RenderJSON(name, text string, data any) (string, error) {
+	t, err := template.New(name).Funcs(Funcs()).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	for _, sub := range t.Templates() {
+		if sub.Tree != nil {
+			escapeList(sub.Tree.Root)
+		}
+	}
+	return execute(t, data)
+}
+
+// RenderBody renders a request body, using RenderJSON when contentType is JSON.
+func RenderBody(name, text, contentType string, data any) (string, error) {
+	if IsJSON(contentType) {
+		return RenderJSON(name, text, data)
+	}
+	return Render(name, text, data)
+}
+
+// IsJSON reports whether contentType is application/json or application/*+json.
+func IsJSON(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mt == "application/json" || (strings.HasPrefix(mt, "application/") && strings.HasSuffix(mt, "+json"))
+}
+
+func execute(t *template.Template, data any) (string, error) {
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// escapeList appends jsonEscape to the pipeline of every output action in n.
+func escapeList(n *parse.ListNode) {
+	if n == nil {
+		return
+	}
+	for _, node := range n.Nodes {
+		switch node := node.(type) {
+		case *parse.ActionNode:
+			escapeAction(node)
+		case *parse.IfNode:
+			escapeList(node.List)
+			escapeList(node.ElseList)
+		case *parse.RangeNode:
+			escapeList(node.List)
+			escapeList(node.ElseList)
+		case *parse.WithNode:
+			escapeList(node.List)
+			escapeList(node.ElseList)
+		}
+	}
+}
+
+func escapeAction(a *parse.ActionNode) {
+	p := a.Pipe
+	if p == nil || len(p.Decl) > 0 || len(p.Cmds) == 0 {
+		return // {{$x := ...}} produces no output
+	}
+	if id, ok := p.Cmds[len(p.Cmds)-1].Args[0].(*parse.IdentifierNode); ok && escapers[id.Ident] {
+		return
+	}
+	p.Cmds = append(p.Cmds, &parse.CommandNode{
+		NodeType: parse.NodeCommand,
+		Pos:      a.Pos,
+		Args:     []parse.Node{parse.NewIdentifier("jsonEscape").SetPos(a.Pos)},
+	})
+}
+
+// jsonEscape returns v as the contents of a JSON string (without surrounding quotes).
+func jsonEscape(v any) string {
+	s, ok := v.(string)
+	if !ok {
+		s = fmt.Sprint(v)
+	}
+	b, _ := json.Marshal(s)
+	return string(b[1 : len(b)-1])
+}
+
+// toJSON encodes v as a JSON value.
+func toJSON(v any) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// dict builds a map from alternating keys and values, for use with json.
+func dict(kv ...any) (map[string]any, error) {
+	if len(kv)%2 != 0 {
+		return nil, errors.New("dict needs an even number of arguments")
+	}
+	m := make(map[string]any, len(kv)/2)
+	for i := 0; i < len(kv); i += 2 {
+		k, ok := kv[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("dict key %v is not a string", kv[i])
+		}
+		m[k] = kv[i+1]
+	}
+	return m, nil
+}
+
+// digitsOnly strips everything except digits from a string.
+func digitsOnly(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// hmacSHA256 returns the hex HMAC-SHA256 of message with key.
+func hmacSHA256(key, message string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(message))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// e164 normalizes a phone number; the optional second argument is the default
+// country calling code for national numbers, e.g. {{e164 .Phone "31"}}.
+func e164(number string, defaultCountry ...string) (string, error) {
+	cc := ""
+	if len(defaultCountry) > 0 {
+		cc = defaultCountry[0]
+	}
+	n, err := phone.NormalizeE164(number, cc)
+	if err != nil {
+		return "", fmt.Errorf("e164 %q: %w", number, err)
+	}
+	return n, nil
+}
+
+// rfc3339 formats a Unix timestamp (seconds, as string or integer) as RFC 3339 in UTC.
+func rfc3339(v any) (string, error) {
+	var sec int64
+	switch v := v.(type) {
+	case int:
+		sec = int64(v)
+	case int64:
+		sec = v
+	case string:
+		n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("rfc3339: %w", err)
+		}
+		sec = n
+	default:
+		return "", fmt.Errorf("rfc3339: unsupported type %T", v)
+	}
+	return time.Unix(sec, 0).UTC().Format(time.RFC3339), nil
+}
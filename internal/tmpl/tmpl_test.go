@@ -0,0 +1,86 @@
+package tmpl
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestRenderJSONEscapesEveryAction(t *testing.T) {
+	data := map[string]string{
+		"Username": `al"ice`,
+		"Password": "p\\w\nd",
+		"Note":     `{"x":1}`,
+	}
+	text := `{"user":"{{.Username}}","pw":"{{jsonEscape .Password}}",` +
+		`"upper":"{{.Username | upper}}",{{if .Note}}"note":"{{.Note}}",{{end}}` +
+		`"meta":{{json (dict "user" .Username "tags" (list "a" "b"))}},"raw":{{raw .Note}}}`
+
+	out, err := RenderJSON("body", text, data)
+	if err != nil {
+		t.Fatalf("RenderJSON: %v", err)
+	}
+	var got struct {
+		User  string `json:"user"`
+		PW    string `json:"pw"`
+		Upper string `json:"upper"`
+		Note  string `json:"note"`
+		Meta  struct {
+			User string   `json:"user"`
+			Tags []string `json:"tags"`
+		} `json:"meta"`
+		Raw map[string]int `json:"raw"`
+	}
+	if err := json.Unmarshal([]byte(out), &got); err != nil {
+		t.Fatalf("output is not valid JSON: %v\n%s", err, out)
+	}
+	if got.User != data["Username"] || got.PW != data["Password"] || got.Upper != `AL"ICE` ||
+		got.Note != data["Note"] || got.Meta.User != data["Username"] || len(got.Meta.Tags) != 2 || got.Raw["x"] != 1 {
+		t.Fatalf("unexpected values: %+v", got)
+	}
+}
+
+func TestRenderFuncs(t *testing.T) {
+	now = func() time.Time { return time.Unix(1700000000, 0) }
+	defer func() { now = time.Now }()
+
+	cases := map[string]string{
+		`{{e164 "06 1234 5678" "31"}}`: "+31612345678",
+		`{{e164 "0031 (6) 12345678"}}`: "+31612345678",
+		`{{urlquery "a b&c"}}`:         "a+b%26c",
+		`{{base64 "user:pw"}}`:         "dXNlcjpwdw==",
+		`{{sha256 "abc"}}`:             "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+		`{{hmac "key" "The quick brown fox jumps over the lazy dog"}}`: "f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8",
+		`{{now}} {{timestamp}}`:                 "2023-11-14T22:13:20Z 1700000000",
+		`{{rfc3339 "0"}}`:                       "1970-01-01T00:00:00Z",
+		`{{lower "ABC"}}{{digitsOnly "+31-6"}}`: "abc316",
+	}
+	for text, want := range cases {
+		got, err := Render("t", text, nil)
+		if err != nil {
+			t.Errorf("%s: %v", text, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("%s = %q, want %q", text, got, want)
+		}
+	}
+
+	if _, err := Render("t", `{{e164 "0612345678"}}`, nil); err == nil {
+		t.Errorf("expected an error for a national number without default country")
+	}
+}
+
+func TestIsJSON(t *testing.T) {
+	for ct, want := range map[string]bool{
+		"application/json":                  true,
+		"application/json; charset=utf-8":   true,
+		"application/vnd.api+json":          true,
+		"application/x-www-form-urlencoded": false,
+		"text/plain":                        false,
+	} {
+		if got := IsJSON(ct); got != want {
+			t.Errorf("IsJSON(%q) = %v, want %v", ct, got, want)
+		}
+	}
+}
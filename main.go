@@ -6,10 +6,13 @@ import (
 	"io/fs"
 	"log"
 	"net/http"
+	"os"
 	"strings"
+	"time"
 
 	"tinyauth-sidecar/internal/config"
 	"tinyauth-sidecar/internal/handler"
+	"tinyauth-sidecar/internal/httpclient"
 	"tinyauth-sidecar/internal/middleware"
 	"tinyauth-sidecar/internal/provider"
 	"tinyauth-sidecar/internal/service"
@@ -23,7 +26,14 @@ import (
 var frontendFS embed.FS
 
 func main() {
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
 	cfg := config.Load()
+	if err := httpclient.SetExtraCAFiles(cfg.ExtraCAFile); err != nil {
+		log.Fatalf("EXTRA_CA_FILE: %v", err)
+	}
 
 	st, err := store.NewStore("")
 	if err != nil {
@@ -32,30 +42,93 @@ func main() {
 	defer st.Close()
 
 	// Initialize providers
-	fileCfg := config.LoadFileConfig()
+	fileCfg, cfgErrs := config.LoadFileConfig()
+	if len(cfgErrs) > 0 && cfg.ConfigStrict {
+		log.Fatalf("config.toml has %d problem(s) and CONFIG_STRICT is set; refusing to start", len(cfgErrs))
+	}
 
 	// Apply config.toml overrides (SMTP, password policy, users settings)
 	cfg.ApplyFileConfig(fileCfg)
+	if cfg.SMSCodeLength < 4 || cfg.SMSCodeLength > 10 {
+		log.Fatalf("SMS_CODE_LENGTH (or sms.code_length) must be between 4 and 10, got %d", cfg.SMSCodeLength)
+	}
+	if cfg.SMSCodeMaxAttempts < 1 {
+		log.Fatalf("SMS_CODE_MAX_ATTEMPTS (or sms.code_max_attempts) must be at least 1, got %d", cfg.SMSCodeMaxAttempts)
+	}
 
-	passwordTargets := provider.NewPasswordTargetProvider()
+	// Password hooks: [[password_hooks]] from config.toml plus PASSWORD_TARGETS from the env
+	hookCfgs := append(fileCfg.PasswordHooks, config.LoadPasswordTargets()...)
 	var passwordHooks []provider.PasswordChangeHook
-	for _, hookCfg := range fileCfg.PasswordHooks {
-		if h := provider.NewWebhookPasswordHook(hookCfg); h != nil {
+	for _, hookCfg := range hookCfgs {
+		if h := provider.NewPasswordHook(hookCfg); h != nil {
 			passwordHooks = append(passwordHooks, h)
 		}
 	}
 
-	// SMS: config.toml takes precedence, fall back to env vars
-	smsProvider := provider.NewWebhookSMSProviderFromConfig(fileCfg.SMS)
-	if smsProvider == nil {
-		smsProvider = provider.NewWebhookSMSProvider()
+	// SMS: [[sms_providers]] plus [sms] from config.toml, which takes precedence over the env vars
+	legacySMS := provider.NewWebhookSMSProviderFromConfig(fileCfg.SMS.WebhookConfig)
+	if legacySMS == nil {
+		legacySMS = provider.NewWebhookSMSProvider()
+	}
+	smsLog, err := store.NewSMSLog(cfg.SMSLogPath)
+	if err != nil {
+		log.Fatalf("failed to open SMS log: %v", err)
 	}
+	smsRouter := provider.NewSMSRouter(fileCfg.SMSProviders, legacySMS, smsLog)
+	var smsProvider provider.SMSProvider
+	if smsRouter != nil {
+		smsProvider = smsRouter
+	}
+
+	auditSvc := service.NewAuditService("/data/audit.log")
+
+	// Lifecycle events: [[event_hooks]] from config.toml
+	var eventHooks []provider.EventHook
+	for _, hookCfg := range fileCfg.EventHooks {
+		if h := provider.NewWebhookEventHook(hookCfg); h != nil {
+			eventHooks = append(eventHooks, h)
+		}
+	}
+	events := service.NewEventBus(st, auditSvc, eventHooks...)
+	events.Start()
 
 	usersSvc := service.NewUserFileService(cfg)
-	mailSvc := service.NewMailService(cfg)
+	mailTransport, err := service.NewMailTransport(cfg, fileCfg.Email.HTTP)
+	if err != nil {
+		log.Fatalf("failed to init mail transport: %v", err)
+	}
+	mailSvc, err := service.NewMailService(cfg, mailTransport, auditSvc)
+	if err != nil {
+		log.Fatalf("failed to init mail: %v", err)
+	}
+	mailSvc.Start()
 	dockerSvc := service.NewDockerService(cfg)
-	auditSvc := service.NewAuditService("/data/audit.log")
-	accountSvc := service.NewAccountService(cfg, st, usersSvc, mailSvc, dockerSvc, passwordTargets, smsProvider, auditSvc, passwordHooks...)
+	hookSvc, err := service.NewHookDeliveryService(cfg, auditSvc, passwordHooks...)
+	if err != nil {
+		log.Fatalf("failed to init password hook delivery: %v", err)
+	}
+	hookSvc.Start()
+	// Users and roles are managed in users.txt and users.toml; watch them for
+	// user.created, user.deleted and user.role_changed, and to drop removed
+	// users from the file sync hooks.
+	service.NewUserWatcher(usersSvc, st, events, hookSvc).Start()
+	smsTemplates, err := service.NewSMSTemplates(cfg.SMSTemplatesDir, cfg.MailDefaultLocale)
+	if err != nil {
+		log.Fatalf("failed to init SMS templates: %v", err)
+	}
+	accountSvc := service.NewAccountService(cfg, st, usersSvc, mailSvc, dockerSvc, smsProvider, smsTemplates, auditSvc, hookSvc, events)
+	if err := accountSvc.NormalizeStoredPhones(); err != nil {
+		log.Printf("[phone] failed to normalize stored phone numbers: %v", err)
+	}
+
+	stepUpKey, err := service.LoadStepUpKey(cfg)
+	if err != nil {
+		log.Fatalf("failed to load step-up key: %v", err)
+	}
+	if cfg.StepUpTTLSeconds <= 0 {
+		log.Fatalf("STEP_UP_TTL_SECONDS must be positive, got %d", cfg.StepUpTTLSeconds)
+	}
+	stepUp := middleware.NewStepUp(stepUpKey, time.Duration(cfg.StepUpTTLSeconds)*time.Second)
 
 	r := gin.Default()
 
@@ -73,6 +146,11 @@ func main() {
 	resetEmailRL := middleware.PerMinute(3)
 	forgotSmsRL := middleware.PerMinute(3)
 	resetSmsRL := middleware.PerMinute(5)
+	verifyEmailRL := middleware.PerMinute(5)
+	reauthRL := middleware.PerMinute(5)
+
+	// SMS delivery reports come from gateways, not the browser: no CSRF
+	handler.NewSMSReportHandler(smsRouter).Register(r.Group("/manage/api"))
 
 	api := r.Group("/manage/api")
 	{
@@ -81,22 +159,22 @@ func main() {
 
 		// Public endpoints (no auth required)
 		public := handler.NewPublicHandler(accountSvc, cfg)
-		public.Register(api, resetEmailRL, forgotSmsRL, resetSmsRL)
+		public.Register(api, resetEmailRL, forgotSmsRL, resetSmsRL, verifyEmailRL)
 
 		// Auth check and logout (behind tinyauth middleware)
 		authed := api.Group("")
 		authed.Use(middleware.SessionMiddleware(cfg))
 
 		// Auth endpoints
-		authHandler := handler.NewAuthHandler(cfg)
+		authHandler := handler.NewAuthHandler(cfg, stepUp)
 		authHandler.Register(authed)
 
 		// Account management endpoints
-		accountHandler := handler.NewAccountHandler(accountSvc)
-		accountHandler.Register(authed)
+		accountHandler := handler.NewAccountHandler(accountSvc, stepUp)
+		accountHandler.Register(authed, reauthRL)
 
 		// Admin endpoints
-		adminHandler := handler.NewAdminHandler(cfg, mailSvc, smsProvider, usersSvc, st, dockerSvc)
+		adminHandler := handler.NewAdminHandler(cfg, mailSvc, smsRouter, smsLog, usersSvc, st, dockerSvc, hookSvc, stepUp)
 		adminHandler.Register(authed)
 	}
 
@@ -0,0 +1,283 @@
+// Package webhooksig signs and verifies webhooks using the Standard Webhooks
+// scheme (https://www.standardwebhooks.com).
+//
+// Every signed request carries three headers:
+//
+//	webhook-id:        unique message ID (the nonce), e.g. "msg_2b4c..."
+//	webhook-timestamp: Unix time in seconds when the request was signed
+//	webhook-signature: space separated list of "v1,<base64 signature>"
+//
+// The signature is HMAC-SHA256 over "<id>.<timestamp>.<body>" keyed with the
+// shared secret. Secrets are either "whsec_" followed by base64, as issued by
+// most Standard Webhooks implementations, or a plain string used as-is.
+//
+// Receivers reject requests whose timestamp is outside the tolerance window and,
+// when a NonceStore is configured, requests whose ID was already handled.
+// Senders retry a message with the same ID, so an ID only counts as handled
+// once the receiver calls Commit after processing it successfully.
+package webhooksig
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	HeaderID        = "webhook-id"
+	HeaderTimestamp = "webhook-timestamp"
+	HeaderSignature = "webhook-signature"
+
+	// DefaultTolerance is the maximum allowed clock difference between sender and receiver.
+	DefaultTolerance = 5 * time.Minute
+	// DefaultNonceTTL is how long committed message IDs are remembered. Retries
+	// carry fresh timestamps, so this must cover the sender's retry schedule
+	// rather than just the tolerance window.
+	DefaultNonceTTL = 24 * time.Hour
+
+	secretPrefix     = "whsec_"
+	signatureVersion = "v1"
+)
+
+var (
+	ErrMissingHeaders      = errors.New("webhooksig: missing signature headers")
+	ErrInvalidTimestamp    = errors.New("webhooksig: invalid timestamp")
+	ErrTimestampTooOld     = errors.New("webhooksig: timestamp too old")
+	ErrTimestampTooNew     = errors.New("webhooksig: timestamp too new")
+	ErrNoMatchingSignature = errors.New("webhooksig: no matching signature")
+	ErrReplayed            = errors.New("webhooksig: message already received")
+)
+
+// DecodeSecret returns the HMAC key for a secret. "whsec_" secrets are base64
+// decoded; anything else is used as raw bytes.
+func DecodeSecret(secret string) ([]byte, error) {
+	if secret == "" {
+		return nil, errors.New("webhooksig: empty secret")
+	}
+	if !strings.HasPrefix(secret, secretPrefix) {
+		return []byte(secret), nil
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(secret, secretPrefix))
+	if err != nil {
+		return nil, fmt.Errorf("webhooksig: invalid whsec_ secret: %w", err)
+	}
+	if len(key) == 0 {
+		return nil, errors.New("webhooksig: empty secret")
+	}
+	return key, nil
+}
+
+// NewID returns a random message ID suitable for the webhook-id header.
+func NewID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("webhooksig: read random: %v", err))
+	}
+	return "msg_" + hex.EncodeToString(b)
+}
+
+// Signer signs outgoing webhook requests.
+type Signer struct {
+	key []byte
+}
+
+// NewSigner creates a Signer for secret (see DecodeSecret).
+func NewSigner(secret string) (*Signer, error) {
+	key, err := DecodeSecret(secret)
+	if err != nil {
+		return nil, err
+	}
+	return &Signer{key: key}, nil
+}
+
+// Sign returns the webhook-signature value for a message.
+func (s *Signer) Sign(id string, ts time.Time, body []byte) string {
+	return signatureVersion + "," + base64.StdEncoding.EncodeToString(sign(s.key, id, ts.Unix(), body))
+}
+
+// SignHeaders sets webhook-id, webhook-timestamp and webhook-signature on h
+// for body, using a fresh message ID and the current time.
+func (s *Signer) SignHeaders(h http.Header, body []byte) {
+	s.SignHeadersWithID(h, NewID(), body)
+}
+
+// SignHeadersWithID is SignHeaders with a given message ID. Retries of the
+// same message should keep its ID so receivers can deduplicate them.
+func (s *Signer) SignHeadersWithID(h http.Header, id string, body []byte) {
+	now := time.Now()
+	h.Set(HeaderID, id)
+	h.Set(HeaderTimestamp, strconv.FormatInt(now.Unix(), 10))
+	h.Set(HeaderSignature, s.Sign(id, now, body))
+}
+
+func sign(key []byte, id string, ts int64, body []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s.%d.", id, ts)
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// NonceStore remembers the IDs of handled messages to reject replays.
+type NonceStore interface {
+	// Seen reports whether id was added and hasn't expired. now is the
+	// verifier's current time, used to drop expired IDs.
+	Seen(id string, now time.Time) bool
+	// Add records id as handled until expires.
+	Add(id string, expires time.Time)
+}
+
+// Verifier checks incoming webhook requests.
+type Verifier struct {
+	key []byte
+
+	// Tolerance is the allowed clock difference; DefaultTolerance when zero.
+	Tolerance time.Duration
+	// Nonces, when set, rejects messages whose webhook-id was committed.
+	Nonces NonceStore
+	// NonceTTL is how long committed IDs are kept; DefaultNonceTTL when zero.
+	NonceTTL time.Duration
+	// Now returns the current time; time.Now when nil. Useful in tests.
+	Now func() time.Time
+}
+
+// NewVerifier creates a Verifier for secret (see DecodeSecret) with an
+// in-memory NonceStore.
+func NewVerifier(secret string) (*Verifier, error) {
+	key, err := DecodeSecret(secret)
+	if err != nil {
+		return nil, err
+	}
+	return &Verifier{key: key, Nonces: NewMemoryNonceStore()}, nil
+}
+
+// Verify checks the signature headers in h against body and rejects IDs that
+// were already committed. It does not record the ID: call Commit once the
+// message has been handled, so a retry after a failure is still accepted.
+func (v *Verifier) Verify(h http.Header, body []byte) error {
+	id := h.Get(HeaderID)
+	tsStr := h.Get(HeaderTimestamp)
+	sigs := h.Get(HeaderSignature)
+	if id == "" || tsStr == "" || sigs == "" {
+		return ErrMissingHeaders
+	}
+
+	ts, err := strconv.ParseInt(tsStr, 10, 64)
+	if err != nil {
+		return ErrInvalidTimestamp
+	}
+	tolerance := v.Tolerance
+	if tolerance <= 0 {
+		tolerance = DefaultTolerance
+	}
+	now := time.Now()
+	if v.Now != nil {
+		now = v.Now()
+	}
+	sent := time.Unix(ts, 0)
+	if now.Sub(sent) > tolerance {
+		return ErrTimestampTooOld
+	}
+	if sent.Sub(now) > tolerance {
+		return ErrTimestampTooNew
+	}
+
+	expected := sign(v.key, id, ts, body)
+	matched := false
+	for _, s := range strings.Fields(sigs) {
+		version, encoded, ok := strings.Cut(s, ",")
+		if !ok || version != signatureVersion {
+			continue
+		}
+		got, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			continue
+		}
+		if hmac.Equal(got, expected) {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return ErrNoMatchingSignature
+	}
+
+	if v.Nonces != nil && v.Nonces.Seen(id, now) {
+		return ErrReplayed
+	}
+	return nil
+}
+
+// Commit records the message ID in h as handled, so later deliveries of the
+// same message fail Verify with ErrReplayed. Receivers answering those with a
+// 2xx stop the sender's retries.
+func (v *Verifier) Commit(h http.Header) {
+	id := h.Get(HeaderID)
+	if v.Nonces == nil || id == "" {
+		return
+	}
+	ttl := v.NonceTTL
+	if ttl <= 0 {
+		ttl = DefaultNonceTTL
+	}
+	now := time.Now()
+	if v.Now != nil {
+		now = v.Now()
+	}
+	v.Nonces.Add(id, now.Add(ttl))
+}
+
+// VerifyRequest reads and verifies r's body. The body is returned and also
+// restored on r so later handlers can read it again.
+func (v *Verifier) VerifyRequest(r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("webhooksig: read body: %w", err)
+	}
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err := v.Verify(r.Header, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// MemoryNonceStore is an in-memory NonceStore. Expired IDs are pruned on use.
+type MemoryNonceStore struct {
+	mu  sync.Mutex
+	ids map[string]time.Time // id -> expiry
+}
+
+// NewMemoryNonceStore creates an empty MemoryNonceStore.
+func NewMemoryNonceStore() *MemoryNonceStore {
+	return &MemoryNonceStore{ids: make(map[string]time.Time)}
+}
+
+func (m *MemoryNonceStore) Seen(id string, now time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for k, exp := range m.ids {
+		if now.After(exp) {
+			delete(m.ids, k)
+		}
+	}
+	_, ok := m.ids[id]
+	return ok
+}
+
+func (m *MemoryNonceStore) Add(id string, expires time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ids[id] = expires
+}
@@ -0,0 +1,103 @@
+package webhooksig
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Test vector from the Standard Webhooks reference implementations.
+const (
+	testSecret    = "whsec_MfKQ9r8GKYqrTwjUPD8ILPZIo2LaLaSw"
+	testID        = "msg_p5jXN8AQM9LWM0D4loKWxJek"
+	testTimestamp = 1614265330
+	testBody      = `{"test": 2432232314}`
+	testSignature = "v1,g0hM9SsE+OTPJTGt/tmIKtSyZlE3uFJELVlNIOLJ1OE="
+)
+
+func testHeaders(sig string) http.Header {
+	h := http.Header{}
+	h.Set(HeaderID, testID)
+	h.Set(HeaderTimestamp, strconv.Itoa(testTimestamp))
+	h.Set(HeaderSignature, sig)
+	return h
+}
+
+func TestSignMatchesStandardWebhooksVector(t *testing.T) {
+	s, err := NewSigner(testSecret)
+	if err != nil {
+		t.Fatalf("NewSigner: %v", err)
+	}
+	if got := s.Sign(testID, time.Unix(testTimestamp, 0), []byte(testBody)); got != testSignature {
+		t.Fatalf("expected signature %q, got %q", testSignature, got)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	v, err := NewVerifier(testSecret)
+	if err != nil {
+		t.Fatalf("NewVerifier: %v", err)
+	}
+	v.Now = func() time.Time { return time.Unix(testTimestamp+10, 0) }
+
+	if err := v.Verify(testHeaders("v1,bogus "+testSignature), []byte(testBody)); err != nil {
+		t.Fatalf("expected valid signature, got %v", err)
+	}
+	v.Commit(testHeaders(testSignature))
+	if err := v.Verify(testHeaders(testSignature), []byte(testBody)); !errors.Is(err, ErrReplayed) {
+		t.Fatalf("expected ErrReplayed for second delivery, got %v", err)
+	}
+
+	v.Nonces = nil
+	if err := v.Verify(testHeaders(testSignature), []byte(`{"test": 1}`)); !errors.Is(err, ErrNoMatchingSignature) {
+		t.Fatalf("expected ErrNoMatchingSignature for modified body, got %v", err)
+	}
+	if err := v.Verify(http.Header{}, []byte(testBody)); !errors.Is(err, ErrMissingHeaders) {
+		t.Fatalf("expected ErrMissingHeaders, got %v", err)
+	}
+
+	v.Now = func() time.Time { return time.Unix(testTimestamp, 0).Add(DefaultTolerance + time.Second) }
+	if err := v.Verify(testHeaders(testSignature), []byte(testBody)); !errors.Is(err, ErrTimestampTooOld) {
+		t.Fatalf("expected ErrTimestampTooOld, got %v", err)
+	}
+}
+
+func TestVerifyAcceptsRetryAfterFailedHandling(t *testing.T) {
+	v, err := NewVerifier(testSecret)
+	if err != nil {
+		t.Fatalf("NewVerifier: %v", err)
+	}
+	now := time.Unix(testTimestamp, 0)
+	v.Now = func() time.Time { return now }
+	s, _ := NewSigner(testSecret)
+
+	// The sender retries the same message ID with a fresh timestamp.
+	attempt := func(at time.Time) error {
+		now = at
+		h := http.Header{}
+		h.Set(HeaderID, testID)
+		h.Set(HeaderTimestamp, strconv.FormatInt(at.Unix(), 10))
+		h.Set(HeaderSignature, s.Sign(testID, at, []byte(testBody)))
+		return v.Verify(h, []byte(testBody))
+	}
+
+	// The first attempt verifies but the receiver fails before committing.
+	if err := attempt(now); err != nil {
+		t.Fatalf("first attempt: %v", err)
+	}
+	if err := attempt(now.Add(30 * time.Second)); err != nil {
+		t.Fatalf("retry after failed handling: %v", err)
+	}
+	v.Commit(testHeaders(testSignature))
+
+	// Committed IDs stay rejected beyond the tolerance window, as retries
+	// carry new timestamps.
+	if err := attempt(now.Add(time.Hour)); !errors.Is(err, ErrReplayed) {
+		t.Fatalf("expected ErrReplayed after commit, got %v", err)
+	}
+	if err := attempt(now.Add(DefaultNonceTTL + time.Hour)); err != nil {
+		t.Fatalf("expected the ID to expire after DefaultNonceTTL, got %v", err)
+	}
+}